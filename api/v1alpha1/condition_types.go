@@ -20,6 +20,35 @@ const (
 	// HealthyCondition represents the last recorded
 	// health assessment result.
 	HealthyCondition string = "Healthy"
+
+	// DeployedCondition represents the result of the last
+	// deployment.
+	DeployedCondition string = "Deployed"
+
+	// PrunedCondition represents the result of the last
+	// prune.
+	PrunedCondition string = "Pruned"
+
+	// DeployPendingCondition represents the fact that a
+	// deployment is pending until a deploy window opens.
+	DeployPendingCondition string = "DeployPending"
+
+	// ApprovalPendingCondition represents the fact that a
+	// deployment is waiting for the approval of its change set.
+	ApprovalPendingCondition string = "ApprovalPending"
+
+	// RolledBackCondition represents the fact that the
+	// latest revision failed and was rolled back to the
+	// last successful revision.
+	RolledBackCondition string = "RolledBack"
+
+	// DriftedCondition represents the result of the last
+	// drift detection.
+	DriftedCondition string = "Drifted"
+
+	// PruneBlockedCondition represents the fact that the
+	// last prune was blocked by spec.pruneSafety.
+	PruneBlockedCondition string = "PruneBlocked"
 )
 
 const (
@@ -31,10 +60,30 @@ const (
 	// pruning of the KluctlDeployment failed.
 	PruneFailedReason string = "PruneFailed"
 
+	// DeleteFailedReason represents the fact that the
+	// deletion of the KluctlDeployment's objects failed.
+	DeleteFailedReason string = "DeleteFailed"
+
 	// ValidateFailedReason represents the fact that the
 	// validate of the KluctlDeployment failed.
 	ValidateFailedReason string = "ValidateFailed"
 
+	// UnhealthyReason represents the fact that the validation
+	// succeeded but reported errors or not-ready objects.
+	UnhealthyReason string = "Unhealthy"
+
+	// DeploySucceededReason represents the fact that the
+	// kluctl deploy command succeeded.
+	DeploySucceededReason string = "DeploySucceeded"
+
+	// PruneSucceededReason represents the fact that the
+	// pruning of the KluctlDeployment succeeded.
+	PruneSucceededReason string = "PruneSucceeded"
+
+	// ValidateSucceededReason represents the fact that the
+	// validate of the KluctlDeployment succeeded.
+	ValidateSucceededReason string = "ValidateSucceeded"
+
 	// ArtifactFailedReason represents the fact that the
 	// source artifact download failed.
 	ArtifactFailedReason string = "ArtifactFailed"
@@ -49,4 +98,52 @@ const (
 	// ReconciliationSucceededReason represents the fact that
 	// the reconciliation succeeded.
 	ReconciliationSucceededReason string = "ReconciliationSucceeded"
+
+	// OutsideDeployWindowReason represents the fact that
+	// a deployment was deferred due to deploy windows or blackouts.
+	OutsideDeployWindowReason string = "OutsideDeployWindow"
+
+	// AwaitingApprovalReason represents the fact that
+	// a deployment was deferred until its change set gets approved.
+	AwaitingApprovalReason string = "AwaitingApproval"
+
+	// RolledBackReason represents the fact that the latest
+	// revision is not deployed as it was rolled back.
+	RolledBackReason string = "RolledBack"
+
+	// RollbackSucceededReason represents the fact that the
+	// last successful revision was redeployed successfully.
+	RollbackSucceededReason string = "RollbackSucceeded"
+
+	// RollbackFailedReason represents the fact that the
+	// redeployment of the last successful revision failed.
+	RollbackFailedReason string = "RollbackFailed"
+
+	// DriftDetectedReason represents the fact that objects
+	// differ from their deployed state.
+	DriftDetectedReason string = "DriftDetected"
+
+	// NoDriftDetectedReason represents the fact that all
+	// objects match their deployed state.
+	NoDriftDetectedReason string = "NoDriftDetected"
+
+	// DriftDetectionFailedReason represents the fact that
+	// the drift detection failed.
+	DriftDetectionFailedReason string = "DriftDetectionFailed"
+
+	// PruneLimitExceededReason represents the fact that a
+	// prune was blocked as it exceeded the prune safety limits.
+	PruneLimitExceededReason string = "PruneLimitExceeded"
+
+	// PruneDryRunReason represents the fact that a prune
+	// was only previewed due to spec.pruneSafety.dryRun.
+	PruneDryRunReason string = "PruneDryRun"
+
+	// GenerateSucceededReason represents the fact that a
+	// KluctlDeploymentGenerator successfully generated its KluctlDeployments.
+	GenerateSucceededReason string = "GenerateSucceeded"
+
+	// GenerateFailedReason represents the fact that a
+	// KluctlDeploymentGenerator failed to generate its KluctlDeployments.
+	GenerateFailedReason string = "GenerateFailed"
 )
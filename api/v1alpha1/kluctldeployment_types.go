@@ -37,6 +37,91 @@ const (
 	KluctlDeployPokeImages = "poke-images"
 
 	KluctlDeployRequestAnnotation = "deploy.flux.kluctl.io/requestedAt"
+
+	// KluctlApprovedHashAnnotation is used to approve the change set found in status.pendingApproval. When
+	// spec.targets is used, it contains comma separated <target>=<hash> entries instead.
+	KluctlApprovedHashAnnotation = "deploy.flux.kluctl.io/approvedHash"
+
+	// KluctlRestoreBackupAnnotation is used to request the restore of the backup with the given name
+	KluctlRestoreBackupAnnotation = "deploy.flux.kluctl.io/restoreBackup"
+
+	ApprovalAuto   = "auto"
+	ApprovalManual = "manual"
+
+	DefaultHistoryLimit = 10
+
+	DefaultArgsKey = "args.yaml"
+
+	ResultsStoreKindStatus       = "Status"
+	ResultsStoreKindSecret       = "Secret"
+	ResultsStoreKindConfigMap    = "ConfigMap"
+	DefaultResultsStoreRetention = 5
+
+	DefaultRollbackTimeout = 5 * time.Minute
+
+	CommitStatusProviderGitHub    = "github"
+	CommitStatusProviderGitLab    = "gitlab"
+	CommitStatusProviderGitea     = "gitea"
+	CommitStatusProviderBitbucket = "bitbucket"
+
+	NotificationProviderGeneric = "generic"
+	NotificationProviderSlack   = "slack"
+	NotificationProviderMSTeams = "msteams"
+	NotificationProviderMatrix  = "matrix"
+
+	NotificationSeverityInfo  = "info"
+	NotificationSeverityError = "error"
+
+	DefaultNotificationRateLimit = 5 * time.Minute
+
+	// MaxDriftedObjects is the maximum number of drifted objects stored in status
+	MaxDriftedObjects = 20
+	// MaxDriftDiffLength is the maximum length of the diff stored per drifted object
+	MaxDriftDiffLength = 1024
+
+	// KluctlDeploymentUidLabel is set on result objects and contains the UID of the owning KluctlDeployment
+	KluctlDeploymentUidLabel = "flux.kluctl.io/kluctl-deployment-uid"
+	// KluctlCommandLabel is set on result objects and contains the command that produced the result
+	KluctlCommandLabel = "flux.kluctl.io/command"
+	// CommandResultKey is the key inside result objects that contains the gzip compressed result
+	CommandResultKey = "result.yaml.gz"
+
+	// KluctlBackupOfLabel is set on backup objects and contains the name of the KluctlDeployment
+	KluctlBackupOfLabel = "flux.kluctl.io/backup-of"
+	// KluctlBackupPartsAnnotation is set on backup objects that were split into multiple parts and contains the number
+	// of parts. The backup object stores the first part, all following parts are stored in objects of the same kind
+	// with the name "<backup>-part-<i>" (starting with 1), which are owned by the backup object.
+	KluctlBackupPartsAnnotation = "flux.kluctl.io/backup-parts"
+	// BackupObjectsKey is the key inside backup objects that contains the gzip compressed multi-document YAML
+	BackupObjectsKey = "objects.yaml.gz"
+	// MaxBackupSize is the maximum size of the compressed objects stored in a single backup object or part. Larger
+	// backups are split into multiple parts.
+	MaxBackupSize          = 900 * 1024
+	BackupKindSecret       = "Secret"
+	BackupKindConfigMap    = "ConfigMap"
+	DefaultBackupRetention = 5
+)
+
+// The following constants are used as HistoryEntry.Initiator
+const (
+	// InitiatorInitial means that the project was never deployed before
+	InitiatorInitial = "Initial"
+	// InitiatorSourceChange means that the rendered objects have changed
+	InitiatorSourceChange = "SourceChange"
+	// InitiatorSpecChange means that the KluctlDeployment spec has changed
+	InitiatorSpecChange = "SpecChange"
+	// InitiatorRequest means that a deployment was requested via the deploy.flux.kluctl.io/requestedAt annotation
+	InitiatorRequest = "Request"
+	// InitiatorInterval means that spec.deployInterval has passed
+	InitiatorInterval = "Interval"
+	// InitiatorDeployWindow means that a deployment was deferred until a deploy window opened
+	InitiatorDeployWindow = "DeployWindow"
+	// InitiatorApproval means that a deployment was deferred until its change set got approved
+	InitiatorApproval = "Approval"
+	// InitiatorRollback means that a failed revision was rolled back to the last successful revision
+	InitiatorRollback = "Rollback"
+	// InitiatorDriftCorrection means that drift was detected and spec.driftDetection.autoCorrect is enabled
+	InitiatorDriftCorrection = "DriftCorrection"
 )
 
 type KluctlDeploymentSpec struct {
@@ -57,6 +142,12 @@ type KluctlDeploymentSpec struct {
 	// +optional
 	Source *ProjectSource `json:"source,omitempty"`
 
+	// DependsOn may contain a meta.NamespacedObjectReference slice
+	// with references to KluctlDeployment resources that must be ready before this
+	// KluctlDeployment can be reconciled.
+	// +optional
+	DependsOn []meta.NamespacedObjectReference `json:"dependsOn,omitempty"`
+
 	// Decrypt Kubernetes secrets before applying them on the cluster.
 	// +optional
 	Decryption *Decryption `json:"decryption,omitempty"`
@@ -90,6 +181,18 @@ type KluctlDeploymentSpec struct {
 	// +kubebuilder:default:=true
 	DeployOnChanges bool `json:"deployOnChanges"`
 
+	// DeployWindows is a list of time specs in which deployments are allowed. When specified, deployments (and prunes)
+	// are deferred until the current time matches at least one of the specs. Validation is not affected.
+	// Time specs have the form "Mon-Fri 06:30-20:30 Europe/Berlin" or
+	// "2019-01-01T00:00:00+00:00-2019-01-02T12:34:56+00:00".
+	// +optional
+	DeployWindows []string `json:"deployWindows,omitempty"`
+
+	// DeployBlackouts is a list of time specs in which deployments are not allowed. Blackouts take precedence over
+	// DeployWindows. The format is the same as in DeployWindows.
+	// +optional
+	DeployBlackouts []string `json:"deployBlackouts,omitempty"`
+
 	// ValidateInterval specifies the interval at which to validate the KluctlDeployment.
 	// Validation is performed the same way as with 'kluctl validate -t <target>'.
 	// Defaults to the same value as specified in Interval.
@@ -146,6 +249,12 @@ type KluctlDeploymentSpec struct {
 	// +optional
 	Target *string `json:"target,omitempty"`
 
+	// Targets selects multiple targets of the project to be deployed. All selected targets are deployed from a single
+	// clone of the project, with results and conditions being reported per target in status.targets.
+	// Can not be used together with Target and TargetNameOverride.
+	// +optional
+	Targets *TargetSelector `json:"targets,omitempty"`
+
 	// TargetNameOverride sets or overrides the target name. This is especially useful when deployment without a target.
 	// +kubebuilder:validation:MinLength=1
 	// +kubebuilder:validation:MaxLength=63
@@ -162,6 +271,11 @@ type KluctlDeploymentSpec struct {
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Args runtime.RawExtension `json:"args,omitempty"`
 
+	// ArgsFrom specifies a list of ConfigMaps and Secrets from which dynamic target args are loaded. The args are
+	// merged in the given order, with spec.args being merged last so that inline args take precedence.
+	// +optional
+	ArgsFrom []ArgsReference `json:"argsFrom,omitempty"`
+
 	// DEPRECATED UpdateImages instructs kluctl to update dynamic images.
 	// Equivalent to using '-u' when calling kluctl.
 	// Setting this field to true is deprecated.
@@ -253,6 +367,61 @@ type KluctlDeploymentSpec struct {
 	// +kubebuilder:default:=false
 	// +optional
 	Delete bool `json:"delete,omitempty"`
+
+	// HistoryLimit specifies how many deploy and prune results are kept in status.history.
+	// Setting it to 0 disables the history.
+	// +kubebuilder:default:=10
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HistoryLimit *int `json:"historyLimit,omitempty"`
+
+	// ResultsStore specifies where the full results of deploy and prune commands are stored.
+	// +optional
+	ResultsStore *ResultsStore `json:"resultsStore,omitempty"`
+
+	// Approval specifies if deployments need to be approved. With 'manual', the controller performs a diff first
+	// and stores the resulting change set in status.pendingApproval. The deployment is only performed when the
+	// deploy.flux.kluctl.io/approvedHash annotation matches the hash of the change set.
+	// +kubebuilder:default:=auto
+	// +kubebuilder:validation:Enum=auto;manual
+	// +optional
+	Approval string `json:"approval,omitempty"`
+
+	// Rollback enables automatic rollbacks to the last successful revision when a deployment or validation fails.
+	// Only git sources are supported and spec.targets must not be used.
+	// +optional
+	Rollback *Rollback `json:"rollback,omitempty"`
+
+	// CommitStatus enables reporting of deploy and validate results as commit statuses to the git hosting
+	// provider. Only git sources are supported.
+	// +optional
+	CommitStatus *CommitStatus `json:"commitStatus,omitempty"`
+
+	// Notifications specifies receivers that are notified about the outcome of deploy, prune and validate
+	// commands.
+	// +optional
+	Notifications []Notification `json:"notifications,omitempty"`
+
+	// DriftDetection enables periodic detection of objects that were changed or deleted in the cluster since they
+	// were deployed.
+	// +optional
+	DriftDetection *DriftDetection `json:"driftDetection,omitempty"`
+
+	// PruneSafety specifies limits that protect against accidental mass deletions when spec.prune is enabled.
+	// +optional
+	PruneSafety *PruneSafety `json:"pruneSafety,omitempty"`
+
+	// Backup enables backups of the live objects before they are pruned or deleted.
+	// +optional
+	Backup *Backup `json:"backup,omitempty"`
+}
+
+// GetHistoryLimit returns the history limit
+func (in KluctlDeploymentSpec) GetHistoryLimit() int {
+	if in.HistoryLimit != nil {
+		return *in.HistoryLimit
+	}
+	return DefaultHistoryLimit
 }
 
 // GetRetryInterval returns the retry interval
@@ -263,10 +432,217 @@ func (in KluctlDeploymentSpec) GetRetryInterval() time.Duration {
 	return in.Interval.Duration
 }
 
+// GetResultsStoreKind returns the kind of objects used to store command results
+func (in KluctlDeploymentSpec) GetResultsStoreKind() string {
+	if in.ResultsStore != nil && in.ResultsStore.Kind != "" {
+		return in.ResultsStore.Kind
+	}
+	return ResultsStoreKindStatus
+}
+
+// GetResultsStoreRetention returns the number of result objects to keep
+func (in KluctlDeploymentSpec) GetResultsStoreRetention() int {
+	if in.ResultsStore != nil && in.ResultsStore.Retention != nil {
+		return *in.ResultsStore.Retention
+	}
+	return DefaultResultsStoreRetention
+}
+
+// GetBackupKind returns the kind of objects used to store backups
+func (in KluctlDeploymentSpec) GetBackupKind() string {
+	if in.Backup != nil && in.Backup.Kind != "" {
+		return in.Backup.Kind
+	}
+	return BackupKindSecret
+}
+
+// GetBackupRetention returns the number of backup objects to keep
+func (in KluctlDeploymentSpec) GetBackupRetention() int {
+	if in.Backup != nil && in.Backup.Retention != nil {
+		return *in.Backup.Retention
+	}
+	return DefaultBackupRetention
+}
+
+// ResultsStore specifies where full command results are stored.
+type ResultsStore struct {
+	// Kind specifies the kind of object used to store command results. With Status, the results are stored
+	// in status.lastDeployResult and status.lastPruneResult. With Secret or ConfigMap, the results are stored
+	// compressed in owned objects, and the status only contains a reference and a summary.
+	// +kubebuilder:default:=Status
+	// +kubebuilder:validation:Enum=Status;Secret;ConfigMap
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Retention specifies how many result objects are kept. Older objects are garbage-collected, while the objects
+	// referenced from the status are always kept.
+	// +kubebuilder:default:=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Retention *int `json:"retention,omitempty"`
+}
+
+// Rollback specifies when failed revisions are rolled back.
+type Rollback struct {
+	// OnDeployFailure enables rollbacks after failed deployments.
+	// +kubebuilder:default:=true
+	// +optional
+	OnDeployFailure bool `json:"onDeployFailure"`
+
+	// OnValidateFailure enables rollbacks when the validation of a deployed revision still fails after Timeout
+	// has passed since the deployment. Requires spec.validate to be enabled.
+	// +kubebuilder:default:=true
+	// +optional
+	OnValidateFailure bool `json:"onValidateFailure"`
+
+	// Timeout specifies how long the validation of a newly deployed revision may fail before it is rolled back.
+	// +kubebuilder:default:="5m"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// GetTimeout returns the validation timeout
+func (in Rollback) GetTimeout() time.Duration {
+	if in.Timeout != nil {
+		return in.Timeout.Duration
+	}
+	return DefaultRollbackTimeout
+}
+
+// CommitStatus specifies how commit statuses are reported
+type CommitStatus struct {
+	// Provider specifies the git hosting provider.
+	// +kubebuilder:validation:Enum=github;gitlab;gitea;bitbucket
+	// +required
+	Provider string `json:"provider"`
+
+	// Address specifies the base URL of the provider API. If omitted, it is derived from the host of the
+	// source url, e.g. https://api.github.com for github.com.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// SecretRef references a Secret in the same namespace that contains the credentials. For github, gitlab
+	// and gitea, the key 'token' must contain an access token. For bitbucket, the keys 'username' and
+	// 'password' must contain the username and an app password.
+	// +required
+	SecretRef meta.LocalObjectReference `json:"secretRef"`
+}
+
+// Notification specifies a receiver of notifications
+type Notification struct {
+	// Provider specifies the kind of the receiver.
+	// +kubebuilder:validation:Enum=generic;slack;msteams;matrix
+	// +required
+	Provider string `json:"provider"`
+
+	// Address specifies the webhook URL for generic, slack and msteams, or the homeserver URL for matrix.
+	// It can also be specified via the 'address' key of the referenced Secret, which takes precedence.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Channel overrides the channel of slack webhooks and specifies the room ID for matrix.
+	// +optional
+	Channel string `json:"channel,omitempty"`
+
+	// SecretRef references a Secret in the same namespace. The key 'address' overrides Address and the key
+	// 'token' is sent as bearer token. A token is required for matrix.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Severity specifies the minimum severity of sent notifications. With 'error', only failures are sent.
+	// +kubebuilder:default:=info
+	// +kubebuilder:validation:Enum=info;error
+	// +optional
+	Severity string `json:"severity,omitempty"`
+
+	// RateLimit specifies the interval in which identical notifications are only sent once. Notifications are
+	// identical when the command, severity, revision and message are equal. Setting it to 0 disables rate
+	// limiting.
+	// +kubebuilder:default:="5m"
+	// +optional
+	RateLimit *metav1.Duration `json:"rateLimit,omitempty"`
+}
+
+// GetRateLimit returns the rate limit interval
+func (in Notification) GetRateLimit() time.Duration {
+	if in.RateLimit != nil {
+		return in.RateLimit.Duration
+	}
+	return DefaultNotificationRateLimit
+}
+
+// DriftDetection specifies how drift is detected
+type DriftDetection struct {
+	// Interval specifies the interval at which drift detection is performed. Defaults to spec.interval.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// AutoCorrect enables a deployment when drift is detected, so that drifted objects are corrected.
+	// Without it, drift is only reported.
+	// +optional
+	AutoCorrect bool `json:"autoCorrect,omitempty"`
+}
+
+// Backup specifies how objects are backed up before they are pruned or deleted.
+type Backup struct {
+	// Kind specifies the kind of object used to store backups. Backups that contain Secrets are always stored
+	// in a Secret.
+	// +kubebuilder:default:=Secret
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Retention specifies how many backup objects are kept. Older objects are garbage-collected.
+	// +kubebuilder:default:=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Retention *int `json:"retention,omitempty"`
+}
+
+// PruneSafety specifies limits for pruning. When a limit is exceeded, the prune is blocked and the PruneBlocked
+// condition lists the objects that would have been deleted.
+type PruneSafety struct {
+	// MaxDeletions is the maximum number of objects that a single prune may delete.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxDeletions *int `json:"maxDeletions,omitempty"`
+
+	// MaxDeletionPercentage is the maximum percentage of all objects (rendered and orphaned) that a single prune
+	// may delete.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MaxDeletionPercentage *int `json:"maxDeletionPercentage,omitempty"`
+
+	// DenyKinds specifies kinds that are never pruned. Entries are either a kind or a kind and group separated
+	// by a dot, e.g. 'Namespace' or 'CustomResourceDefinition.apiextensions.k8s.io'. Orphan objects of such a
+	// kind are excluded from the prune and reported as warnings, while all other orphans are still pruned.
+	// +optional
+	DenyKinds []string `json:"denyKinds,omitempty"`
+
+	// DryRun disables all deletions. The objects that would have been deleted are listed in the PruneBlocked
+	// condition instead.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
 type ProjectSource struct {
 	// Url specifies the Git url where the project source is located
-	// +required
-	URL string `json:"url"`
+	// Exactly one of Url, Bucket or Oci must be specified.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Bucket specifies a Flux Bucket object whose artifact is used as the project source.
+	// Exactly one of Url, Bucket or Oci must be specified.
+	// +optional
+	Bucket *meta.NamespacedObjectReference `json:"bucket,omitempty"`
+
+	// Oci specifies an OCI artifact that contains the project source.
+	// Exactly one of Url, Bucket or Oci must be specified.
+	// +optional
+	Oci *OciSource `json:"oci,omitempty"`
 
 	// Ref specifies the branch, tag or commit that should be used. If omitted, the default branch of the repo is used.
 	// +optional
@@ -286,6 +662,43 @@ type ProjectSource struct {
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
 }
 
+// OciSource specifies an OCI artifact that contains the project source.
+type OciSource struct {
+	// Repository is the OCI repository where the artifact is located, e.g. "ghcr.io/my-org/my-project".
+	// The "oci://" prefix is optional.
+	// +required
+	Repository string `json:"repository"`
+
+	// Ref specifies the tag, digest or semver constraint to use. If omitted, the "latest" tag is used.
+	// +optional
+	Ref *OciRef `json:"ref,omitempty"`
+
+	// SecretRef specifies a Secret containing a ".dockerconfigjson" field, which is used to authenticate against
+	// the registry.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Insecure allows connecting to registries via plain HTTP.
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// OciRef specifies which OCI artifact to pull from a repository.
+type OciRef struct {
+	// Tag to pull.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// SemVer specifies a semver constraint which is resolved against the tags of the repository. The highest
+	// matching tag is pulled. Takes precedence over Tag.
+	// +optional
+	SemVer string `json:"semver,omitempty"`
+
+	// Digest to pull, takes precedence over all other fields.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
 // Decryption defines how decryption is handled for Kubernetes manifests.
 type Decryption struct {
 	// Provider is the name of the decryption engine.
@@ -319,6 +732,50 @@ type HelmCredentials struct {
 	SecretRef meta.LocalObjectReference `json:"secretRef,omitempty"`
 }
 
+// TargetSelector selects targets of a Kluctl project. Targets that match any of the fields are selected.
+type TargetSelector struct {
+	// Names is a list of target names. All names must exist in the Kluctl project.
+	// +optional
+	Names []string `json:"names,omitempty"`
+
+	// NamePattern is a regular expression that must match the whole target name.
+	// +optional
+	NamePattern string `json:"namePattern,omitempty"`
+}
+
+// ArgsReference references a ConfigMap or Secret from which dynamic target args are loaded.
+type ArgsReference struct {
+	// Kind of the referent, either ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	// +required
+	Kind string `json:"kind"`
+
+	// Name of the referent. It must reside in the same namespace as the KluctlDeployment.
+	// +required
+	Name string `json:"name"`
+
+	// Key specifies the data key to use. Defaults to 'args.yaml'.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// TargetPath specifies a dot separated path at which the value of Key is set as a string arg. If omitted,
+	// the value of Key must be a YAML dictionary which is then merged into the args.
+	// +optional
+	TargetPath string `json:"targetPath,omitempty"`
+
+	// Optional marks this reference as optional. Missing objects and keys are then ignored.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// GetKey returns the data key to use
+func (in ArgsReference) GetKey() string {
+	if in.Key != "" {
+		return in.Key
+	}
+	return DefaultArgsKey
+}
+
 // KubeConfig references a Kubernetes secret that contains a kubeconfig file.
 type KubeConfig struct {
 	// SecretRef holds the name of a secret that contains a key with
@@ -363,6 +820,21 @@ type KluctlDeploymentStatus struct {
 	// +optional
 	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
 
+	// ResolvedRef is the git ref that was resolved from spec.source.ref in the last reconciliation attempt.
+	// This is especially useful when spec.source.ref contains patterns or semver constraints.
+	// +optional
+	ResolvedRef string `json:"resolvedRef,omitempty"`
+
+	// ResolvedSemVer contains the semver constraint from spec.source.ref.semver and the tag that was resolved from it
+	// in the last reconciliation attempt.
+	// +optional
+	ResolvedSemVer *ResolvedSemVer `json:"resolvedSemVer,omitempty"`
+
+	// LastInputsHash is a hash of the inputs (resolved commit, spec, args and referenced secrets) of the last
+	// successful reconciliation. It is used to skip loading the project when nothing has changed.
+	// +optional
+	LastInputsHash string `json:"lastInputsHash,omitempty"`
+
 	// LastDeployResult is the result of the last deploy command
 	// +optional
 	LastDeployResult *LastCommandResult `json:"lastDeployResult,omitempty"`
@@ -383,12 +855,98 @@ type KluctlDeploymentStatus struct {
 	// +optional
 	RawTarget *string `json:"rawTarget,omitempty"`
 
+	// PendingApproval contains the change set that must be approved before the next deployment is performed.
+	// Only used when spec.approval is 'manual'.
+	// +optional
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
+
+	// LastSuccessfulRevision is the last git revision that was deployed (and validated if spec.validate is
+	// enabled) successfully. It is the revision used for rollbacks.
+	// +optional
+	LastSuccessfulRevision *SuccessfulRevision `json:"lastSuccessfulRevision,omitempty"`
+
+	// Rollback is set while the latest revision is rolled back to LastSuccessfulRevision. It is cleared as soon as
+	// a new revision succeeds.
+	// +optional
+	Rollback *RollbackStatus `json:"rollback,omitempty"`
+
+	// LastDriftDetectionResult is the result of the last drift detection. Only used when spec.driftDetection is
+	// set.
+	// +optional
+	LastDriftDetectionResult *DriftDetectionResult `json:"lastDriftDetectionResult,omitempty"`
+
+	// Targets contains the per-target status when spec.targets is used.
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+
+	// History contains compact entries of the last deploy and prune results, with the oldest entry first.
+	// The number of entries is limited by spec.historyLimit.
+	// +optional
+	History []HistoryEntry `json:"history,omitempty"`
+
+	// Backups references the backups of pruned objects, with the newest backup first. Backups of objects deleted
+	// by the finalizer are not listed, as they are created while the KluctlDeployment is deleted.
+	// The number of entries is limited by spec.backup.retention.
+	// +optional
+	Backups []BackupRef `json:"backups,omitempty"`
+
+	// LastHandledRestore is the value of the deploy.flux.kluctl.io/restoreBackup annotation that was last handled.
+	// +optional
+	LastHandledRestore string `json:"lastHandledRestore,omitempty"`
+
+	// LastRestoreResult is the result of the last restore of a backup.
+	// +optional
+	LastRestoreResult *RestoreResult `json:"lastRestoreResult,omitempty"`
+
 	// ReadyForMigration is used to signal the new controller that this object is handled by a legacy controller version
 	// that will honor the existence of KluctlDeployment objects from the gitops.kluctl.io group.
 	// +optional
 	ReadyForMigration *bool `json:"readyForMigration,omitempty"`
 }
 
+// SuccessfulRevision describes a git revision that was deployed successfully
+type SuccessfulRevision struct {
+	// Revision is the source revision, consisting of the resolved ref and the commit
+	// +required
+	Revision string `json:"revision"`
+
+	// Ref is the git ref that was resolved from spec.source.ref
+	// +optional
+	Ref string `json:"ref,omitempty"`
+
+	// Commit is the git commit that was checked out
+	// +required
+	Commit string `json:"commit"`
+}
+
+// RollbackStatus describes an active rollback
+type RollbackStatus struct {
+	// FailedRevision is the source revision that failed and was rolled back
+	// +required
+	FailedRevision string `json:"failedRevision"`
+
+	// FailedCommit is the git commit of FailedRevision
+	// +required
+	FailedCommit string `json:"failedCommit"`
+
+	// FailedGeneration is the generation of the KluctlDeployment when the rollback happened. Changing the spec
+	// retries the failed revision.
+	// +optional
+	FailedGeneration int64 `json:"failedGeneration,omitempty"`
+
+	// Reason describes why the rollback happened
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Revision is the source revision that was rolled back to
+	// +required
+	Revision string `json:"revision"`
+
+	// RolledBackAt is the time when the rollback happened
+	// +required
+	RolledBackAt metav1.Time `json:"time"`
+}
+
 type ReconcileResultBase struct {
 	// AttemptedAt is the time when the attempt was performed
 	// +required
@@ -413,13 +971,110 @@ type ReconcileResultBase struct {
 type LastCommandResult struct {
 	ReconcileResultBase `json:",inline"`
 
+	// RawResult contains the full command result in YAML format. It is only set when spec.resultsStore.kind
+	// is Status.
 	// +optional
 	RawResult *string `json:"rawResult,omitempty"`
 
+	// ResultRef references the object that contains the full command result. It is only set when
+	// spec.resultsStore.kind is Secret or ConfigMap.
+	// +optional
+	ResultRef *ResultRef `json:"resultRef,omitempty"`
+
+	// Summary contains the object and error counts of the command result
+	// +optional
+	Summary *CommandSummary `json:"summary,omitempty"`
+
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ResultRef references an object in the same namespace that contains a full command result
+type ResultRef struct {
+	// Kind is the kind of the referenced object, either Secret or ConfigMap
+	// +required
+	Kind string `json:"kind"`
+
+	// Name is the name of the referenced object
+	// +required
+	Name string `json:"name"`
+}
+
+// BackupRef references a backup object in the same namespace
+type BackupRef struct {
+	ResultRef `json:",inline"`
+
+	// Command is the command that triggered the backup
+	// +required
+	Command string `json:"command"`
+
+	// Target is the target of the backed up objects
+	// +optional
+	Target *string `json:"target,omitempty"`
+
+	// Revision is the source revision that was reconciled when the backup was created
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Objects is the number of backed up objects
+	// +required
+	Objects int `json:"objects"`
+
+	// CreatedAt is the time when the backup was created
+	// +required
+	CreatedAt metav1.Time `json:"createdAt"`
+}
+
+// RestoreResult contains the result of restoring a backup
+type RestoreResult struct {
+	// Backup is the name of the restored backup object
+	// +required
+	Backup string `json:"backup"`
+
+	// RestoredAt is the time when the restore was performed
+	// +required
+	RestoredAt metav1.Time `json:"restoredAt"`
+
+	// RestoredObjects is the number of objects that were applied successfully
+	// +optional
+	RestoredObjects int `json:"restoredObjects,omitempty"`
+
+	// Error contains the error(s) of the restore, if any
 	// +optional
 	Error string `json:"error,omitempty"`
 }
 
+// DriftDetectionResult contains the result of a drift detection
+type DriftDetectionResult struct {
+	ReconcileResultBase `json:",inline"`
+
+	// DriftedObjects contains the drifted objects, limited to MaxDriftedObjects entries
+	// +optional
+	DriftedObjects []DriftedObject `json:"driftedObjects,omitempty"`
+
+	// TotalDriftedObjects is the number of drifted objects, including the ones omitted from DriftedObjects
+	// +optional
+	TotalDriftedObjects int `json:"totalDriftedObjects,omitempty"`
+
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// DriftedObject describes an object that differs from its deployed state
+type DriftedObject struct {
+	// Ref is the reference of the object, consisting of the namespace, kind and name
+	// +required
+	Ref string `json:"ref"`
+
+	// Deleted is true if the object does not exist in the cluster anymore
+	// +optional
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Diff is a shortened unified diff of the drifted fields
+	// +optional
+	Diff string `json:"diff,omitempty"`
+}
+
 type LastValidateResult struct {
 	ReconcileResultBase `json:",inline"`
 
@@ -430,6 +1085,201 @@ type LastValidateResult struct {
 	Error string `json:"error"`
 }
 
+// ResolvedSemVer contains a semver constraint and the tag that was resolved from it
+type ResolvedSemVer struct {
+	// Constraint is the semver constraint
+	// +required
+	Constraint string `json:"constraint"`
+
+	// Tag is the tag with the highest version matching the constraint
+	// +required
+	Tag string `json:"tag"`
+}
+
+// TargetStatus contains the status of a single target selected by spec.targets
+type TargetStatus struct {
+	// Name is the name of the target
+	// +required
+	Name string `json:"name"`
+
+	// Deselected is true if the target is not selected by spec.targets anymore. The entry is kept until the objects
+	// of the target were pruned, or until the KluctlDeployment is deleted if spec.prune is disabled.
+	// +optional
+	Deselected bool `json:"deselected,omitempty"`
+
+	// Conditions contains the conditions of the target, e.g. Ready, Deployed, Pruned and Healthy
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Discriminator is the discriminator found in the target when the last deployment was done.
+	// +optional
+	Discriminator string `json:"discriminator,omitempty"`
+
+	// +optional
+	RawTarget *string `json:"rawTarget,omitempty"`
+
+	// LastDeployResult is the result of the last deploy command of this target
+	// +optional
+	LastDeployResult *LastCommandResult `json:"lastDeployResult,omitempty"`
+
+	// LastPruneResult is the result of the last prune command of this target
+	// +optional
+	LastPruneResult *LastCommandResult `json:"lastPruneResult,omitempty"`
+
+	// LastValidateResult is the result of the last validate command of this target
+	// +optional
+	LastValidateResult *LastValidateResult `json:"lastValidateResult,omitempty"`
+
+	// PendingApproval contains the change set of this target that must be approved.
+	// +optional
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
+
+	// LastDriftDetectionResult is the result of the last drift detection of this target
+	// +optional
+	LastDriftDetectionResult *DriftDetectionResult `json:"lastDriftDetectionResult,omitempty"`
+}
+
+// GetTargetStatus returns the status of the given target or nil if it does not exist
+func (d *KluctlDeploymentStatus) GetTargetStatus(name string) *TargetStatus {
+	for i := range d.Targets {
+		if d.Targets[i].Name == name {
+			return &d.Targets[i]
+		}
+	}
+	return nil
+}
+
+// PendingApproval describes a change set that is waiting for approval
+type PendingApproval struct {
+	// Hash is the hash of the change set. The deploy.flux.kluctl.io/approvedHash annotation must be set to this
+	// value to approve the deployment.
+	// +required
+	Hash string `json:"hash"`
+
+	// DetectedAt is the time when the change set was first detected
+	// +required
+	DetectedAt metav1.Time `json:"time"`
+
+	// Revision is the source revision that produced the change set
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// ObjectsHash is the hash of all rendered objects
+	// +optional
+	ObjectsHash string `json:"objectsHash,omitempty"`
+
+	// Summary contains the object counts of the change set
+	// +optional
+	Summary *CommandSummary `json:"summary,omitempty"`
+
+	// RawChanges contains the changed objects and their diffs in YAML format. Secret values are obfuscated.
+	// +optional
+	RawChanges *string `json:"rawChanges,omitempty"`
+}
+
+// HistoryEntry is a compact summary of a single deploy or prune command
+type HistoryEntry struct {
+	// Target is the name of the target the command was executed for
+	// +optional
+	Target string `json:"target,omitempty"`
+
+	// Command is the command that was executed, either "deploy", "poke-images" or "prune"
+	// +required
+	Command string `json:"command"`
+
+	// AttemptedAt is the time when the command was started
+	// +required
+	AttemptedAt metav1.Time `json:"time"`
+
+	// Duration is the time it took to execute the command
+	// +optional
+	Duration metav1.Duration `json:"duration,omitempty"`
+
+	// Initiator describes what caused the command to be executed
+	// +optional
+	Initiator string `json:"initiator,omitempty"`
+
+	// Revision is the source revision that was used
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// ObjectsHash is the hash of all rendered objects
+	// +optional
+	ObjectsHash string `json:"objectsHash,omitempty"`
+
+	// Summary contains the object and error counts of the command result
+	// +optional
+	Summary *CommandSummary `json:"summary,omitempty"`
+
+	// Error is the error that caused the command to fail
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// CommandSummary contains the counts of a command result
+type CommandSummary struct {
+	// +optional
+	NewObjects int `json:"newObjects,omitempty"`
+
+	// +optional
+	ChangedObjects int `json:"changedObjects,omitempty"`
+
+	// +optional
+	OrphanObjects int `json:"orphanObjects,omitempty"`
+
+	// +optional
+	DeletedObjects int `json:"deletedObjects,omitempty"`
+
+	// +optional
+	AppliedHookObjects int `json:"appliedHookObjects,omitempty"`
+
+	// +optional
+	TotalChanges int `json:"totalChanges,omitempty"`
+
+	// +optional
+	Errors int `json:"errors,omitempty"`
+
+	// +optional
+	Warnings int `json:"warnings,omitempty"`
+}
+
+// NewCommandSummary converts the given kluctl result summary into a CommandSummary
+func NewCommandSummary(s *result.CommandResultSummary) *CommandSummary {
+	if s == nil {
+		return nil
+	}
+	return &CommandSummary{
+		NewObjects:         s.NewObjects,
+		ChangedObjects:     s.ChangedObjects,
+		OrphanObjects:      s.OrphanObjects,
+		DeletedObjects:     s.DeletedObjects,
+		AppliedHookObjects: s.AppliedHookObjects,
+		TotalChanges:       s.TotalChanges,
+		Errors:             len(s.Errors),
+		Warnings:           len(s.Warnings),
+	}
+}
+
+func targetName(k *KluctlDeployment) string {
+	if k.Spec.Target != nil {
+		return *k.Spec.Target
+	}
+	return ""
+}
+
+// appendHistory appends the given entry to status.history and drops the oldest entries that exceed spec.historyLimit
+func appendHistory(k *KluctlDeployment, e HistoryEntry) {
+	limit := k.Spec.GetHistoryLimit()
+	if limit <= 0 {
+		k.Status.History = nil
+		return
+	}
+	k.Status.History = append(k.Status.History, e)
+	if len(k.Status.History) > limit {
+		k.Status.History = k.Status.History[len(k.Status.History)-limit:]
+	}
+}
+
 func (r *LastCommandResult) ParseResult() *result.CommandResult {
 	if r == nil || r.RawResult == nil {
 		return nil
@@ -443,6 +1293,26 @@ func (r *LastCommandResult) ParseResult() *result.CommandResult {
 	return &ret
 }
 
+// HasResult returns true if a command result is available, either in the status or in a referenced object
+func (r *LastCommandResult) HasResult() bool {
+	return r != nil && (r.RawResult != nil || r.ResultRef != nil)
+}
+
+// ErrorCount returns the number of errors reported by the command result
+func (r *LastCommandResult) ErrorCount() int {
+	if r == nil {
+		return 0
+	}
+	if r.Summary != nil {
+		return r.Summary.Errors
+	}
+	cr := r.ParseResult()
+	if cr == nil {
+		return 0
+	}
+	return len(cr.Errors)
+}
+
 func (r *LastValidateResult) ParseResult() *result.ValidateResult {
 	if r == nil || r.RawResult == nil {
 		return nil
@@ -475,11 +1345,28 @@ func (d *KluctlDeploymentStatus) ParseRawTarget() *types.Target {
 	return &ret
 }
 
-func SetDeployResult(k *KluctlDeployment, revision string, result *result.CommandResult, objectHash string, err error) {
+func SetDeployResult(k *KluctlDeployment, revision string, result *result.CommandResult, summary *result.CommandResultSummary, objectHash string, initiator string, startTime time.Time, err error) {
 	errStr := ""
 	if err != nil {
 		errStr = err.Error()
 	}
+	cs := NewCommandSummary(summary)
+
+	command := "deploy"
+	if k.Spec.DeployMode == KluctlDeployPokeImages {
+		command = KluctlDeployPokeImages
+	}
+	appendHistory(k, HistoryEntry{
+		Target:      targetName(k),
+		Command:     command,
+		AttemptedAt: metav1.NewTime(startTime),
+		Duration:    metav1.Duration{Duration: time.Since(startTime)},
+		Initiator:   initiator,
+		Revision:    revision,
+		ObjectsHash: objectHash,
+		Summary:     cs,
+		Error:       errStr,
+	})
 
 	k.Status.LastDeployResult = &LastCommandResult{
 		ReconcileResultBase: ReconcileResultBase{
@@ -489,7 +1376,8 @@ func SetDeployResult(k *KluctlDeployment, revision string, result *result.Comman
 			TargetNameOverride: k.Spec.TargetNameOverride,
 			ObjectsHash:        objectHash,
 		},
-		Error: errStr,
+		Summary: cs,
+		Error:   errStr,
 	}
 	if result != nil {
 		raw, err := yaml.WriteYamlString(result)
@@ -499,11 +1387,24 @@ func SetDeployResult(k *KluctlDeployment, revision string, result *result.Comman
 	}
 }
 
-func SetPruneResult(k *KluctlDeployment, revision string, result *result.CommandResult, objectHash string, err error) {
+func SetPruneResult(k *KluctlDeployment, revision string, result *result.CommandResult, summary *result.CommandResultSummary, objectHash string, initiator string, startTime time.Time, err error) {
 	errStr := ""
 	if err != nil {
 		errStr = err.Error()
 	}
+	cs := NewCommandSummary(summary)
+
+	appendHistory(k, HistoryEntry{
+		Target:      targetName(k),
+		Command:     "prune",
+		AttemptedAt: metav1.NewTime(startTime),
+		Duration:    metav1.Duration{Duration: time.Since(startTime)},
+		Initiator:   initiator,
+		Revision:    revision,
+		ObjectsHash: objectHash,
+		Summary:     cs,
+		Error:       errStr,
+	})
 
 	k.Status.LastPruneResult = &LastCommandResult{
 		ReconcileResultBase: ReconcileResultBase{
@@ -513,7 +1414,8 @@ func SetPruneResult(k *KluctlDeployment, revision string, result *result.Command
 			TargetNameOverride: k.Spec.TargetNameOverride,
 			ObjectsHash:        objectHash,
 		},
-		Error: errStr,
+		Summary: cs,
+		Error:   errStr,
 	}
 	if result != nil {
 		raw, err := yaml.WriteYamlString(result)
@@ -0,0 +1,184 @@
+package v1alpha1
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+const (
+	KluctlDeploymentGeneratorKind = "KluctlDeploymentGenerator"
+
+	// KluctlDeploymentGeneratorLabel is set on all generated KluctlDeployments and contains the name of the generator
+	KluctlDeploymentGeneratorLabel = "flux.kluctl.io/generator"
+)
+
+type KluctlDeploymentGeneratorSpec struct {
+	// The interval at which remote branches and pull requests are listed.
+	// +required
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	Interval metav1.Duration `json:"interval"`
+
+	// The interval at which to retry a previously failed reconciliation.
+	// When not specified, the controller uses the Interval
+	// value to retry failures.
+	// +optional
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	RetryInterval *metav1.Duration `json:"retryInterval,omitempty"`
+
+	// Source specifies the Git repository whose branches and pull requests are listed. The url and secretRef are
+	// also used as source of all generated KluctlDeployments.
+	// +required
+	Source GeneratorSource `json:"source"`
+
+	// Branches selects the branches for which KluctlDeployments are generated.
+	// +optional
+	Branches *GeneratorBranchSelector `json:"branches,omitempty"`
+
+	// PullRequests selects the pull requests for which KluctlDeployments are generated.
+	// +optional
+	PullRequests *GeneratorPullRequestSelector `json:"pullRequests,omitempty"`
+
+	// Template is the template for generated KluctlDeployments. The placeholders ${branch}, ${slug}, ${commit},
+	// ${shortCommit} and ${pullRequest} are replaced in all string values of the template.
+	// +required
+	Template KluctlDeploymentTemplate `json:"template"`
+
+	// This flag tells the controller to suspend subsequent generator runs,
+	// it does not apply to already generated KluctlDeployments.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// GeneratorSource specifies the Git repository used by a KluctlDeploymentGenerator.
+type GeneratorSource struct {
+	// Url specifies the Git url of the repository
+	// +required
+	URL string `json:"url"`
+
+	// SecretRef specifies the Secret containing authentication credentials for
+	// the git repository. See ProjectSource.SecretRef for details.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// GeneratorBranchSelector selects branches of a Git repository.
+type GeneratorBranchSelector struct {
+	// Pattern is a regular expression that must match the whole branch name.
+	// +required
+	Pattern string `json:"pattern"`
+}
+
+// GeneratorPullRequestSelector selects pull requests of a Git repository. Pull requests are detected via the
+// refs/pull/<number>/head (GitHub, Gitea) and refs/merge-requests/<number>/head (GitLab) refs. Only pull requests
+// whose head commit is also the head of a branch in the same repository are selected, as the generated
+// KluctlDeployments deploy that branch.
+type GeneratorPullRequestSelector struct {
+	// BranchPattern is an optional regular expression that must match the whole source branch name of the
+	// pull request.
+	// +optional
+	BranchPattern string `json:"branchPattern,omitempty"`
+}
+
+// KluctlDeploymentTemplate is the template for generated KluctlDeployments.
+type KluctlDeploymentTemplate struct {
+	// Metadata contains labels and annotations that are added to the generated KluctlDeployments.
+	// +optional
+	Metadata GeneratorTemplateMetadata `json:"metadata,omitempty"`
+
+	// Spec is the spec of generated KluctlDeployments. The source url, ref and secretRef are set by the generator.
+	// +required
+	Spec KluctlDeploymentSpec `json:"spec"`
+}
+
+// GeneratorTemplateMetadata contains the labels and annotations of generated KluctlDeployments.
+type GeneratorTemplateMetadata struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GetRetryInterval returns the retry interval
+func (in KluctlDeploymentGeneratorSpec) GetRetryInterval() time.Duration {
+	if in.RetryInterval != nil {
+		return in.RetryInterval.Duration
+	}
+	return in.Interval.Duration
+}
+
+// KluctlDeploymentGeneratorStatus defines the observed state of KluctlDeploymentGenerator
+type KluctlDeploymentGeneratorStatus struct {
+	meta.ReconcileRequestStatus `json:",inline"`
+
+	// ObservedGeneration is the last reconciled generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// GeneratedDeployments contains the KluctlDeployments generated in the last reconciliation.
+	// +optional
+	GeneratedDeployments []GeneratedDeployment `json:"generatedDeployments,omitempty"`
+}
+
+// GeneratedDeployment describes a KluctlDeployment generated by a KluctlDeploymentGenerator
+type GeneratedDeployment struct {
+	// Name is the name of the generated KluctlDeployment
+	// +required
+	Name string `json:"name"`
+
+	// Branch is the branch that is deployed by the generated KluctlDeployment
+	// +required
+	Branch string `json:"branch"`
+
+	// PullRequest is the number of the pull request for which the KluctlDeployment was generated
+	// +optional
+	PullRequest string `json:"pullRequest,omitempty"`
+
+	// Commit is the head commit of the branch at the time of generation
+	// +optional
+	Commit string `json:"commit,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",description=""
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// KluctlDeploymentGenerator is the Schema for the kluctldeploymentgenerators API
+type KluctlDeploymentGenerator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KluctlDeploymentGeneratorSpec   `json:"spec,omitempty"`
+	Status KluctlDeploymentGeneratorStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the status conditions of the object.
+func (in *KluctlDeploymentGenerator) GetConditions() []metav1.Condition {
+	return in.Status.Conditions
+}
+
+// SetConditions sets the status conditions on the object.
+func (in *KluctlDeploymentGenerator) SetConditions(conditions []metav1.Condition) {
+	in.Status.Conditions = conditions
+}
+
+//+kubebuilder:object:root=true
+
+// KluctlDeploymentGeneratorList contains a list of KluctlDeploymentGenerator
+type KluctlDeploymentGeneratorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KluctlDeploymentGenerator `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KluctlDeploymentGenerator{}, &KluctlDeploymentGeneratorList{})
+}
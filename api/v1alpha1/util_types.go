@@ -51,18 +51,25 @@ func (_ DurationOrNever) OpenAPISchemaType() []string { return []string{"string"
 func (_ DurationOrNever) OpenAPISchemaFormat() string { return "" }
 
 type GitRef struct {
-	// Branch to filter for. Can also be a regex.
+	// Branch to filter for. Can also be a regex. If multiple branches match the regex, the branch with the most
+	// recent commit is used.
 	// +optional
 	Branch string `json:"branch,omitempty"`
 
-	// Branch to filter for. Can also be a regex.
+	// Tag to filter for. Can also be a regex. If multiple tags match the regex, the tag with the highest semantic
+	// version is used. If none of the matching tags is a semantic version, the tag with the most recent commit is used.
 	// +optional
 	Tag string `json:"tag,omitempty"`
 
-	// TODO
+	// SemVer specifies a semver constraint (e.g. ">=1.2.0 <2.0.0") which is resolved against the tags of the
+	// repository. The highest matching tag is checked out. Takes precedence over Branch and Tag.
+	// +optional
+	SemVer string `json:"semver,omitempty"`
+
 	// Commit SHA to check out, takes precedence over all reference fields.
+	// When specified together with Branch or Tag, the commit is looked up while cloning the given branch or tag.
 	// +optional
-	// Commit string `json:"commit,omitempty"`
+	Commit string `json:"commit,omitempty"`
 }
 
 func (r *GitRef) String() string {
@@ -27,6 +27,94 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArgsReference) DeepCopyInto(out *ArgsReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArgsReference.
+func (in *ArgsReference) DeepCopy() *ArgsReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ArgsReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Backup) DeepCopyInto(out *Backup) {
+	*out = *in
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Backup.
+func (in *Backup) DeepCopy() *Backup {
+	if in == nil {
+		return nil
+	}
+	out := new(Backup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRef) DeepCopyInto(out *BackupRef) {
+	*out = *in
+	out.ResultRef = in.ResultRef
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(string)
+		**out = **in
+	}
+	in.CreatedAt.DeepCopyInto(&out.CreatedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRef.
+func (in *BackupRef) DeepCopy() *BackupRef {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommandSummary) DeepCopyInto(out *CommandSummary) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommandSummary.
+func (in *CommandSummary) DeepCopy() *CommandSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(CommandSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommitStatus) DeepCopyInto(out *CommitStatus) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommitStatus.
+func (in *CommitStatus) DeepCopy() *CommitStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CommitStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Decryption) DeepCopyInto(out *Decryption) {
 	*out = *in
@@ -47,6 +135,62 @@ func (in *Decryption) DeepCopy() *Decryption {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftDetection) DeepCopyInto(out *DriftDetection) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftDetection.
+func (in *DriftDetection) DeepCopy() *DriftDetection {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftDetection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftDetectionResult) DeepCopyInto(out *DriftDetectionResult) {
+	*out = *in
+	in.ReconcileResultBase.DeepCopyInto(&out.ReconcileResultBase)
+	if in.DriftedObjects != nil {
+		in, out := &in.DriftedObjects, &out.DriftedObjects
+		*out = make([]DriftedObject, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftDetectionResult.
+func (in *DriftDetectionResult) DeepCopy() *DriftDetectionResult {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftDetectionResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftedObject) DeepCopyInto(out *DriftedObject) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftedObject.
+func (in *DriftedObject) DeepCopy() *DriftedObject {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftedObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DurationOrNever) DeepCopyInto(out *DurationOrNever) {
 	*out = *in
@@ -123,6 +267,100 @@ func (in *FixedImage) DeepCopy() *FixedImage {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratedDeployment) DeepCopyInto(out *GeneratedDeployment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratedDeployment.
+func (in *GeneratedDeployment) DeepCopy() *GeneratedDeployment {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratedDeployment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratorBranchSelector) DeepCopyInto(out *GeneratorBranchSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratorBranchSelector.
+func (in *GeneratorBranchSelector) DeepCopy() *GeneratorBranchSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratorBranchSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratorPullRequestSelector) DeepCopyInto(out *GeneratorPullRequestSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratorPullRequestSelector.
+func (in *GeneratorPullRequestSelector) DeepCopy() *GeneratorPullRequestSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratorPullRequestSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratorSource) DeepCopyInto(out *GeneratorSource) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratorSource.
+func (in *GeneratorSource) DeepCopy() *GeneratorSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratorSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratorTemplateMetadata) DeepCopyInto(out *GeneratorTemplateMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratorTemplateMetadata.
+func (in *GeneratorTemplateMetadata) DeepCopy() *GeneratorTemplateMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratorTemplateMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitRef) DeepCopyInto(out *GitRef) {
 	*out = *in
@@ -154,6 +392,28 @@ func (in *HelmCredentials) DeepCopy() *HelmCredentials {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HistoryEntry) DeepCopyInto(out *HistoryEntry) {
+	*out = *in
+	in.AttemptedAt.DeepCopyInto(&out.AttemptedAt)
+	out.Duration = in.Duration
+	if in.Summary != nil {
+		in, out := &in.Summary, &out.Summary
+		*out = new(CommandSummary)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HistoryEntry.
+func (in *HistoryEntry) DeepCopy() *HistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(HistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KluctlDeployment) DeepCopyInto(out *KluctlDeployment) {
 	*out = *in
@@ -182,31 +442,58 @@ func (in *KluctlDeployment) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KluctlDeploymentList) DeepCopyInto(out *KluctlDeploymentList) {
+func (in *KluctlDeploymentGenerator) DeepCopyInto(out *KluctlDeploymentGenerator) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentGenerator.
+func (in *KluctlDeploymentGenerator) DeepCopy() *KluctlDeploymentGenerator {
+	if in == nil {
+		return nil
+	}
+	out := new(KluctlDeploymentGenerator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KluctlDeploymentGenerator) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KluctlDeploymentGeneratorList) DeepCopyInto(out *KluctlDeploymentGeneratorList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]KluctlDeployment, len(*in))
+		*out = make([]KluctlDeploymentGenerator, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentList.
-func (in *KluctlDeploymentList) DeepCopy() *KluctlDeploymentList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentGeneratorList.
+func (in *KluctlDeploymentGeneratorList) DeepCopy() *KluctlDeploymentGeneratorList {
 	if in == nil {
 		return nil
 	}
-	out := new(KluctlDeploymentList)
+	out := new(KluctlDeploymentGeneratorList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *KluctlDeploymentList) DeepCopyObject() runtime.Object {
+func (in *KluctlDeploymentGeneratorList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -214,47 +501,155 @@ func (in *KluctlDeploymentList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KluctlDeploymentSpec) DeepCopyInto(out *KluctlDeploymentSpec) {
+func (in *KluctlDeploymentGeneratorSpec) DeepCopyInto(out *KluctlDeploymentGeneratorSpec) {
 	*out = *in
-	if in.SourceRef != nil {
-		in, out := &in.SourceRef, &out.SourceRef
-		*out = new(meta.NamespacedObjectKindReference)
-		**out = **in
-	}
-	if in.Source != nil {
-		in, out := &in.Source, &out.Source
-		*out = new(ProjectSource)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.Decryption != nil {
-		in, out := &in.Decryption, &out.Decryption
-		*out = new(Decryption)
-		(*in).DeepCopyInto(*out)
-	}
 	out.Interval = in.Interval
 	if in.RetryInterval != nil {
 		in, out := &in.RetryInterval, &out.RetryInterval
 		*out = new(v1.Duration)
 		**out = **in
 	}
-	if in.DeployInterval != nil {
-		in, out := &in.DeployInterval, &out.DeployInterval
-		*out = new(DurationOrNever)
+	in.Source.DeepCopyInto(&out.Source)
+	if in.Branches != nil {
+		in, out := &in.Branches, &out.Branches
+		*out = new(GeneratorBranchSelector)
 		**out = **in
 	}
-	if in.ValidateInterval != nil {
-		in, out := &in.ValidateInterval, &out.ValidateInterval
-		*out = new(DurationOrNever)
+	if in.PullRequests != nil {
+		in, out := &in.PullRequests, &out.PullRequests
+		*out = new(GeneratorPullRequestSelector)
 		**out = **in
 	}
-	if in.Timeout != nil {
-		in, out := &in.Timeout, &out.Timeout
-		*out = new(v1.Duration)
-		**out = **in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentGeneratorSpec.
+func (in *KluctlDeploymentGeneratorSpec) DeepCopy() *KluctlDeploymentGeneratorSpec {
+	if in == nil {
+		return nil
 	}
-	if in.RegistrySecrets != nil {
-		in, out := &in.RegistrySecrets, &out.RegistrySecrets
-		*out = make([]meta.LocalObjectReference, len(*in))
+	out := new(KluctlDeploymentGeneratorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KluctlDeploymentGeneratorStatus) DeepCopyInto(out *KluctlDeploymentGeneratorStatus) {
+	*out = *in
+	out.ReconcileRequestStatus = in.ReconcileRequestStatus
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.GeneratedDeployments != nil {
+		in, out := &in.GeneratedDeployments, &out.GeneratedDeployments
+		*out = make([]GeneratedDeployment, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentGeneratorStatus.
+func (in *KluctlDeploymentGeneratorStatus) DeepCopy() *KluctlDeploymentGeneratorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KluctlDeploymentGeneratorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KluctlDeploymentList) DeepCopyInto(out *KluctlDeploymentList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KluctlDeployment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentList.
+func (in *KluctlDeploymentList) DeepCopy() *KluctlDeploymentList {
+	if in == nil {
+		return nil
+	}
+	out := new(KluctlDeploymentList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KluctlDeploymentList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KluctlDeploymentSpec) DeepCopyInto(out *KluctlDeploymentSpec) {
+	*out = *in
+	if in.SourceRef != nil {
+		in, out := &in.SourceRef, &out.SourceRef
+		*out = new(meta.NamespacedObjectKindReference)
+		**out = **in
+	}
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = new(ProjectSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]meta.NamespacedObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Decryption != nil {
+		in, out := &in.Decryption, &out.Decryption
+		*out = new(Decryption)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Interval = in.Interval
+	if in.RetryInterval != nil {
+		in, out := &in.RetryInterval, &out.RetryInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DeployInterval != nil {
+		in, out := &in.DeployInterval, &out.DeployInterval
+		*out = new(DurationOrNever)
+		**out = **in
+	}
+	if in.DeployWindows != nil {
+		in, out := &in.DeployWindows, &out.DeployWindows
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeployBlackouts != nil {
+		in, out := &in.DeployBlackouts, &out.DeployBlackouts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ValidateInterval != nil {
+		in, out := &in.ValidateInterval, &out.ValidateInterval
+		*out = new(DurationOrNever)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RegistrySecrets != nil {
+		in, out := &in.RegistrySecrets, &out.RegistrySecrets
+		*out = make([]meta.LocalObjectReference, len(*in))
 		copy(*out, *in)
 	}
 	if in.HelmCredentials != nil {
@@ -277,6 +672,11 @@ func (in *KluctlDeploymentSpec) DeepCopyInto(out *KluctlDeploymentSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = new(TargetSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.TargetNameOverride != nil {
 		in, out := &in.TargetNameOverride, &out.TargetNameOverride
 		*out = new(string)
@@ -288,6 +688,11 @@ func (in *KluctlDeploymentSpec) DeepCopyInto(out *KluctlDeploymentSpec) {
 		**out = **in
 	}
 	in.Args.DeepCopyInto(&out.Args)
+	if in.ArgsFrom != nil {
+		in, out := &in.ArgsFrom, &out.ArgsFrom
+		*out = make([]ArgsReference, len(*in))
+		copy(*out, *in)
+	}
 	if in.Images != nil {
 		in, out := &in.Images, &out.Images
 		*out = make([]FixedImage, len(*in))
@@ -315,6 +720,48 @@ func (in *KluctlDeploymentSpec) DeepCopyInto(out *KluctlDeploymentSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.HistoryLimit != nil {
+		in, out := &in.HistoryLimit, &out.HistoryLimit
+		*out = new(int)
+		**out = **in
+	}
+	if in.ResultsStore != nil {
+		in, out := &in.ResultsStore, &out.ResultsStore
+		*out = new(ResultsStore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rollback != nil {
+		in, out := &in.Rollback, &out.Rollback
+		*out = new(Rollback)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CommitStatus != nil {
+		in, out := &in.CommitStatus, &out.CommitStatus
+		*out = new(CommitStatus)
+		**out = **in
+	}
+	if in.Notifications != nil {
+		in, out := &in.Notifications, &out.Notifications
+		*out = make([]Notification, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DriftDetection != nil {
+		in, out := &in.DriftDetection, &out.DriftDetection
+		*out = new(DriftDetection)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PruneSafety != nil {
+		in, out := &in.PruneSafety, &out.PruneSafety
+		*out = new(PruneSafety)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(Backup)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentSpec.
@@ -338,6 +785,11 @@ func (in *KluctlDeploymentStatus) DeepCopyInto(out *KluctlDeploymentStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ResolvedSemVer != nil {
+		in, out := &in.ResolvedSemVer, &out.ResolvedSemVer
+		*out = new(ResolvedSemVer)
+		**out = **in
+	}
 	if in.LastDeployResult != nil {
 		in, out := &in.LastDeployResult, &out.LastDeployResult
 		*out = new(LastCommandResult)
@@ -358,6 +810,52 @@ func (in *KluctlDeploymentStatus) DeepCopyInto(out *KluctlDeploymentStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.PendingApproval != nil {
+		in, out := &in.PendingApproval, &out.PendingApproval
+		*out = new(PendingApproval)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastSuccessfulRevision != nil {
+		in, out := &in.LastSuccessfulRevision, &out.LastSuccessfulRevision
+		*out = new(SuccessfulRevision)
+		**out = **in
+	}
+	if in.Rollback != nil {
+		in, out := &in.Rollback, &out.Rollback
+		*out = new(RollbackStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastDriftDetectionResult != nil {
+		in, out := &in.LastDriftDetectionResult, &out.LastDriftDetectionResult
+		*out = new(DriftDetectionResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]TargetStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]HistoryEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Backups != nil {
+		in, out := &in.Backups, &out.Backups
+		*out = make([]BackupRef, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastRestoreResult != nil {
+		in, out := &in.LastRestoreResult, &out.LastRestoreResult
+		*out = new(RestoreResult)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ReadyForMigration != nil {
 		in, out := &in.ReadyForMigration, &out.ReadyForMigration
 		*out = new(bool)
@@ -375,6 +873,23 @@ func (in *KluctlDeploymentStatus) DeepCopy() *KluctlDeploymentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KluctlDeploymentTemplate) DeepCopyInto(out *KluctlDeploymentTemplate) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KluctlDeploymentTemplate.
+func (in *KluctlDeploymentTemplate) DeepCopy() *KluctlDeploymentTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(KluctlDeploymentTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeConfig) DeepCopyInto(out *KubeConfig) {
 	*out = *in
@@ -400,6 +915,16 @@ func (in *LastCommandResult) DeepCopyInto(out *LastCommandResult) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.ResultRef != nil {
+		in, out := &in.ResultRef, &out.ResultRef
+		*out = new(ResultRef)
+		**out = **in
+	}
+	if in.Summary != nil {
+		in, out := &in.Summary, &out.Summary
+		*out = new(CommandSummary)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LastCommandResult.
@@ -433,6 +958,31 @@ func (in *LastValidateResult) DeepCopy() *LastValidateResult {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Notification) DeepCopyInto(out *Notification) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Notification.
+func (in *Notification) DeepCopy() *Notification {
+	if in == nil {
+		return nil
+	}
+	out := new(Notification)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ObjectRef) DeepCopyInto(out *ObjectRef) {
 	*out = *in
@@ -448,9 +998,85 @@ func (in *ObjectRef) DeepCopy() *ObjectRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OciRef) DeepCopyInto(out *OciRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OciRef.
+func (in *OciRef) DeepCopy() *OciRef {
+	if in == nil {
+		return nil
+	}
+	out := new(OciRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OciSource) DeepCopyInto(out *OciSource) {
+	*out = *in
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(OciRef)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OciSource.
+func (in *OciSource) DeepCopy() *OciSource {
+	if in == nil {
+		return nil
+	}
+	out := new(OciSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingApproval) DeepCopyInto(out *PendingApproval) {
+	*out = *in
+	in.DetectedAt.DeepCopyInto(&out.DetectedAt)
+	if in.Summary != nil {
+		in, out := &in.Summary, &out.Summary
+		*out = new(CommandSummary)
+		**out = **in
+	}
+	if in.RawChanges != nil {
+		in, out := &in.RawChanges, &out.RawChanges
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingApproval.
+func (in *PendingApproval) DeepCopy() *PendingApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ProjectSource) DeepCopyInto(out *ProjectSource) {
 	*out = *in
+	if in.Bucket != nil {
+		in, out := &in.Bucket, &out.Bucket
+		*out = new(meta.NamespacedObjectReference)
+		**out = **in
+	}
+	if in.Oci != nil {
+		in, out := &in.Oci, &out.Oci
+		*out = new(OciSource)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Ref != nil {
 		in, out := &in.Ref, &out.Ref
 		*out = new(GitRef)
@@ -473,6 +1099,36 @@ func (in *ProjectSource) DeepCopy() *ProjectSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PruneSafety) DeepCopyInto(out *PruneSafety) {
+	*out = *in
+	if in.MaxDeletions != nil {
+		in, out := &in.MaxDeletions, &out.MaxDeletions
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxDeletionPercentage != nil {
+		in, out := &in.MaxDeletionPercentage, &out.MaxDeletionPercentage
+		*out = new(int)
+		**out = **in
+	}
+	if in.DenyKinds != nil {
+		in, out := &in.DenyKinds, &out.DenyKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PruneSafety.
+func (in *PruneSafety) DeepCopy() *PruneSafety {
+	if in == nil {
+		return nil
+	}
+	out := new(PruneSafety)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReconcileResultBase) DeepCopyInto(out *ReconcileResultBase) {
 	*out = *in
@@ -513,3 +1169,192 @@ func (in *RenameContext) DeepCopy() *RenameContext {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResolvedSemVer) DeepCopyInto(out *ResolvedSemVer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResolvedSemVer.
+func (in *ResolvedSemVer) DeepCopy() *ResolvedSemVer {
+	if in == nil {
+		return nil
+	}
+	out := new(ResolvedSemVer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreResult) DeepCopyInto(out *RestoreResult) {
+	*out = *in
+	in.RestoredAt.DeepCopyInto(&out.RestoredAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreResult.
+func (in *RestoreResult) DeepCopy() *RestoreResult {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResultRef) DeepCopyInto(out *ResultRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResultRef.
+func (in *ResultRef) DeepCopy() *ResultRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ResultRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResultsStore) DeepCopyInto(out *ResultsStore) {
+	*out = *in
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResultsStore.
+func (in *ResultsStore) DeepCopy() *ResultsStore {
+	if in == nil {
+		return nil
+	}
+	out := new(ResultsStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Rollback) DeepCopyInto(out *Rollback) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Rollback.
+func (in *Rollback) DeepCopy() *Rollback {
+	if in == nil {
+		return nil
+	}
+	out := new(Rollback)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RollbackStatus) DeepCopyInto(out *RollbackStatus) {
+	*out = *in
+	in.RolledBackAt.DeepCopyInto(&out.RolledBackAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RollbackStatus.
+func (in *RollbackStatus) DeepCopy() *RollbackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RollbackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SuccessfulRevision) DeepCopyInto(out *SuccessfulRevision) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SuccessfulRevision.
+func (in *SuccessfulRevision) DeepCopy() *SuccessfulRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(SuccessfulRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetSelector) DeepCopyInto(out *TargetSelector) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetSelector.
+func (in *TargetSelector) DeepCopy() *TargetSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetStatus) DeepCopyInto(out *TargetStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RawTarget != nil {
+		in, out := &in.RawTarget, &out.RawTarget
+		*out = new(string)
+		**out = **in
+	}
+	if in.LastDeployResult != nil {
+		in, out := &in.LastDeployResult, &out.LastDeployResult
+		*out = new(LastCommandResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastPruneResult != nil {
+		in, out := &in.LastPruneResult, &out.LastPruneResult
+		*out = new(LastCommandResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastValidateResult != nil {
+		in, out := &in.LastValidateResult, &out.LastValidateResult
+		*out = new(LastValidateResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PendingApproval != nil {
+		in, out := &in.PendingApproval, &out.PendingApproval
+		*out = new(PendingApproval)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastDriftDetectionResult != nil {
+		in, out := &in.LastDriftDetectionResult, &out.LastDriftDetectionResult
+		*out = new(DriftDetectionResult)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetStatus.
+func (in *TargetStatus) DeepCopy() *TargetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
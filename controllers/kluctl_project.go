@@ -12,11 +12,17 @@ import (
 	"github.com/kluctl/kluctl/v2/pkg/repocache"
 	"github.com/kluctl/kluctl/v2/pkg/sops/decryptor"
 	"github.com/kluctl/kluctl/v2/pkg/types/result"
-	"github.com/kluctl/kluctl/v2/pkg/utils/uo"
 	"github.com/prometheus/client_golang/prometheus"
 	"helm.sh/helm/v3/pkg/repo"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -48,8 +54,24 @@ type preparedProject struct {
 	source *kluctlv1.ProjectSource
 
 	sourceRevision string
+	sourceCommit   string
+	resolvedRef    string
+	resolvedSemVer *kluctlv1.ResolvedSemVer
 
-	rp *repocache.GitRepoCache
+	// rolledBack is true if the project is pinned to the last successful revision due to a rollback
+	rolledBack bool
+	// rollbackDeploy forces a deployment of the last successful revision
+	rollbackDeploy bool
+
+	rp        *repocache.GitRepoCache
+	releaseRp func()
+	// workDir is shared with concurrent reconciliations of the same repository, see RepoCache
+	workDir string
+
+	commitStatusProvider commitStatusProvider
+
+	// selectedTarget is the name of the target when reconciling a target that was selected via spec.targets
+	selectedTarget string
 
 	tmpDir     string
 	repoDir    string
@@ -86,34 +108,45 @@ func prepareProject(ctx context.Context,
 
 	pp.tmpDir = tmpDir
 
-	gitSecret, err := r.getGitSecret(ctx, source, obj.GetNamespace())
-	if err != nil {
-		return nil, err
+	var gitSecret *corev1.Secret
+	if source != nil {
+		gitSecret, err = getGitSecret(ctx, r.Client, source.SecretRef, obj.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	pp.rp, err = r.buildRepoCache(ctx, gitSecret)
+	// checking out a specific commit modifies the checkout, so it can't be shared with other refs
+	sourceUrl := ""
+	variant := ""
+	if source != nil {
+		sourceUrl = source.URL
+		if source.Ref != nil && source.Ref.Commit != "" {
+			variant = source.Ref.Commit
+		}
+	}
+	if sourceUrl != "" {
+		pp.rp, pp.workDir, pp.releaseRp, err = r.RepoCache.Get(ctx, sourceUrl, gitSecret, variant)
+	} else {
+		// without a Git URL there is nothing to share, so the repo cache must not get an entry keyed on ""
+		pp.rp, pp.releaseRp, err = r.RepoCache.GetPrivate(ctx, gitSecret, filepath.Join(tmpDir, "git"))
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if source != nil {
-		gitUrl, err := types2.ParseGitUrl(source.URL)
-		if err != nil {
-			return nil, err
-		}
-		rpEntry, err := pp.rp.GetEntry(*gitUrl)
-		if err != nil {
-			return nil, fmt.Errorf("failed clone source: %w", err)
+		if source.Bucket != nil {
+			err = pp.prepareBucketSource(ctx)
+		} else if source.Oci != nil {
+			err = pp.prepareOciSource(ctx)
+		} else {
+			err = pp.prepareGitSource()
 		}
-
-		clonedDir, ci, err := rpEntry.GetClonedDir(source.Ref.String())
 		if err != nil {
 			return nil, err
 		}
 
-		pp.repoDir = clonedDir
-		pp.sourceRevision = fmt.Sprintf("%s/%s", ci.CheckedOutRef, ci.CheckedOutCommit)
-
 		// check kluctl project path exists
 		pp.projectDir, err = securejoin.SecureJoin(pp.repoDir, source.Path)
 		if err != nil {
@@ -128,11 +161,76 @@ func prepareProject(ctx context.Context,
 	return pp, nil
 }
 
+func (pp *preparedProject) prepareGitSource() error {
+	source := pp.source
+
+	gitUrl, err := types2.ParseGitUrl(source.URL)
+	if err != nil {
+		return err
+	}
+	rpEntry, err := pp.rp.GetEntry(*gitUrl)
+	if err != nil {
+		return fmt.Errorf("failed clone source: %w", err)
+	}
+
+	ref, err := resolveGitRef(source.Ref, rpEntry.GetRepoInfo().RemoteRefs, newCommitTimeGetter(rpEntry))
+	if err != nil {
+		return err
+	}
+
+	clonedDir, ci, err := rpEntry.GetClonedDir(ref)
+	if err != nil {
+		return err
+	}
+	if source.Ref != nil && source.Ref.Commit != "" {
+		commit, err := checkoutCommit(clonedDir, source.Ref.Commit)
+		if err != nil {
+			return err
+		}
+		if ref == "" {
+			ci.CheckedOutRef = "HEAD"
+		}
+		ci.CheckedOutCommit = commit
+	}
+
+	pp.repoDir = clonedDir
+	pp.resolvedRef = ci.CheckedOutRef
+	pp.sourceCommit = ci.CheckedOutCommit
+	pp.sourceRevision = fmt.Sprintf("%s/%s", ci.CheckedOutRef, ci.CheckedOutCommit)
+	if source.Ref != nil && source.Ref.SemVer != "" && source.Ref.Commit == "" {
+		pp.resolvedSemVer = &kluctlv1.ResolvedSemVer{
+			Constraint: source.Ref.SemVer,
+			Tag:        strings.TrimPrefix(ci.CheckedOutRef, "refs/tags/"),
+		}
+	}
+	return nil
+}
+
+func (pp *preparedProject) prepareBucketSource(ctx context.Context) error {
+	source, err := pp.r.getSourceObject(ctx, bucketSourceRef(pp.source.Bucket), pp.obj.GetNamespace(), pp.r.NoCrossNamespaceRefs)
+	if err != nil {
+		return err
+	}
+	artifact := source.GetArtifact()
+	if artifact == nil {
+		return fmt.Errorf("bucket '%s' has no artifact yet", pp.source.Bucket.Name)
+	}
+
+	pp.repoDir = filepath.Join(pp.tmpDir, "source")
+	err = pp.r.fetchArtifact(ctx, artifact, pp.tmpDir, pp.repoDir)
+	if err != nil {
+		return err
+	}
+	pp.sourceRevision = artifact.Revision
+	return nil
+}
+
 func (pp *preparedProject) cleanup() {
 	_ = os.RemoveAll(pp.tmpDir)
 	if pp.rp != nil {
-		pp.rp.Clear()
+		pp.releaseRp()
 		pp.rp = nil
+		pp.workDir = ""
 	}
 }
 
@@ -585,6 +683,15 @@ func (pp *preparedProject) addServiceAccountBasedKeyServers(ctx context.Context,
 	return nil
 }
 
+// withWorkDir lets Kluctl use the shared work directory for temporary files, so that Helm charts pulled while loading
+// and rendering the project are shared with concurrent reconciliations
+func (pp *preparedProject) withWorkDir(ctx context.Context) context.Context {
+	if pp.workDir == "" {
+		return ctx
+	}
+	return utils.WithTmpBaseDir(ctx, pp.workDir)
+}
+
 func (pp *preparedProject) withKluctlProject(ctx context.Context, pt *preparedTarget, cb func(p *kluctl_project.LoadedKluctlProject) error) error {
 	j2, err := kluctl_jinja2.NewKluctlJinja2(true)
 	if err != nil {
@@ -600,7 +707,7 @@ func (pp *preparedProject) withKluctlProject(ctx context.Context, pt *preparedTa
 		}
 	}
 
-	externalArgs, err := uo.FromString(string(pt.pp.obj.Spec.Args.Raw))
+	externalArgs, err := pp.r.buildArgs(ctx, pp.obj)
 	if err != nil {
 		return err
 	}
@@ -616,7 +723,7 @@ func (pp *preparedProject) withKluctlProject(ctx context.Context, pt *preparedTa
 		loadArgs.ClientConfigGetter = pt.clientConfigGetter(ctx)
 	}
 
-	p, err := kluctl_project.LoadKluctlProject(ctx, loadArgs, filepath.Join(pp.tmpDir, "project"), j2)
+	p, err := kluctl_project.LoadKluctlProject(pp.withWorkDir(ctx), loadArgs, filepath.Join(pp.tmpDir, "project"), j2)
 	if err != nil {
 		return err
 	}
@@ -626,41 +733,7 @@ func (pp *preparedProject) withKluctlProject(ctx context.Context, pt *preparedTa
 
 func (pt *preparedTarget) withKluctlProjectTarget(ctx context.Context, cb func(targetContext *kluctl_project.TargetContext) error) error {
 	return pt.pp.withKluctlProject(ctx, pt, func(p *kluctl_project.LoadedKluctlProject) error {
-		renderOutputDir, err := os.MkdirTemp(pt.pp.tmpDir, "render-")
-		if err != nil {
-			return err
-		}
-		images, err := pt.buildImages(ctx)
-		if err != nil {
-			return err
-		}
-		helmCredentials, err := pt.buildHelmCredentials(ctx)
-		if err != nil {
-			return err
-		}
-		inclusion := pt.buildInclusion()
-
-		props := kluctl_project.TargetContextParams{
-			DryRun:          pt.pp.r.DryRun || pt.pp.obj.Spec.DryRun,
-			Images:          images,
-			Inclusion:       inclusion,
-			HelmCredentials: helmCredentials,
-			RenderOutputDir: renderOutputDir,
-		}
-		if pt.pp.obj.Spec.Target != nil {
-			props.TargetName = *pt.pp.obj.Spec.Target
-		}
-		if pt.pp.obj.Spec.TargetNameOverride != nil {
-			props.TargetNameOverride = *pt.pp.obj.Spec.TargetNameOverride
-		}
-		if pt.pp.obj.Spec.Context != nil {
-			props.ContextOverride = *pt.pp.obj.Spec.Context
-		}
-		targetContext, err := p.NewTargetContext(ctx, props)
-		if err != nil {
-			return err
-		}
-		err = targetContext.DeploymentCollection.Prepare()
+		targetContext, err := pt.newTargetContext(ctx, p)
 		if err != nil {
 			return err
 		}
@@ -668,7 +741,56 @@ func (pt *preparedTarget) withKluctlProjectTarget(ctx context.Context, cb func(t
 	})
 }
 
-func (pt *preparedTarget) handleCommandResult(ctx context.Context, cmdErr error, cmdResult *result.CommandResult, commandName string) error {
+// newTargetContext renders the target referenced by spec.target of the prepared object. It is used by
+// withKluctlProjectTarget and when multiple targets are rendered from the same loaded project.
+func (pt *preparedTarget) newTargetContext(ctx context.Context, p *kluctl_project.LoadedKluctlProject) (*kluctl_project.TargetContext, error) {
+	renderOutputDir, err := os.MkdirTemp(pt.pp.tmpDir, "render-")
+	if err != nil {
+		return nil, err
+	}
+	images, err := pt.buildImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	helmCredentials, err := pt.buildHelmCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inclusion := pt.buildInclusion()
+
+	props := kluctl_project.TargetContextParams{
+		DryRun:          pt.pp.r.DryRun || pt.pp.obj.Spec.DryRun,
+		Images:          images,
+		Inclusion:       inclusion,
+		HelmCredentials: helmCredentials,
+		RenderOutputDir: renderOutputDir,
+	}
+	if pt.pp.obj.Spec.Target != nil {
+		props.TargetName = *pt.pp.obj.Spec.Target
+		if !hasTarget(p, props.TargetName) {
+			return nil, newNonRetryableError(fmt.Errorf("target %s not found in kluctl project", props.TargetName))
+		}
+	}
+	if pt.pp.obj.Spec.TargetNameOverride != nil {
+		props.TargetNameOverride = *pt.pp.obj.Spec.TargetNameOverride
+	}
+	if pt.pp.obj.Spec.Context != nil {
+		props.ContextOverride = *pt.pp.obj.Spec.Context
+	}
+	targetContext, err := p.NewTargetContext(pt.pp.withWorkDir(ctx), props)
+	if err != nil {
+		return nil, err
+	}
+	err = targetContext.DeploymentCollection.Prepare()
+	if err != nil {
+		return nil, err
+	}
+	return targetContext, nil
+}
+
+// handleCommandResult emits events and metrics for the given command result. It returns the summary of the result,
+// as the objects are removed from the result to keep the status small.
+func (pt *preparedTarget) handleCommandResult(ctx context.Context, cmdErr error, cmdResult *result.CommandResult, commandName string) (*result.CommandResultSummary, error) {
 	log := ctrl.LoggerFrom(ctx)
 
 	cmdResult.Command.Initiator = result.CommandInititiator_KluctlDeployment
@@ -679,14 +801,16 @@ func (pt *preparedTarget) handleCommandResult(ctx context.Context, cmdErr error,
 	log.Info(fmt.Sprintf("command finished with err=%v", cmdErr))
 	defer pt.exportCommandResultMetricsToProm(summary, commandName)
 	if cmdErr != nil {
-		pt.pp.r.event(ctx, pt.pp.obj, pt.pp.sourceRevision, true, fmt.Sprintf("%s failed. %s", commandName, cmdErr.Error()), nil)
-		return cmdErr
+		msg := fmt.Sprintf("%s failed. %s", commandName, cmdErr.Error())
+		pt.pp.r.event(ctx, pt.pp.obj, pt.pp.sourceRevision, true, msg, nil)
+		pt.notify(ctx, commandName, true, msg, kluctlv1.NewCommandSummary(summary))
+		return summary, cmdErr
 	}
 
 	obfuscator := diff.Obfuscator{}
 	err := obfuscator.ObfuscateResult(cmdResult)
 	if err != nil {
-		return err
+		return summary, err
 	}
 
 	msg := fmt.Sprintf("%s succeeded.", commandName)
@@ -718,11 +842,12 @@ func (pt *preparedTarget) handleCommandResult(ctx context.Context, cmdErr error,
 		err = fmt.Errorf("%s failed with %d errors", commandName, len(summary.Errors))
 	}
 	pt.pp.r.event(ctx, pt.pp.obj, pt.pp.sourceRevision, warning, msg, nil)
+	pt.notify(ctx, commandName, warning, msg, kluctlv1.NewCommandSummary(summary))
 
-	return err
+	return summary, err
 }
 
-func (pt *preparedTarget) kluctlDeploy(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, error) {
+func (pt *preparedTarget) kluctlDeploy(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, *result.CommandResultSummary, error) {
 	timer := prometheus.NewTimer(internal_metrics.NewKluctlDeploymentDuration(pt.pp.obj.ObjectMeta.Namespace, pt.pp.obj.ObjectMeta.Name, pt.pp.obj.Spec.DeployMode))
 	defer timer.ObserveDuration()
 	cmd := commands.NewDeployCommand(targetContext)
@@ -734,38 +859,71 @@ func (pt *preparedTarget) kluctlDeploy(ctx context.Context, targetContext *kluct
 	cmd.NoWait = pt.pp.obj.Spec.NoWait
 
 	cmdResult, err := cmd.Run(nil)
-	err = pt.handleCommandResult(ctx, err, cmdResult, "deploy")
-	return cmdResult, err
+	summary, err := pt.handleCommandResult(ctx, err, cmdResult, "deploy")
+	return cmdResult, summary, err
 }
 
-func (pt *preparedTarget) kluctlPokeImages(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, error) {
+func (pt *preparedTarget) kluctlPokeImages(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, *result.CommandResultSummary, error) {
 	timer := prometheus.NewTimer(internal_metrics.NewKluctlDeploymentDuration(pt.pp.obj.ObjectMeta.Namespace, pt.pp.obj.ObjectMeta.Name, pt.pp.obj.Spec.DeployMode))
 	defer timer.ObserveDuration()
 	cmd := commands.NewPokeImagesCommand(targetContext)
 
 	cmdResult, err := cmd.Run()
-	err = pt.handleCommandResult(ctx, err, cmdResult, "poke-images")
-	return cmdResult, err
+	summary, err := pt.handleCommandResult(ctx, err, cmdResult, "poke-images")
+	return cmdResult, summary, err
+}
+
+// kluctlDiff performs a diff of the full deployment. The result is returned as is, including secret values and the
+// rendered/remote objects, so the caller is responsible for obfuscation.
+func (pt *preparedTarget) kluctlDiff(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, error) {
+	cmd := commands.NewDiffCommand(targetContext)
+	cmd.ForceApply = pt.pp.obj.Spec.ForceApply
+	cmd.ReplaceOnError = pt.pp.obj.Spec.ReplaceOnError
+	cmd.ForceReplaceOnError = pt.pp.obj.Spec.ForceReplaceOnError
+
+	cmdResult, err := cmd.Run()
+	if err != nil {
+		pt.pp.r.event(ctx, pt.pp.obj, pt.pp.sourceRevision, true, fmt.Sprintf("diff failed. %s", err.Error()), nil)
+		return nil, err
+	}
+	return cmdResult, nil
 }
 
-func (pt *preparedTarget) kluctlPrune(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, error) {
+func (pt *preparedTarget) kluctlPrune(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.CommandResult, *result.CommandResultSummary, error) {
 	if !pt.pp.obj.Spec.Prune {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	timer := prometheus.NewTimer(internal_metrics.NewKluctlPruneDuration(pt.pp.obj.ObjectMeta.Namespace, pt.pp.obj.ObjectMeta.Name))
 	defer timer.ObserveDuration()
 
+	removeCondition(pt.pp.obj, kluctlv1.PruneBlockedCondition)
+	total := countRenderedObjects(targetContext)
+
 	cmd := commands.NewPruneCommand(targetContext.Target.Discriminator, targetContext, false)
-	cmdResult, err := cmd.Run(func(refs []k8s.ObjectRef) error {
+	cmdResult, err := runPrune(pt.pp.obj, cmd.Run, func(refs []k8s.ObjectRef, excluded int) error {
 		pt.printDeletedRefs(ctx, refs)
-		return nil
+		err := checkPruneSafety(pt.pp.obj, total+excluded, refs)
+		if err != nil {
+			return err
+		}
+		return pt.backupObjects(ctx, targetContext.SharedContext.K, "prune", refs, true)
+	}, func(refs []k8s.ObjectRef) error {
+		kubeContext := targetContext.Target.Context
+		if pt.pp.obj.Spec.Context != nil {
+			kubeContext = pt.pp.obj.Spec.Context
+		}
+		restConfig, _, err := pt.clientConfigGetter(ctx)(kubeContext)
+		if err != nil {
+			return err
+		}
+		return pt.deleteRefs(ctx, restConfig, refs)
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	err = pt.handleCommandResult(ctx, err, cmdResult, "prune")
-	return cmdResult, err
+	summary, err := pt.handleCommandResult(ctx, err, cmdResult, "prune")
+	return cmdResult, summary, err
 }
 
 func (pt *preparedTarget) kluctlValidate(ctx context.Context, targetContext *kluctl_project.TargetContext) (*result.ValidateResult, error) {
@@ -785,6 +943,26 @@ func (pt *preparedTarget) kluctlDelete(ctx context.Context, discriminator string
 	timer := prometheus.NewTimer(internal_metrics.NewKluctlDeleteDuration(pt.pp.obj.ObjectMeta.Namespace, pt.pp.obj.ObjectMeta.Name))
 	defer timer.ObserveDuration()
 
+	return pt.deleteObjects(ctx, discriminator, "delete", false)
+}
+
+// kluctlPruneDeselected deletes all objects of a target that is not selected by spec.targets anymore. Prune safety
+// and backups apply as for regular prunes.
+func (pt *preparedTarget) kluctlPruneDeselected(ctx context.Context, discriminator string) (*result.CommandResult, error) {
+	if !pt.pp.obj.Spec.Prune {
+		return nil, nil
+	}
+
+	timer := prometheus.NewTimer(internal_metrics.NewKluctlPruneDuration(pt.pp.obj.ObjectMeta.Namespace, pt.pp.obj.ObjectMeta.Name))
+	defer timer.ObserveDuration()
+
+	removeCondition(pt.pp.obj, kluctlv1.PruneBlockedCondition)
+	return pt.deleteObjects(ctx, discriminator, "prune", true)
+}
+
+// deleteObjects deletes all objects with the given discriminator. If the deletion is a prune, spec.pruneSafety is
+// applied as well. Failed backups are returned as backupError.
+func (pt *preparedTarget) deleteObjects(ctx context.Context, discriminator string, commandName string, prune bool) (*result.CommandResult, error) {
 	inclusion := pt.buildInclusion()
 
 	cmd := commands.NewDeleteCommand(discriminator, nil, inclusion, false)
@@ -793,26 +971,108 @@ func (pt *preparedTarget) kluctlDelete(ctx context.Context, discriminator string
 	if err != nil {
 		return nil, err
 	}
-	clientFactory, err := k8s2.NewClientFactory(ctx, restConfig)
-	if err != nil {
-		return nil, err
-	}
-	k, err := k8s2.NewK8sCluster(ctx, clientFactory, pt.pp.r.DryRun || pt.pp.obj.Spec.DryRun)
+	k, err := pt.newK8sCluster(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	cmdResult, err := cmd.Run(ctx, k, func(refs []k8s.ObjectRef) error {
+	confirm := func(refs []k8s.ObjectRef, excluded int) error {
 		pt.printDeletedRefs(ctx, refs)
+		if prune {
+			// none of the objects are rendered anymore
+			err := checkPruneSafety(pt.pp.obj, excluded, refs)
+			if err != nil {
+				return err
+			}
+		}
+		// deletions happen while the KluctlDeployment is being deleted, so the backup must not be owned by it
+		err := pt.backupObjects(ctx, k, commandName, refs, prune)
+		if err != nil {
+			return &backupError{err: err}
+		}
 		return nil
-	})
+	}
+	run := func(cb func(refs []k8s.ObjectRef) error) (*result.CommandResult, error) {
+		return cmd.Run(ctx, k, cb)
+	}
+
+	var cmdResult *result.CommandResult
+	if prune {
+		cmdResult, err = runPrune(pt.pp.obj, run, confirm, func(refs []k8s.ObjectRef) error {
+			return pt.deleteRefs(ctx, restConfig, refs)
+		})
+	} else {
+		var confirmErr error
+		cmdResult, err = run(func(refs []k8s.ObjectRef) error {
+			confirmErr = confirm(refs, 0)
+			return confirmErr
+		})
+		if confirmErr != nil {
+			// kluctl does not necessarily wrap the error returned by the callback
+			return nil, confirmErr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	err = pt.handleCommandResult(ctx, err, cmdResult, "delete")
+	_, err = pt.handleCommandResult(ctx, err, cmdResult, commandName)
 	return cmdResult, err
 }
 
+// deleteRefs deletes the given objects from the target cluster without waiting for them to disappear. It is used
+// when kluctl can not perform the deletion itself, e.g. when some objects are excluded from a prune.
+func (pt *preparedTarget) deleteRefs(ctx context.Context, restConfig *rest.Config, refs []k8s.ObjectRef) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	var opts metav1.DeleteOptions
+	if pt.pp.r.DryRun || pt.pp.obj.Spec.DryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	var errs []error
+	for _, ref := range refs {
+		var versions []string
+		if ref.Version != "" {
+			versions = append(versions, ref.Version)
+		}
+		mapping, err := mapper.RESTMapping(schema.GroupKind{Group: ref.Group, Kind: ref.Kind}, versions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", ref.String(), err))
+			continue
+		}
+		var ri dynamic.ResourceInterface = dynamicClient.Resource(mapping.Resource)
+		if mapping.Scope.Name() == apimeta.RESTScopeNameNamespace {
+			ri = dynamicClient.Resource(mapping.Resource).Namespace(ref.Namespace)
+		}
+		err = ri.Delete(ctx, ref.Name, opts)
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", ref.String(), err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// newK8sCluster creates a client for the target cluster outside of a loaded target context
+func (pt *preparedTarget) newK8sCluster(ctx context.Context) (*k8s2.K8sCluster, error) {
+	restConfig, err := pt.buildRestConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientFactory, err := k8s2.NewClientFactory(ctx, restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return k8s2.NewK8sCluster(ctx, clientFactory, pt.pp.r.DryRun || pt.pp.obj.Spec.DryRun)
+}
+
 func (pt *preparedTarget) printDeletedRefs(ctx context.Context, refs []k8s.ObjectRef) {
 	log := ctrl.LoggerFrom(ctx)
 
@@ -836,3 +1096,12 @@ func (pt *preparedTarget) exportCommandResultMetricsToProm(summary *result.Comma
 	numberOfErrors := summary.Errors
 	internal_metrics.NewKluctlNumberOfErrors(pt.pp.obj.Namespace, pt.pp.obj.Name, commandName).Set(float64(len(numberOfErrors)))
 }
+
+func hasTarget(p *kluctl_project.LoadedKluctlProject, name string) bool {
+	for _, t := range p.Targets {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	apiacl "github.com/fluxcd/pkg/apis/acl"
 	"github.com/fluxcd/pkg/apis/meta"
@@ -13,7 +14,6 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
 	internal_metrics "github.com/kluctl/kluctl/v2/pkg/controllers/metrics"
-	ssh_pool "github.com/kluctl/kluctl/v2/pkg/git/ssh-pool"
 	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
 	project "github.com/kluctl/kluctl/v2/pkg/kluctl_project"
 	"github.com/kluctl/kluctl/v2/pkg/status"
@@ -21,10 +21,12 @@ import (
 	"github.com/kluctl/kluctl/v2/pkg/utils/uo"
 	"github.com/kluctl/kluctl/v2/pkg/yaml"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	kuberecorder "k8s.io/client-go/tools/record"
@@ -33,6 +35,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -41,6 +44,7 @@ type KluctlDeploymentReconciler struct {
 	RestConfig            *rest.Config
 	ClientSet             *kubernetes.Clientset
 	httpClient            *retryablehttp.Client
+	notificationLimiter   *notificationRateLimiter
 	requeueDependency     time.Duration
 	Scheme                *runtime.Scheme
 	EventRecorder         kuberecorder.EventRecorder
@@ -51,13 +55,14 @@ type KluctlDeploymentReconciler struct {
 	DefaultServiceAccount string
 	DryRun                bool
 
-	SshPool *ssh_pool.SshPool
+	RepoCache *RepoCache
 }
 
 // KluctlDeploymentReconcilerOpts contains options for the BaseReconciler.
 type KluctlDeploymentReconcilerOpts struct {
-	MaxConcurrentReconciles int
-	HTTPRetry               int
+	MaxConcurrentReconciles   int
+	HTTPRetry                 int
+	DependencyRequeueInterval time.Duration
 }
 
 // +kubebuilder:rbac:groups=flux.kluctl.io,resources=kluctldeployments,verbs=get;list;watch;create;update;patch;delete
@@ -68,6 +73,7 @@ type KluctlDeploymentReconcilerOpts struct {
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets;gitrepositories,verbs=get;list;watch
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=buckets/status;gitrepositories/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=configmaps;secrets;serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=create;delete
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *KluctlDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -134,6 +140,9 @@ func (r *KluctlDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			msg := fmt.Sprintf("Source '%s' not found", obj.Spec.SourceRef)
+			if obj.Spec.Source != nil && obj.Spec.Source.Bucket != nil {
+				msg = fmt.Sprintf("Bucket '%s' not found", obj.Spec.Source.Bucket.Name)
+			}
 			patch := client.MergeFrom(obj.DeepCopy())
 			setReadiness(obj, metav1.ConditionFalse, kluctlv1.ArtifactFailedReason, msg)
 			if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
@@ -157,10 +166,46 @@ func (r *KluctlDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			return ctrl.Result{RequeueAfter: retryInterval}, nil
 		}
 
+		if isNonRetryableError(err) {
+			patch := client.MergeFrom(obj.DeepCopy())
+			setReadiness(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error())
+			setStalled(obj, kluctlv1.PrepareFailedReason, err.Error())
+			if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
+				return ctrl.Result{Requeue: true}, err
+			}
+			log.Error(err, "invalid source specified")
+			r.recordReadiness(ctx, obj)
+			r.event(ctx, obj, "unknown", true, err.Error(), nil)
+			// retrying won't help, a spec change will trigger a new reconciliation
+			return ctrl.Result{}, nil
+		}
+
 		// retry on transient errors
 		return ctrl.Result{Requeue: true}, err
 	}
 
+	// check dependencies
+	if len(obj.Spec.DependsOn) > 0 {
+		if err := r.checkDependencies(ctx, obj); err != nil {
+			reason := kluctlv1.DependencyNotReadyReason
+			if acl.IsAccessDenied(err) {
+				reason = apiacl.AccessDeniedReason
+			}
+			patch := client.MergeFrom(obj.DeepCopy())
+			setReadiness(obj, metav1.ConditionFalse, reason, err.Error())
+			if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
+				return ctrl.Result{Requeue: true}, err
+			}
+			r.recordReadiness(ctx, obj)
+			msg := fmt.Sprintf("Dependencies do not meet ready condition (%s), retrying in %s",
+				err.Error(), r.requeueDependency.String())
+			log.Info(msg)
+			r.event(ctx, obj, "", false, msg, nil)
+			return ctrl.Result{RequeueAfter: r.requeueDependency}, nil
+		}
+		log.Info("All dependencies are ready, proceeding with reconciliation")
+	}
+
 	// record reconciliation duration
 	if r.MetricsRecorder != nil {
 		objRef, err := reference.GetReference(r.Scheme, obj)
@@ -171,24 +216,27 @@ func (r *KluctlDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	}
 
 	// set the reconciliation status to progressing
+	progressingPatch := client.MergeFrom(obj.DeepCopy())
+	removeCondition(obj, meta.StalledCondition)
+	setReconciling(obj, meta.ProgressingReason, "reconciliation in progress")
 	if obj.Status.ObservedGeneration == 0 {
-		patch := client.MergeFrom(obj.DeepCopy())
 		setReadiness(obj, metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress")
-		if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
-			return ctrl.Result{Requeue: true}, err
-		}
-
-		r.recordReadiness(ctx, obj)
 	}
+	if err := r.Status().Patch(ctx, obj, progressingPatch, client.FieldOwner(r.statusManager)); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	r.recordReadiness(ctx, obj)
 
 	// record the value of the reconciliation request, if any
+	reconcileRequested := false
 	if v, ok := meta.ReconcileAnnotationValue(obj.GetAnnotations()); ok {
+		reconcileRequested = v != obj.Status.GetLastHandledReconcileRequest()
 		obj.Status.SetLastHandledReconcileRequest(v)
 	}
 
 	// reconcile kluctlDeployment by applying the latest revision
 	patch := client.MergeFrom(obj.DeepCopy())
-	ctrlResult, sourceRevision, reconcileErr := r.doReconcile(ctx, obj, source)
+	ctrlResult, sourceRevision, reconcileErr := r.doReconcile(ctx, obj, source, reconcileRequested)
 	if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
 		return ctrl.Result{Requeue: true}, err
 	}
@@ -228,16 +276,79 @@ func (r *KluctlDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Req
 func (r *KluctlDeploymentReconciler) doReconcile(
 	ctx context.Context,
 	obj *kluctlv1.KluctlDeployment,
-	source *kluctlv1.ProjectSource) (*ctrl.Result, string, error) {
+	source *kluctlv1.ProjectSource,
+	reconcileRequested bool) (*ctrl.Result, string, error) {
+
+	log := ctrl.LoggerFrom(ctx)
+
+	r.exportDeploymentObjectToProm(obj, source)
+
+	if _, ok := obj.GetAnnotations()[kluctlv1.KluctlRestoreBackupAnnotation]; !ok {
+		// allows to restore the same backup again by re-adding the annotation
+		obj.Status.LastHandledRestore = ""
+	}
+
+	deployAllowed, err := isDeployAllowed(obj, time.Now())
+	if err != nil {
+		// invalid time specs can only be fixed by changing the spec
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), "")
+		setStalled(obj, kluctlv1.PrepareFailedReason, err.Error())
+		return nil, "", err
+	}
+
+	// check if the project has to be loaded at all
+	inputsHash := ""
+	inputsCommit := ""
+	if obj.Spec.Targets == nil {
+		inputsHash, inputsCommit, err = r.calcInputsHash(ctx, obj, source)
+		if err != nil {
+			// not fatal, the full reconciliation will report real errors
+			log.V(1).Info("failed to calculate inputs hash", "error", err.Error())
+			inputsHash = ""
+		}
+	}
+	if inputsHash != "" && inputsHash == obj.Status.LastInputsHash && !r.isReconcileWorkDue(obj, reconcileRequested, time.Now()) {
+		log.Info("Inputs did not change and nothing is due, skipping project loading")
+		removeCondition(obj, meta.ReconcilingCondition)
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(1.0)
+		return &ctrl.Result{RequeueAfter: time.Until(r.nextReconcileTime(obj))}, obj.Status.LastAttemptedRevision, nil
+	}
+	obj.Status.LastInputsHash = ""
 
-	r.exportDeploymentObjectToProm(obj)
+	if !isRollbackEnabled(obj, source) {
+		clearRollback(obj)
+	}
 
 	pp, err := prepareProject(ctx, r, obj, source)
+	if err == nil {
+		if rollbackSource := buildRollbackSource(obj, source, pp.sourceCommit); rollbackSource != nil {
+			// the revision that was rolled back is still the latest one, so stay on the last successful revision
+			pp.cleanup()
+			pp, err = prepareProject(ctx, r, obj, rollbackSource)
+			if err == nil {
+				pp.rolledBack = true
+			}
+		}
+	}
 	if err != nil {
 		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), "")
 		return nil, "", err
 	}
-	defer pp.cleanup()
+	defer func() {
+		pp.cleanup()
+	}()
+
+	obj.Status.ResolvedRef = pp.resolvedRef
+	obj.Status.ResolvedSemVer = pp.resolvedSemVer
+
+	if name, ok := checkRequestedRestore(obj); ok {
+		r.restoreBackup(ctx, pp, name)
+	}
+
+	if obj.Spec.Targets != nil {
+		return r.doReconcileTargets(ctx, pp, deployAllowed)
+	}
+	obj.Status.Targets = nil
 
 	pt, err := pp.newTarget()
 	if err != nil {
@@ -245,125 +356,309 @@ func (r *KluctlDeploymentReconciler) doReconcile(
 		return nil, pp.sourceRevision, err
 	}
 
+	deployPending := false
+	approvalPending := false
 	err = pt.withKluctlProjectTarget(ctx, func(targetContext *kluctl_project.TargetContext) error {
-		obj.Status.Discriminator = targetContext.Target.Discriminator
-		obj.Status.SetRawTarget(&targetContext.Target)
+		var err error
+		deployPending, approvalPending, err = r.reconcileTarget(ctx, pt, targetContext, deployAllowed)
+		return err
+	})
+	var rollbackRemaining time.Duration
+	if err == nil && !pp.rolledBack {
+		var rollbackReason string
+		rollbackReason, rollbackRemaining = checkRollbackNeeded(obj, pp, time.Now())
+		if rollbackReason != "" {
+			failed := pp
+			pp, err = r.rollback(ctx, obj, source, failed, rollbackReason)
+			failed.cleanup()
+			if pp == nil {
+				pp = failed
+			}
+		}
+	}
+	obj.Status.ObservedGeneration = obj.GetGeneration()
+	if cleanupErr := r.cleanupCommandResults(ctx, obj); cleanupErr != nil {
+		ctrl.LoggerFrom(ctx).Error(cleanupErr, "failed to cleanup old command results")
+	}
+	if v, ok := obj.GetAnnotations()[kluctlv1.KluctlDeployRequestAnnotation]; ok && !deployPending && !approvalPending {
+		obj.Status.LastHandledDeployAt = v
+	}
+	if err != nil {
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
+		if isNonRetryableError(err) {
+			setStalled(obj, kluctlv1.PrepareFailedReason, err.Error())
+		}
+		return nil, pp.sourceRevision, err
+	}
 
-		objectsHash := r.calcObjectsHash(targetContext)
-		needDeploy := false
-		needPrune := false
-		needValidate := false
+	var ctrlResult ctrl.Result
+	ctrlResult.RequeueAfter = r.nextReconcileTime(obj).Sub(time.Now())
+	if ctrlResult.RequeueAfter < 0 {
+		ctrlResult.RequeueAfter = 0
+		ctrlResult.Requeue = true
+	}
+	if rollbackRemaining > 0 && rollbackRemaining < ctrlResult.RequeueAfter {
+		// re-check the failing validation when the rollback timeout passes
+		ctrlResult.RequeueAfter = rollbackRemaining
+	}
 
-		if obj.Status.LastDeployResult == nil || (obj.Spec.DeployOnChanges && obj.Status.LastDeployResult.ObjectsHash != objectsHash) {
-			// either never deployed or source code changed
-			needDeploy = true
-		} else if r.checkRequestedDeploy(obj) {
-			// explicitly requested a deploy
-			needDeploy = true
-		} else if obj.Status.ObservedGeneration != obj.GetGeneration() {
-			// spec has changed
+	err = r.updateReadiness(obj, pp.sourceRevision, deployPending, approvalPending)
+	if err != nil {
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(0.0)
+		if obj.Status.Rollback != nil {
+			setRolledBackCondition(obj)
+		}
+		return &ctrlResult, pp.sourceRevision, err
+	}
+	if pp.rolledBack {
+		// the last successful revision is healthy, but the latest revision is not deployed
+		recordSuccessfulRevision(obj, pp)
+		setRolledBackCondition(obj)
+		rb := obj.Status.Rollback
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.RolledBackReason,
+			fmt.Sprintf("revision %s was rolled back to revision %s: %s", rb.FailedRevision, rb.Revision, rb.Reason), pp.sourceRevision)
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(0.0)
+		return &ctrlResult, pp.sourceRevision, nil
+	}
+	if apimeta.IsStatusConditionTrue(obj.Status.Conditions, meta.ReadyCondition) {
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(1.0)
+		recordSuccessfulRevision(obj, pp)
+		if obj.Status.Rollback != nil {
+			r.event(ctx, obj, pp.sourceRevision, false,
+				fmt.Sprintf("revision %s succeeded, rollback of revision %s ended", pp.sourceRevision, obj.Status.Rollback.FailedRevision), nil)
+			clearRollback(obj)
+		}
+		if inputsCommit != "" && strings.HasPrefix(pp.sourceCommit, inputsCommit) {
+			// the checkout might have been fetched before or after the inputs hash was calculated
+			obj.Status.LastInputsHash = inputsHash
+		}
+	}
+	return &ctrlResult, pp.sourceRevision, nil
+}
+
+// reconcileTarget performs the deploy, prune and validate steps for a single rendered target. The results are stored
+// in the status of the prepared object. The returned flags tell if a deployment was deferred due to deploy windows
+// or due to a missing approval.
+func (r *KluctlDeploymentReconciler) reconcileTarget(ctx context.Context, pt *preparedTarget, targetContext *kluctl_project.TargetContext, deployAllowed bool) (deployPending bool, approvalPending bool, err error) {
+	obj := pt.pp.obj
+
+	obj.Status.Discriminator = targetContext.Target.Discriminator
+	obj.Status.SetRawTarget(&targetContext.Target)
+
+	objectsHash := r.calcObjectsHash(targetContext)
+	needDeploy := false
+	needPrune := false
+	needValidate := false
+
+	initiator := ""
+	if pt.pp.rollbackDeploy {
+		// redeploy the last successful revision
+		needDeploy = true
+		initiator = kluctlv1.InitiatorRollback
+	} else if obj.Status.LastDeployResult == nil {
+		// never deployed
+		needDeploy = true
+		initiator = kluctlv1.InitiatorInitial
+	} else if obj.Spec.DeployOnChanges && obj.Status.LastDeployResult.ObjectsHash != objectsHash {
+		// source code changed
+		needDeploy = true
+		initiator = kluctlv1.InitiatorSourceChange
+	} else if r.checkRequestedDeploy(obj) {
+		// explicitly requested a deploy
+		needDeploy = true
+		initiator = kluctlv1.InitiatorRequest
+	} else if obj.Status.ObservedGeneration != obj.GetGeneration() {
+		// spec has changed
+		needDeploy = true
+		initiator = kluctlv1.InitiatorSpecChange
+	} else {
+		// was deployed before, let's check if we need to do periodic deployments
+		nextDeployTime := r.nextDeployTime(obj)
+		if nextDeployTime != nil {
+			needDeploy = nextDeployTime.Before(time.Now())
+			initiator = kluctlv1.InitiatorInterval
+		}
+	}
+
+	if obj.Spec.DriftDetection == nil {
+		obj.Status.LastDriftDetectionResult = nil
+	} else if !needDeploy && r.isDriftDetectionDue(obj, objectsHash, time.Now()) {
+		drifted := r.detectDrift(ctx, pt, targetContext, objectsHash)
+		if drifted && obj.Spec.DriftDetection.AutoCorrect {
 			needDeploy = true
+			initiator = kluctlv1.InitiatorDriftCorrection
+		}
+	}
+
+	if isDeployPending(obj) {
+		// a previously deferred deployment is still pending
+		if !needDeploy {
+			initiator = kluctlv1.InitiatorDeployWindow
+		}
+		needDeploy = true
+	}
+	if obj.Spec.Approval == kluctlv1.ApprovalManual && isApprovalPending(obj) {
+		// a previous change set is still waiting for approval, re-check it
+		if !needDeploy {
+			initiator = kluctlv1.InitiatorApproval
+		}
+		needDeploy = true
+	}
+	if needDeploy && !deployAllowed {
+		// we're outside of the deploy windows or inside a blackout, so defer the deployment
+		nextAllowed, err := nextDeployAllowedTime(obj, time.Now())
+		if err != nil {
+			return false, false, err
+		}
+		setDeployPending(obj, nextAllowed)
+		needDeploy = false
+		deployPending = true
+	} else {
+		removeDeployPending(obj)
+	}
+
+	if obj.Spec.Approval != kluctlv1.ApprovalManual {
+		removeApprovalPending(obj)
+	} else if needDeploy && !pt.pp.rollbackDeploy {
+		approved, err := r.checkApproval(ctx, pt, targetContext, objectsHash)
+		if err != nil {
+			return false, false, err
+		}
+		if !approved {
+			needDeploy = false
+			approvalPending = true
+		}
+	}
+
+	if obj.Spec.Validate {
+		if obj.Status.LastValidateResult == nil || needDeploy || needRollbackValidate(obj) {
+			// either never validated before, a deployment requested (which required re-validation) or a failed
+			// validation might cause a rollback
+			needValidate = true
 		} else {
-			// was deployed before, let's check if we need to do periodic deployments
-			nextDeployTime := r.nextDeployTime(obj)
-			if nextDeployTime != nil {
-				needDeploy = nextDeployTime.Before(time.Now())
+			nextValidateTime := r.nextValidateTime(obj)
+			if nextValidateTime != nil {
+				needValidate = nextValidateTime.Before(time.Now())
 			}
 		}
+	} else {
+		obj.Status.LastValidateResult = nil
+	}
 
-		if obj.Spec.Validate {
-			if obj.Status.LastValidateResult == nil || needDeploy {
-				// either never validated before or a deployment requested (which required re-validation)
-				needValidate = true
-			} else {
-				nextValidateTime := r.nextValidateTime(obj)
-				if nextValidateTime != nil {
-					needValidate = nextValidateTime.Before(time.Now())
-				}
-			}
+	if obj.Spec.Prune {
+		needPrune = needDeploy
+	} else {
+		obj.Status.LastPruneResult = nil
+		removeCondition(obj, kluctlv1.PruneBlockedCondition)
+	}
+
+	if needDeploy {
+		// deploy the kluctl project
+		var deployResult *result.CommandResult
+		var deploySummary *result.CommandResultSummary
+		startTime := time.Now()
+		pt.reportCommitStatus(ctx, "deploy", commitStatusPending, "deployment in progress")
+		if obj.Spec.DeployMode == kluctlv1.KluctlDeployModeFull {
+			deployResult, deploySummary, err = pt.kluctlDeploy(ctx, targetContext)
+		} else if obj.Spec.DeployMode == kluctlv1.KluctlDeployPokeImages {
+			deployResult, deploySummary, err = pt.kluctlPokeImages(ctx, targetContext)
 		} else {
-			obj.Status.LastValidateResult = nil
+			err = fmt.Errorf("deployMode '%s' not supported", obj.Spec.DeployMode)
+			pt.reportCommitStatus(ctx, "deploy", commitStatusFailure, err.Error())
+			setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.DeployFailedReason, err.Error(), pt.pp.sourceRevision)
+			setStalled(obj, kluctlv1.DeployFailedReason, err.Error())
+			return deployPending, approvalPending, nil
 		}
-
-		if obj.Spec.Prune {
-			needPrune = needDeploy
+		kluctlv1.SetDeployResult(obj, pt.pp.sourceRevision, deployResult, deploySummary, objectsHash, initiator, startTime, err)
+		r.storeCommandResult(ctx, obj, "deploy", obj.Status.LastDeployResult)
+		if err != nil {
+			pt.reportCommitStatus(ctx, "deploy", commitStatusFailure, fmt.Sprintf("deployment failed: %s", err.Error()))
+			setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.DeployFailedReason, err.Error(), pt.pp.sourceRevision)
+			return deployPending, approvalPending, nil
+		}
+		if errCount := obj.Status.LastDeployResult.ErrorCount(); errCount != 0 {
+			pt.reportCommitStatus(ctx, "deploy", commitStatusFailure, fmt.Sprintf("deployment failed with %d errors", errCount))
 		} else {
-			obj.Status.LastPruneResult = nil
-		}
-
-		if needDeploy {
-			// deploy the kluctl project
-			var deployResult *result.CommandResult
-			if obj.Spec.DeployMode == kluctlv1.KluctlDeployModeFull {
-				deployResult, err = pt.kluctlDeploy(ctx, targetContext)
-			} else if obj.Spec.DeployMode == kluctlv1.KluctlDeployPokeImages {
-				deployResult, err = pt.kluctlPokeImages(ctx, targetContext)
-			} else {
-				err = fmt.Errorf("deployMode '%s' not supported", obj.Spec.DeployMode)
-				setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.DeployFailedReason, err.Error(), pp.sourceRevision)
-				return nil
-			}
-			kluctlv1.SetDeployResult(obj, pp.sourceRevision, deployResult, objectsHash, err)
-			if err != nil {
-				setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.DeployFailedReason, err.Error(), pp.sourceRevision)
-				return nil
-			}
+			pt.reportCommitStatus(ctx, "deploy", commitStatusSuccess, "deployment succeeded")
+			// the deployment corrected all drift, the next reconciliation verifies it
+			obj.Status.LastDriftDetectionResult = nil
 		}
+	}
 
-		if needPrune {
-			// run garbage collection for stale objects that do not have pruning disabled
-			pruneResult, err := pt.kluctlPrune(ctx, targetContext)
-			kluctlv1.SetPruneResult(obj, pp.sourceRevision, pruneResult, objectsHash, err)
+	if needPrune {
+		// run garbage collection for stale objects that do not have pruning disabled
+		startTime := time.Now()
+		pruneResult, pruneSummary, err := pt.kluctlPrune(ctx, targetContext)
+		// a dry-run prune deleted nothing, so it is not recorded as prune result. The PruneBlocked condition lists
+		// the objects that would have been deleted instead.
+		if !errors.Is(err, errPruneDryRun) {
+			kluctlv1.SetPruneResult(obj, pt.pp.sourceRevision, pruneResult, pruneSummary, objectsHash, initiator, startTime, err)
+			r.storeCommandResult(ctx, obj, "prune", obj.Status.LastPruneResult)
 			if err != nil {
-				setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PruneFailedReason, err.Error(), pp.sourceRevision)
-				return nil
+				setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PruneFailedReason, err.Error(), pt.pp.sourceRevision)
+				return deployPending, approvalPending, nil
 			}
 		}
+	}
 
-		if needValidate {
-			validateResult, err := pt.kluctlValidate(ctx, targetContext)
-			kluctlv1.SetValidateResult(obj, pp.sourceRevision, validateResult, objectsHash, err)
-			if err != nil {
-				setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.ValidateFailedReason, err.Error(), pp.sourceRevision)
-				return nil
+	if needValidate {
+		pt.reportCommitStatus(ctx, "validate", commitStatusPending, "validation in progress")
+		validateResult, err := pt.kluctlValidate(ctx, targetContext)
+		kluctlv1.SetValidateResult(obj, pt.pp.sourceRevision, validateResult, objectsHash, err)
+		if err != nil {
+			pt.reportCommitStatus(ctx, "validate", commitStatusFailure, fmt.Sprintf("validation failed: %s", err.Error()))
+			pt.notify(ctx, "validate", true, fmt.Sprintf("validate failed. %s", err.Error()), nil)
+			setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.ValidateFailedReason, err.Error(), pt.pp.sourceRevision)
+			return deployPending, approvalPending, nil
+		}
+		if validateResult == nil || len(validateResult.Errors) != 0 || !validateResult.Ready {
+			desc := "validation returned no result"
+			if validateResult != nil {
+				desc = fmt.Sprintf("validation reported %d errors (ready: %v)", len(validateResult.Errors), validateResult.Ready)
 			}
+			pt.reportCommitStatus(ctx, "validate", commitStatusFailure, desc)
+			pt.notify(ctx, "validate", true, desc, nil)
+		} else {
+			pt.reportCommitStatus(ctx, "validate", commitStatusSuccess, "validation succeeded")
+			pt.notify(ctx, "validate", false, "validation succeeded", nil)
 		}
-		return nil
-	})
-	obj.Status.ObservedGeneration = obj.GetGeneration()
-	if v, ok := obj.GetAnnotations()[kluctlv1.KluctlDeployRequestAnnotation]; ok {
-		obj.Status.LastHandledDeployAt = v
-	}
-	if err != nil {
-		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
-		return nil, pp.sourceRevision, err
 	}
+	return deployPending, approvalPending, nil
+}
 
-	var ctrlResult ctrl.Result
-	ctrlResult.RequeueAfter = r.nextReconcileTime(obj).Sub(time.Now())
-	if ctrlResult.RequeueAfter < 0 {
-		ctrlResult.RequeueAfter = 0
-		ctrlResult.Requeue = true
-	}
+// updateReadiness sets the result conditions and the Ready condition from the last command results. An error is
+// returned if any of the results indicates a failure.
+func (r *KluctlDeploymentReconciler) updateReadiness(obj *kluctlv1.KluctlDeployment, revision string, deployPending bool, approvalPending bool) error {
+	r.setResultConditions(obj)
 
 	finalStatus, reason := r.buildFinalStatus(obj)
+	if deployPending && obj.Status.LastDeployResult == nil {
+		// never deployed before, so there is nothing to report besides the pending deployment
+		c := apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.DeployPendingCondition)
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.OutsideDeployWindowReason, c.Message, revision)
+		return nil
+	}
+	if approvalPending && obj.Status.LastDeployResult == nil {
+		// never deployed before, so there is nothing to report besides the pending approval
+		c := apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.ApprovalPendingCondition)
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.AwaitingApprovalReason, c.Message, revision)
+		return nil
+	}
 	if reason != kluctlv1.ReconciliationSucceededReason {
-		setReadinessWithRevision(obj, metav1.ConditionFalse, reason, finalStatus, pp.sourceRevision)
-		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(0.0)
-		return &ctrlResult, pp.sourceRevision, fmt.Errorf(finalStatus)
+		setReadinessWithRevision(obj, metav1.ConditionFalse, reason, finalStatus, revision)
+		return fmt.Errorf(finalStatus)
 	}
-	setReadinessWithRevision(obj, metav1.ConditionTrue, reason, finalStatus, pp.sourceRevision)
-	internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(1.0)
-	return &ctrlResult, pp.sourceRevision, nil
+	setReadinessWithRevision(obj, metav1.ConditionTrue, reason, finalStatus, revision)
+	return nil
 }
 
 func (r *KluctlDeploymentReconciler) buildFinalStatus(obj *kluctlv1.KluctlDeployment) (finalStatus string, reason string) {
-	lastDeployResult := obj.Status.LastDeployResult.ParseResult()
-	lastPruneResult := obj.Status.LastPruneResult.ParseResult()
+	lastDeployResult := obj.Status.LastDeployResult
+	lastPruneResult := obj.Status.LastPruneResult
 	lastValidateResult := obj.Status.LastValidateResult.ParseResult()
 
-	deployOk := lastDeployResult != nil && obj.Status.LastDeployResult.Error == "" && len(lastDeployResult.Errors) == 0
-	pruneOk := lastPruneResult == nil || (obj.Status.LastPruneResult.Error == "" && len(lastPruneResult.Errors) == 0)
+	deployOk := lastDeployResult.HasResult() && lastDeployResult.Error == "" && lastDeployResult.ErrorCount() == 0
+	pruneOk := !lastPruneResult.HasResult() || (lastPruneResult.Error == "" && lastPruneResult.ErrorCount() == 0)
 	validateOk := lastValidateResult != nil && obj.Status.LastValidateResult.Error == "" && len(lastValidateResult.Errors) == 0 && lastValidateResult.Ready
 
 	if !obj.Spec.Prune {
@@ -418,6 +713,61 @@ func (r *KluctlDeploymentReconciler) buildFinalStatus(obj *kluctlv1.KluctlDeploy
 	return
 }
 
+// setResultConditions sets the Deployed, Pruned and Healthy conditions from the last command results, so that
+// failed deployments can be distinguished from unhealthy workloads.
+func (r *KluctlDeploymentReconciler) setResultConditions(obj *kluctlv1.KluctlDeployment) {
+	setCommandResultCondition(obj, kluctlv1.DeployedCondition, "deploy", obj.Status.LastDeployResult,
+		kluctlv1.DeploySucceededReason, kluctlv1.DeployFailedReason)
+
+	if obj.Spec.Prune {
+		setCommandResultCondition(obj, kluctlv1.PrunedCondition, "prune", obj.Status.LastPruneResult,
+			kluctlv1.PruneSucceededReason, kluctlv1.PruneFailedReason)
+	} else {
+		removeCondition(obj, kluctlv1.PrunedCondition)
+	}
+
+	if obj.Spec.Validate && obj.Status.LastValidateResult != nil {
+		lr := obj.Status.LastValidateResult
+		vr := lr.ParseResult()
+		suffix := fmt.Sprintf("revision %s at %s", lr.Revision, lr.AttemptedAt.Format(time.RFC3339))
+		if lr.Error != "" || vr == nil {
+			msg := lr.Error
+			if msg == "" {
+				msg = "no result"
+			}
+			setCondition(obj, kluctlv1.HealthyCondition, metav1.ConditionFalse, kluctlv1.ValidateFailedReason,
+				fmt.Sprintf("validate failed for %s: %s", suffix, msg))
+		} else if len(vr.Errors) != 0 || !vr.Ready {
+			setCondition(obj, kluctlv1.HealthyCondition, metav1.ConditionFalse, kluctlv1.UnhealthyReason,
+				fmt.Sprintf("validate reported %d errors and %d warnings for %s (ready: %v)", len(vr.Errors), len(vr.Warnings), suffix, vr.Ready))
+		} else {
+			setCondition(obj, kluctlv1.HealthyCondition, metav1.ConditionTrue, kluctlv1.ValidateSucceededReason,
+				fmt.Sprintf("validate succeeded for %s", suffix))
+		}
+	} else if !obj.Spec.Validate {
+		removeCondition(obj, kluctlv1.HealthyCondition)
+	}
+
+	setDriftedCondition(obj)
+}
+
+func setCommandResultCondition(obj *kluctlv1.KluctlDeployment, conditionType string, op string, lr *kluctlv1.LastCommandResult, successReason string, failedReason string) {
+	if lr == nil {
+		return
+	}
+	suffix := fmt.Sprintf("revision %s at %s", lr.Revision, lr.AttemptedAt.Format(time.RFC3339))
+	if lr.Error != "" {
+		setCondition(obj, conditionType, metav1.ConditionFalse, failedReason,
+			fmt.Sprintf("%s failed for %s: %s", op, suffix, lr.Error))
+	} else if errCount := lr.ErrorCount(); errCount != 0 {
+		setCondition(obj, conditionType, metav1.ConditionFalse, failedReason,
+			fmt.Sprintf("%s failed with %d errors for %s", op, errCount, suffix))
+	} else {
+		setCondition(obj, conditionType, metav1.ConditionTrue, successReason,
+			fmt.Sprintf("%s succeeded for %s", op, suffix))
+	}
+}
+
 func (r *KluctlDeploymentReconciler) calcTimeout(obj *kluctlv1.KluctlDeployment) time.Duration {
 	var d time.Duration
 	if obj.Spec.Timeout != nil {
@@ -437,12 +787,20 @@ func (r *KluctlDeploymentReconciler) nextReconcileTime(obj *kluctlv1.KluctlDeplo
 	t1 := time.Now().Add(obj.Spec.Interval.Duration)
 	t2 := r.nextDeployTime(obj)
 	t3 := r.nextValidateTime(obj)
+	t4 := r.nextDriftDetectionTime(obj)
+	if isDeployPending(obj) {
+		// the deployment was deferred, so the next deployment happens when the next deploy window opens
+		t2, _ = nextDeployAllowedTime(obj, time.Now())
+	}
 	if t2 != nil && t2.Before(t1) {
 		t1 = *t2
 	}
 	if obj.Spec.Validate && t3 != nil && t3.Before(t1) {
 		t1 = *t3
 	}
+	if t4 != nil && t4.Before(t1) {
+		t1 = *t4
+	}
 	return t1
 }
 
@@ -494,7 +852,25 @@ func (r *KluctlDeploymentReconciler) nextValidateTime(obj *kluctlv1.KluctlDeploy
 }
 
 func (r *KluctlDeploymentReconciler) finalize(ctx context.Context, obj *kluctlv1.KluctlDeployment) (ctrl.Result, error) {
-	r.doFinalize(ctx, obj)
+	err := r.doFinalize(ctx, obj)
+	if isBackupError(err) {
+		// keep the finalizer, as the objects must not be deleted without a backup
+		patch := client.MergeFrom(obj.DeepCopy())
+		setReadiness(obj, metav1.ConditionFalse, kluctlv1.DeleteFailedReason, err.Error())
+		if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		r.recordReadiness(ctx, obj)
+		r.event(ctx, obj, obj.Status.LastAttemptedRevision, true, fmt.Sprintf("delete failed: %s", err.Error()), nil)
+		return ctrl.Result{RequeueAfter: obj.Spec.GetRetryInterval()}, nil
+	}
+	if err != nil {
+		// other errors (e.g. a missing kubeconfig or an unreachable cluster) might never resolve, so the finalizer is
+		// removed anyway to not block the deletion of the KluctlDeployment and its namespace
+		r.event(ctx, obj, obj.Status.LastAttemptedRevision, true, fmt.Sprintf("delete failed, objects are left orphaned: %s", err.Error()), nil)
+	}
+
+	deleteDriftedObjectsMetric(obj)
 
 	// Record deleted status
 	r.recordReadiness(ctx, obj)
@@ -510,36 +886,65 @@ func (r *KluctlDeploymentReconciler) finalize(ctx context.Context, obj *kluctlv1
 	return ctrl.Result{}, nil
 }
 
-func (r *KluctlDeploymentReconciler) doFinalize(ctx context.Context, obj *kluctlv1.KluctlDeployment) {
+// doFinalize deletes the objects of all targets if spec.delete is enabled. Failed backups are returned as backupError,
+// see isBackupError.
+func (r *KluctlDeploymentReconciler) doFinalize(ctx context.Context, obj *kluctlv1.KluctlDeployment) error {
 	log := ctrl.LoggerFrom(ctx)
 
 	if !obj.Spec.Delete || obj.Spec.Suspend {
-		return
+		return nil
 	}
 	if r.checkNewGitOpsObjectExistence(ctx, obj) {
 		log.V(1).Info("Skipping finalization due to new version of KluctlDeployment being present")
-		return
+		return nil
 	}
 
-	if obj.Status.Discriminator == "" {
+	hasDiscriminator := obj.Status.Discriminator != ""
+	for _, ts := range obj.Status.Targets {
+		if ts.Discriminator != "" {
+			hasDiscriminator = true
+		}
+	}
+	if !hasDiscriminator {
 		log.V(1).Info("No discriminator set, skipping deletion")
-		return
+		return nil
 	}
 
 	log.V(1).Info("Deleting target")
 
 	pp, err := prepareProject(ctx, r, obj, nil)
 	if err != nil {
-		return
+		return fmt.Errorf("failed to prepare deletion: %w", err)
 	}
 	defer pp.cleanup()
 
-	pt, err := pp.newTarget()
-	if err != nil {
-		return
+	var errs []error
+	doDelete := func(spp *preparedProject, discriminator string) {
+		pt, err := spp.newTarget()
+		if err == nil {
+			_, err = pt.kluctlDelete(ctx, discriminator)
+		}
+		if err != nil {
+			log.Error(err, "Deleting target failed", "discriminator", discriminator)
+			errs = append(errs, err)
+		}
 	}
 
-	_, _ = pt.kluctlDelete(ctx, obj.Status.Discriminator)
+	if obj.Status.Discriminator != "" {
+		doDelete(pp, obj.Status.Discriminator)
+	}
+	// targets selected via spec.targets are deleted with their own shadow, so that events, notifications and backups
+	// refer to the target
+	for _, ts := range obj.Status.Targets {
+		if ts.Discriminator == "" {
+			continue
+		}
+		spp := *pp
+		spp.obj = newTargetShadow(obj, ts.Name)
+		spp.selectedTarget = ts.Name
+		doDelete(&spp, ts.Discriminator)
+	}
+	return utilerrors.NewAggregate(errs)
 }
 
 func (r *KluctlDeploymentReconciler) checkNewGitOpsObjectExistence(ctx context.Context, obj *kluctlv1.KluctlDeployment) bool {
@@ -586,7 +991,9 @@ func (r *KluctlDeploymentReconciler) calcObjectsHash(targetContext *project.Targ
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (r *KluctlDeploymentReconciler) exportDeploymentObjectToProm(obj *kluctlv1.KluctlDeployment) {
+// exportDeploymentObjectToProm exports the spec of the KluctlDeployment as metrics. The source labels are taken from
+// the resolved source, as spec.source is not set when spec.sourceRef is used.
+func (r *KluctlDeploymentReconciler) exportDeploymentObjectToProm(obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource) {
 	pruneEnabled := 0.0
 	deleteEnabled := 0.0
 	dryRunEnabled := 0.0
@@ -615,5 +1022,5 @@ func (r *KluctlDeploymentReconciler) exportDeploymentObjectToProm(obj *kluctlv1.
 	internal_metrics.NewKluctlDeleteEnabled(obj.Namespace, obj.Name).Set(deleteEnabled)
 	internal_metrics.NewKluctlDryRunEnabled(obj.Namespace, obj.Name).Set(dryRunEnabled)
 	internal_metrics.NewKluctlDeploymentInterval(obj.Namespace, obj.Name).Set(deploymentInterval)
-	internal_metrics.NewKluctlSourceSpec(obj.Namespace, obj.Name, obj.Spec.Source.URL, obj.Spec.Source.Path, obj.Spec.Source.Ref.String()).Set(0.0)
+	internal_metrics.NewKluctlSourceSpec(obj.Namespace, obj.Name, buildSourceUrl(obj, source), source.Path, source.Ref.String()).Set(0.0)
 }
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/diff"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	"github.com/kluctl/kluctl/v2/pkg/yaml"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+)
+
+// checkApproval performs a diff and checks if the resulting change set was approved via the
+// deploy.flux.kluctl.io/approvedHash annotation. Change sets without any changes are considered approved.
+// If the change set was not approved, it is stored in status.pendingApproval.
+func (r *KluctlDeploymentReconciler) checkApproval(ctx context.Context, pt *preparedTarget, targetContext *kluctl_project.TargetContext, objectsHash string) (bool, error) {
+	obj := pt.pp.obj
+
+	diffResult, err := pt.kluctlDiff(ctx, targetContext)
+	if err != nil {
+		return false, err
+	}
+
+	hash, hasChanges, err := calcChangeSetHash(diffResult)
+	if err != nil {
+		return false, err
+	}
+	if !hasChanges || obj.GetAnnotations()[kluctlv1.KluctlApprovedHashAnnotation] == hash {
+		removeApprovalPending(obj)
+		return true, nil
+	}
+
+	if obj.Status.PendingApproval != nil && obj.Status.PendingApproval.Hash == hash {
+		// still waiting for the same change set to be approved
+		return false, nil
+	}
+
+	summary := diffResult.BuildSummary()
+	pa := &kluctlv1.PendingApproval{
+		Hash:        hash,
+		DetectedAt:  metav1.Now(),
+		Revision:    pt.pp.sourceRevision,
+		ObjectsHash: objectsHash,
+		Summary:     kluctlv1.NewCommandSummary(summary),
+	}
+
+	// remove secret values and the full objects, the diffs are sufficient to review the change set
+	obfuscator := diff.Obfuscator{}
+	err = obfuscator.ObfuscateResult(diffResult)
+	if err != nil {
+		return false, err
+	}
+	diffResult.Deployment = nil
+	for i := range diffResult.Objects {
+		diffResult.Objects[i].Rendered = nil
+		diffResult.Objects[i].Remote = nil
+		diffResult.Objects[i].Applied = nil
+	}
+	raw, err := yaml.WriteYamlString(diffResult)
+	if err == nil {
+		pa.RawChanges = &raw
+	}
+
+	obj.Status.PendingApproval = pa
+	msg := fmt.Sprintf("change set %s needs to be approved by setting the %s annotation", hash, kluctlv1.KluctlApprovedHashAnnotation)
+	if pt.pp.selectedTarget != "" {
+		msg += fmt.Sprintf(" with the entry %s=%s", pt.pp.selectedTarget, hash)
+	}
+	if pa.Summary != nil {
+		msg += fmt.Sprintf(" (%d new, %d changed, %d orphan objects)", pa.Summary.NewObjects, pa.Summary.ChangedObjects, pa.Summary.OrphanObjects)
+	}
+	apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+		Type:    kluctlv1.ApprovalPendingCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  kluctlv1.AwaitingApprovalReason,
+		Message: msg,
+	})
+	r.event(ctx, obj, pt.pp.sourceRevision, false, msg, nil)
+
+	return false, nil
+}
+
+// getTargetApprovedHash returns the hash approved for the given target. With spec.targets, the approvedHash annotation
+// contains comma separated <target>=<hash> entries, so that the change sets of all targets can be approved
+// independently of each other.
+func getTargetApprovedHash(obj *kluctlv1.KluctlDeployment, target string) string {
+	for _, e := range strings.Split(obj.GetAnnotations()[kluctlv1.KluctlApprovedHashAnnotation], ",") {
+		t, hash, ok := strings.Cut(strings.TrimSpace(e), "=")
+		if ok && t == target {
+			return hash
+		}
+	}
+	return ""
+}
+
+func isApprovalPending(obj *kluctlv1.KluctlDeployment) bool {
+	return apimeta.IsStatusConditionTrue(obj.Status.Conditions, kluctlv1.ApprovalPendingCondition)
+}
+
+func removeApprovalPending(obj *kluctlv1.KluctlDeployment) {
+	obj.Status.PendingApproval = nil
+	apimeta.RemoveStatusCondition(&obj.Status.Conditions, kluctlv1.ApprovalPendingCondition)
+}
+
+type changeSetEntry struct {
+	Ref     string          `json:"ref"`
+	New     bool            `json:"new,omitempty"`
+	Orphan  bool            `json:"orphan,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Changes []result.Change `json:"changes,omitempty"`
+}
+
+// calcChangeSetHash calculates a hash over all changes found in the given diff result. The hash is calculated before
+// obfuscation, so that changes to secret values result in different hashes. The second return value is false if
+// the diff result does not contain any changes.
+func calcChangeSetHash(diffResult *result.CommandResult) (string, bool, error) {
+	var entries []changeSetEntry
+	for _, o := range diffResult.Objects {
+		if !o.New && !o.Orphan && !o.Deleted && len(o.Changes) == 0 {
+			continue
+		}
+		e := changeSetEntry{
+			Ref:     fmt.Sprintf("%s/%s/%s/%s", o.Ref.Group, o.Ref.Kind, o.Ref.Namespace, o.Ref.Name),
+			New:     o.New,
+			Orphan:  o.Orphan,
+			Deleted: o.Deleted,
+		}
+		for _, c := range o.Changes {
+			// the unified diff is derived from the old and new values
+			c.UnifiedDiff = ""
+			e.Changes = append(e.Changes, c)
+		}
+		sort.SliceStable(e.Changes, func(i, j int) bool {
+			return e.Changes[i].JsonPath < e.Changes[j].JsonPath
+		})
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return "", false, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Ref < entries[j].Ref
+	})
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return "", false, err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), true, nil
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"github.com/kluctl/kluctl/v2/pkg/types/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"testing"
+)
+
+func buildTestDiffResult(value string, reversed bool) *result.CommandResult {
+	objs := []result.ResultObject{
+		{BaseObject: result.BaseObject{
+			Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "cm1", Namespace: "ns"},
+			Changes: []result.Change{
+				{Type: "update", JsonPath: "data.k1", NewValue: &apiextensionsv1.JSON{Raw: []byte(`"` + value + `"`)}, UnifiedDiff: "-\n+" + value},
+				{Type: "update", JsonPath: "data.k2", NewValue: &apiextensionsv1.JSON{Raw: []byte(`"v"`)}},
+			},
+		}},
+		{BaseObject: result.BaseObject{
+			Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "cm2", Namespace: "ns"},
+			New: true,
+		}},
+		{BaseObject: result.BaseObject{
+			Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "unchanged", Namespace: "ns"},
+		}},
+	}
+	if reversed {
+		objs[0], objs[1] = objs[1], objs[0]
+		objs[1].Changes[0], objs[1].Changes[1] = objs[1].Changes[1], objs[1].Changes[0]
+	}
+	return &result.CommandResult{Objects: objs}
+}
+
+func TestCalcChangeSetHash(t *testing.T) {
+	g := NewWithT(t)
+
+	h1, hasChanges, err := calcChangeSetHash(buildTestDiffResult("v1", false))
+	g.Expect(err).To(Succeed())
+	g.Expect(hasChanges).To(BeTrue())
+	g.Expect(h1).To(HaveLen(64))
+
+	h2, _, err := calcChangeSetHash(buildTestDiffResult("v1", true))
+	g.Expect(err).To(Succeed())
+	g.Expect(h2).To(Equal(h1), "order of objects and changes must not matter")
+
+	h3, _, err := calcChangeSetHash(buildTestDiffResult("v2", false))
+	g.Expect(err).To(Succeed())
+	g.Expect(h3).ToNot(Equal(h1))
+
+	_, hasChanges, err = calcChangeSetHash(&result.CommandResult{Objects: []result.ResultObject{
+		{BaseObject: result.BaseObject{Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "unchanged"}}},
+	}})
+	g.Expect(err).To(Succeed())
+	g.Expect(hasChanges).To(BeFalse())
+}
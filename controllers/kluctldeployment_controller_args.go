@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/utils/uo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+)
+
+const argsFromIndexKey = ".spec.argsFrom"
+
+// buildArgs merges the args from spec.argsFrom and spec.args, in that order.
+func (r *KluctlDeploymentReconciler) buildArgs(ctx context.Context, obj *kluctlv1.KluctlDeployment) (*uo.UnstructuredObject, error) {
+	args := uo.New()
+
+	for _, ref := range obj.Spec.ArgsFrom {
+		data, err := r.getArgsFromData(ctx, obj.Namespace, ref)
+		if err != nil {
+			if apierrors.IsNotFound(err) && ref.Optional {
+				continue
+			}
+			return nil, err
+		}
+
+		key := ref.GetKey()
+		v, ok := data[key]
+		if !ok {
+			if ref.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("%s '%s' has no key '%s'", ref.Kind, ref.Name, key)
+		}
+
+		if ref.TargetPath != "" {
+			var path []interface{}
+			for _, x := range strings.Split(ref.TargetPath, ".") {
+				path = append(path, x)
+			}
+			err = args.SetNestedField(string(v), path...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to set args from %s '%s' at '%s': %w", ref.Kind, ref.Name, ref.TargetPath, err)
+			}
+		} else {
+			o, err := uo.FromString(string(v))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse args from key '%s' of %s '%s': %w", key, ref.Kind, ref.Name, err)
+			}
+			args.Merge(o)
+		}
+	}
+
+	inlineArgs, err := uo.FromString(string(obj.Spec.Args.Raw))
+	if err != nil {
+		return nil, err
+	}
+	args.Merge(inlineArgs)
+
+	return args, nil
+}
+
+func (r *KluctlDeploymentReconciler) getArgsFromData(ctx context.Context, namespace string, ref kluctlv1.ArgsReference) (map[string][]byte, error) {
+	name := types.NamespacedName{
+		Namespace: namespace,
+		Name:      ref.Name,
+	}
+
+	switch ref.Kind {
+	case "ConfigMap":
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, name, &cm)
+		if err != nil {
+			return nil, err
+		}
+		data := map[string][]byte{}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		return data, nil
+	case "Secret":
+		var secret corev1.Secret
+		err := r.Get(ctx, name, &secret)
+		if err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	default:
+		return nil, fmt.Errorf("argsFrom kind '%s' not supported", ref.Kind)
+	}
+}
+
+// indexArgsFrom returns the index values for all ConfigMaps and Secrets referenced via spec.argsFrom
+func indexArgsFrom(o client.Object) []string {
+	obj, ok := o.(*kluctlv1.KluctlDeployment)
+	if !ok {
+		panic(fmt.Sprintf("Expected a KluctlDeployment, got %T", o))
+	}
+
+	var ret []string
+	for _, ref := range obj.Spec.ArgsFrom {
+		ret = append(ret, fmt.Sprintf("%s/%s", ref.Kind, ref.Name))
+	}
+	return ret
+}
+
+// requestsForArgsFrom returns a map function that enqueues all KluctlDeployments that reference the changed object
+// via spec.argsFrom
+func (r *KluctlDeploymentReconciler) requestsForArgsFrom(kind string) func(ctx context.Context, o client.Object) []reconcile.Request {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		var list kluctlv1.KluctlDeploymentList
+		err := r.List(ctx, &list, client.InNamespace(o.GetNamespace()), client.MatchingFields{
+			argsFromIndexKey: fmt.Sprintf("%s/%s", kind, o.GetName()),
+		})
+		if err != nil {
+			return nil
+		}
+
+		var reqs []reconcile.Request
+		for _, d := range list.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&d)})
+		}
+		return reqs
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/hashicorp/go-retryablehttp"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// untarLimits limits the extraction of tarballs, so that a small compressed artifact can not fill up the disk. Zero
+// values disable the respective limit.
+type untarLimits struct {
+	// maxSize is the maximum number of bytes of all extracted files
+	maxSize int64
+	// maxFiles is the maximum number of entries in the tarball
+	maxFiles int
+}
+
+// artifactUntarLimits are applied when extracting artifacts of Flux sources
+var artifactUntarLimits = untarLimits{
+	maxSize:  100 << 20,
+	maxFiles: 10000,
+}
+
+// fetchArtifact downloads the given artifact via the retryable http client, verifies its digest and extracts the
+// tarball into targetDir. tmpDir is used to store the downloaded tarball.
+func (r *KluctlDeploymentReconciler) fetchArtifact(ctx context.Context, artifact *sourcev1.Artifact, tmpDir string, targetDir string) error {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, artifact.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create a new request: %w", err)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download artifact, error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download artifact from %s, status: %s", artifact.URL, resp.Status)
+	}
+
+	f, err := os.CreateTemp(tmpDir, "artifact-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h, expected, err := newArtifactHasher(artifact)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return fmt.Errorf("failed to download artifact: %w", err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("failed to verify artifact: computed digest '%s' doesn't match advertised '%s'", actual, expected)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := untar(f, targetDir, artifactUntarLimits); err != nil {
+		return fmt.Errorf("failed to extract artifact: %w", err)
+	}
+	return nil
+}
+
+// newArtifactHasher returns a hash and the expected hex encoded digest for the given artifact. The digest is taken
+// from Artifact.Digest and falls back to the deprecated Artifact.Checksum (which is always sha256).
+func newArtifactHasher(artifact *sourcev1.Artifact) (hash.Hash, string, error) {
+	if artifact.Digest == "" {
+		return sha256.New(), artifact.Checksum, nil
+	}
+	algo, digest, ok := strings.Cut(artifact.Digest, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid artifact digest '%s'", artifact.Digest)
+	}
+	switch algo {
+	case "sha256":
+		return sha256.New(), digest, nil
+	case "sha384":
+		return sha512.New384(), digest, nil
+	case "sha512":
+		return sha512.New(), digest, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported artifact digest algorithm '%s'", algo)
+	}
+}
+
+// untar extracts a gzipped tarball into targetDir. Only directories and regular files are extracted, all other
+// entries (e.g. symlinks) are ignored. Extraction fails as soon as the tarball exceeds the given limits.
+func untar(r io.Reader, targetDir string, limits untarLimits) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	err = os.MkdirAll(targetDir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	var files int
+	var size int64
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		files++
+		if limits.maxFiles != 0 && files > limits.maxFiles {
+			return fmt.Errorf("tarball contains more than %d files", limits.maxFiles)
+		}
+
+		p, err := securejoin.SecureJoin(targetDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+				return err
+			}
+			var fr io.Reader = tr
+			if limits.maxSize != 0 {
+				// read one byte more than allowed to detect files that exceed the limit
+				fr = io.LimitReader(tr, limits.maxSize-size+1)
+			}
+			n, err := writeTarFile(fr, p, os.FileMode(header.Mode).Perm()|0o600)
+			if err != nil {
+				return err
+			}
+			size += n
+			if limits.maxSize != 0 && size > limits.maxSize {
+				return fmt.Errorf("tarball exceeds the maximum extracted size of %d bytes", limits.maxSize)
+			}
+		}
+	}
+	return nil
+}
+
+func writeTarFile(r io.Reader, p string, mode os.FileMode) (int64, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(f, r)
+}
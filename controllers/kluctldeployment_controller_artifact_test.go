@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/hashicorp/go-retryablehttp"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildTestTarball(t *testing.T, files map[string]string) []byte {
+	buf := bytes.NewBuffer(nil)
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = tw.Close()
+	_ = gzw.Close()
+	return buf.Bytes()
+}
+
+func TestFetchArtifact(t *testing.T) {
+	g := NewWithT(t)
+
+	tarball := buildTestTarball(t, map[string]string{
+		".kluctl.yaml":        "targets: []\n",
+		"d1/deployment.yaml":  "resources: []\n",
+		"../outside/evil.txt": "evil",
+	})
+	h := sha256.Sum256(tarball)
+	digest := hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/artifact.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(tarball)
+	}))
+	defer server.Close()
+
+	httpClient := retryablehttp.NewClient()
+	httpClient.RetryMax = 0
+	httpClient.Logger = nil
+	r := &KluctlDeploymentReconciler{httpClient: httpClient}
+
+	t.Run("valid digest", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		targetDir := filepath.Join(tmpDir, "source")
+		err := r.fetchArtifact(context.TODO(), &sourcev1.Artifact{
+			URL:    server.URL + "/artifact.tar.gz",
+			Digest: "sha256:" + digest,
+		}, tmpDir, targetDir)
+		g.Expect(err).To(Succeed())
+		g.Expect(filepath.Join(targetDir, ".kluctl.yaml")).To(BeAnExistingFile())
+		g.Expect(filepath.Join(targetDir, "d1/deployment.yaml")).To(BeAnExistingFile())
+		// must be extracted into targetDir instead of escaping it
+		g.Expect(filepath.Join(targetDir, "outside/evil.txt")).To(BeAnExistingFile())
+		_, err = os.Stat(filepath.Join(tmpDir, "outside"))
+		g.Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	t.Run("deprecated checksum", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		err := r.fetchArtifact(context.TODO(), &sourcev1.Artifact{
+			URL:      server.URL + "/artifact.tar.gz",
+			Checksum: digest,
+		}, tmpDir, filepath.Join(tmpDir, "source"))
+		g.Expect(err).To(Succeed())
+	})
+
+	t.Run("invalid digest", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		err := r.fetchArtifact(context.TODO(), &sourcev1.Artifact{
+			URL:    server.URL + "/artifact.tar.gz",
+			Digest: "sha256:0000",
+		}, tmpDir, filepath.Join(tmpDir, "source"))
+		g.Expect(err).To(MatchError(ContainSubstring("failed to verify artifact")))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		err := r.fetchArtifact(context.TODO(), &sourcev1.Artifact{
+			URL:    server.URL + "/missing.tar.gz",
+			Digest: "sha256:" + digest,
+		}, tmpDir, filepath.Join(tmpDir, "source"))
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestUntarLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	tarball := buildTestTarball(t, map[string]string{
+		"a.txt": strings.Repeat("a", 100),
+		"b.txt": strings.Repeat("b", 100),
+	})
+
+	tests := []struct {
+		name          string
+		limits        untarLimits
+		expectedError string
+	}{
+		{name: "no limits", limits: untarLimits{}},
+		{name: "within limits", limits: untarLimits{maxSize: 200, maxFiles: 2}},
+		{name: "too large", limits: untarLimits{maxSize: 199}, expectedError: "maximum extracted size of 199 bytes"},
+		{name: "too many files", limits: untarLimits{maxFiles: 1}, expectedError: "more than 1 files"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			targetDir := t.TempDir()
+			err := untar(bytes.NewReader(tarball), targetDir, tc.limits)
+			if tc.expectedError == "" {
+				g.Expect(err).To(Succeed())
+				g.Expect(filepath.Join(targetDir, "a.txt")).To(BeAnExistingFile())
+				g.Expect(filepath.Join(targetDir, "b.txt")).To(BeAnExistingFile())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedError)))
+			}
+		})
+	}
+}
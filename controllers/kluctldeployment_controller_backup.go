@@ -0,0 +1,469 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	k8s2 "github.com/kluctl/kluctl/v2/pkg/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/types/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/utils/uo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// backupList is the format of backups. It is a v1 List, so that decompressed backups can also be applied manually.
+type backupList struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Items      []map[string]any `json:"items"`
+}
+
+// backupError is returned when the backup of objects that are about to be deleted failed
+type backupError struct {
+	err error
+}
+
+func (e *backupError) Error() string {
+	return fmt.Sprintf("backup failed: %s", e.err.Error())
+}
+
+func (e *backupError) Unwrap() error {
+	return e.err
+}
+
+// isBackupError checks if err or any of the aggregated errors is a backupError
+func isBackupError(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isBackupError(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var be *backupError
+	return errors.As(err, &be)
+}
+
+// buildBackupLabelValue returns the value of the backup-of label. Labels are limited to 63 characters, while
+// KluctlDeployment names can be longer.
+func buildBackupLabelValue(obj *kluctlv1.KluctlDeployment) string {
+	if len(obj.Name) <= 63 {
+		return obj.Name
+	}
+	h := sha256.Sum256([]byte(obj.Name))
+	return hex.EncodeToString(h[:])[:63]
+}
+
+// backupObjects stores the live manifests of the given objects in a new backup object, as specified by spec.backup.
+// It is called right before the objects are deleted, and an error aborts the deletion.
+func (pt *preparedTarget) backupObjects(ctx context.Context, k *k8s2.K8sCluster, command string, refs []k8s.ObjectRef, owned bool) error {
+	obj := pt.pp.obj
+	if obj.Spec.Backup == nil || len(refs) == 0 || pt.pp.r.DryRun || obj.Spec.DryRun {
+		return nil
+	}
+
+	var items []map[string]any
+	for _, ref := range refs {
+		o, _, err := k.GetSingleObject(ref)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get %s for backup: %w", ref.String(), err)
+		}
+		items = append(items, cleanupBackupObject(o.Object))
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	data, err := encodeBackup(items)
+	if err != nil {
+		return fmt.Errorf("failed to encode backup: %w", err)
+	}
+
+	br, err := pt.pp.r.createBackup(ctx, obj, getBackupKind(obj, items), command, splitBackupData(data, kluctlv1.MaxBackupSize), owned)
+	if err != nil {
+		return fmt.Errorf("failed to store backup: %w", err)
+	}
+	br.Target = obj.Spec.Target
+	br.Revision = pt.pp.sourceRevision
+	br.Objects = len(items)
+
+	// delete backups happen while the KluctlDeployment is finalized, so its status would never be persisted. These
+	// backups can only be found via the event and the backup-of label.
+	if owned {
+		obj.Status.Backups = append([]kluctlv1.BackupRef{*br}, obj.Status.Backups...)
+		if len(obj.Status.Backups) > obj.Spec.GetBackupRetention() {
+			obj.Status.Backups = obj.Status.Backups[:obj.Spec.GetBackupRetention()]
+		}
+	}
+	if err := pt.pp.r.cleanupBackups(ctx, obj); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to cleanup old backups")
+	}
+
+	pt.pp.r.event(ctx, obj, pt.pp.sourceRevision, false,
+		fmt.Sprintf("backed up %d objects to %s/%s before %s", len(items), br.Kind, br.Name, command), nil)
+	return nil
+}
+
+// getBackupKind returns the kind of the backup object for the given items. Backups containing Secrets are always
+// stored in a Secret, as a ConfigMap would expose them to everyone that is allowed to read ConfigMaps.
+func getBackupKind(obj *kluctlv1.KluctlDeployment, items []map[string]any) string {
+	for _, item := range items {
+		if item["apiVersion"] == "v1" && item["kind"] == "Secret" {
+			return kluctlv1.BackupKindSecret
+		}
+	}
+	return obj.Spec.GetBackupKind()
+}
+
+// cleanupBackupObject removes all fields from a live object that must not be set when the object is re-applied
+func cleanupBackupObject(o map[string]any) map[string]any {
+	delete(o, "status")
+	if m, ok := o["metadata"].(map[string]any); ok {
+		for _, f := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp",
+			"deletionGracePeriodSeconds", "managedFields", "selfLink"} {
+			delete(m, f)
+		}
+		if a, ok := m["annotations"].(map[string]any); ok {
+			delete(a, "kubectl.kubernetes.io/last-applied-configuration")
+			if len(a) == 0 {
+				delete(m, "annotations")
+			}
+		}
+	}
+	return o
+}
+
+func encodeBackup(items []map[string]any) ([]byte, error) {
+	b, err := yaml.Marshal(&backupList{APIVersion: "v1", Kind: "List", Items: items})
+	if err != nil {
+		return nil, err
+	}
+	return compressCommandResult(string(b))
+}
+
+func decodeBackup(data []byte) ([]map[string]any, error) {
+	s, err := decompressCommandResult(data)
+	if err != nil {
+		return nil, err
+	}
+	var l backupList
+	err = yaml.Unmarshal([]byte(s), &l)
+	if err != nil {
+		return nil, err
+	}
+	return l.Items, nil
+}
+
+// splitBackupData splits the compressed objects into parts of at most maxSize bytes
+func splitBackupData(data []byte, maxSize int) [][]byte {
+	var parts [][]byte
+	for len(data) > maxSize {
+		parts = append(parts, data[:maxSize])
+		data = data[maxSize:]
+	}
+	return append(parts, data)
+}
+
+func newBackupObject(kind string, objMeta metav1.ObjectMeta, data []byte) (client.Object, error) {
+	switch kind {
+	case kluctlv1.BackupKindSecret:
+		return &corev1.Secret{
+			ObjectMeta: objMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				kluctlv1.BackupObjectsKey: data,
+			},
+		}, nil
+	case kluctlv1.BackupKindConfigMap:
+		return &corev1.ConfigMap{
+			ObjectMeta: objMeta,
+			BinaryData: map[string][]byte{
+				kluctlv1.BackupObjectsKey: data,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("backup kind '%s' not supported", kind)
+	}
+}
+
+// createBackup creates a Secret or ConfigMap with the given parts of the compressed objects. The first part is stored
+// in the backup object itself, all following parts are stored in objects that are owned by the backup object, so that
+// they are garbage-collected together with it. Backups are not labelled with the KluctlDeployment UID, as
+// cleanupCommandResults would otherwise garbage-collect them.
+func (r *KluctlDeploymentReconciler) createBackup(ctx context.Context, obj *kluctlv1.KluctlDeployment, kind string, command string, parts [][]byte, owned bool) (*kluctlv1.BackupRef, error) {
+	objMeta := metav1.ObjectMeta{
+		GenerateName: fmt.Sprintf("%s-%s-backup-", obj.Name, command),
+		Namespace:    obj.Namespace,
+		Labels: map[string]string{
+			kluctlv1.KluctlBackupOfLabel: buildBackupLabelValue(obj),
+			kluctlv1.KluctlCommandLabel:  command,
+		},
+	}
+	if len(parts) > 1 {
+		objMeta.Annotations = map[string]string{
+			kluctlv1.KluctlBackupPartsAnnotation: strconv.Itoa(len(parts)),
+		}
+	}
+
+	o, err := newBackupObject(kind, objMeta, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if owned {
+		err := controllerutil.SetOwnerReference(obj, o, r.Scheme)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = r.Create(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(parts); i++ {
+		err = r.createBackupPart(ctx, o, kind, i, parts[i])
+		if err != nil {
+			// the already created parts are garbage-collected with the incomplete backup
+			_ = r.Delete(ctx, o)
+			return nil, fmt.Errorf("failed to create part %d of backup %s: %w", i, o.GetName(), err)
+		}
+	}
+
+	return &kluctlv1.BackupRef{
+		ResultRef: kluctlv1.ResultRef{
+			Kind: kind,
+			Name: o.GetName(),
+		},
+		Command:   command,
+		CreatedAt: metav1.Now(),
+	}, nil
+}
+
+func (r *KluctlDeploymentReconciler) createBackupPart(ctx context.Context, backup client.Object, kind string, i int, data []byte) error {
+	o, err := newBackupObject(kind, metav1.ObjectMeta{
+		Name:      buildBackupPartName(backup.GetName(), i),
+		Namespace: backup.GetNamespace(),
+		Labels: map[string]string{
+			kluctlv1.KluctlCommandLabel: backup.GetLabels()[kluctlv1.KluctlCommandLabel],
+		},
+	}, data)
+	if err != nil {
+		return err
+	}
+	err = controllerutil.SetOwnerReference(backup, o, r.Scheme)
+	if err != nil {
+		return err
+	}
+	return r.Create(ctx, o)
+}
+
+func buildBackupPartName(name string, i int) string {
+	return fmt.Sprintf("%s-part-%d", name, i)
+}
+
+// cleanupBackups deletes the oldest backup objects that exceed spec.backup.retention. This includes unowned backups
+// of a previously deleted KluctlDeployment with the same name.
+func (r *KluctlDeploymentReconciler) cleanupBackups(ctx context.Context, obj *kluctlv1.KluctlDeployment) error {
+	opts := []client.ListOption{
+		client.InNamespace(obj.Namespace),
+		client.MatchingLabels{kluctlv1.KluctlBackupOfLabel: buildBackupLabelValue(obj)},
+	}
+
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, opts...); err != nil {
+		return err
+	}
+	var configMaps corev1.ConfigMapList
+	if err := r.List(ctx, &configMaps, opts...); err != nil {
+		return err
+	}
+
+	var objs []client.Object
+	for i := range secrets.Items {
+		objs = append(objs, &secrets.Items[i])
+	}
+	for i := range configMaps.Items {
+		objs = append(objs, &configMaps.Items[i])
+	}
+
+	// newest first
+	sort.SliceStable(objs, func(i, j int) bool {
+		ti := objs[i].GetCreationTimestamp()
+		tj := objs[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return objs[i].GetName() > objs[j].GetName()
+	})
+
+	retention := obj.Spec.GetBackupRetention()
+	for i, x := range objs {
+		if i < retention {
+			continue
+		}
+		err := r.Delete(ctx, x)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getBackupObject returns the Secret or ConfigMap with the given name and the data stored in it
+func (r *KluctlDeploymentReconciler) getBackupObject(ctx context.Context, key client.ObjectKey) (client.Object, []byte, error) {
+	var secret corev1.Secret
+	err := r.Get(ctx, key, &secret)
+	if err == nil {
+		return &secret, secret.Data[kluctlv1.BackupObjectsKey], nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, nil, err
+	}
+	var configMap corev1.ConfigMap
+	err = r.Get(ctx, key, &configMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &configMap, configMap.BinaryData[kluctlv1.BackupObjectsKey], nil
+}
+
+// loadBackup loads the objects of the backup object with the given name, including all of its parts. Only Secrets and
+// ConfigMaps that are labelled as backups of the given KluctlDeployment are accepted.
+func (r *KluctlDeploymentReconciler) loadBackup(ctx context.Context, obj *kluctlv1.KluctlDeployment, name string) ([]map[string]any, error) {
+	o, data, err := r.getBackupObject(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.GetLabels()[kluctlv1.KluctlBackupOfLabel] != buildBackupLabelValue(obj) {
+		return nil, fmt.Errorf("%s is not a backup of this KluctlDeployment", name)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%s does not contain %s", name, kluctlv1.BackupObjectsKey)
+	}
+
+	parts := 1
+	if v, ok := o.GetAnnotations()[kluctlv1.KluctlBackupPartsAnnotation]; ok {
+		parts, err = strconv.Atoi(v)
+		if err != nil || parts < 1 {
+			return nil, fmt.Errorf("%s has an invalid %s annotation: %s", name, kluctlv1.KluctlBackupPartsAnnotation, v)
+		}
+	}
+	for i := 1; i < parts; i++ {
+		partName := buildBackupPartName(name, i)
+		part, partData, err := r.getBackupObject(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: partName})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get part %d of backup %s: %w", i, name, err)
+		}
+		if !isOwnedBy(part, o) {
+			return nil, fmt.Errorf("%s is not a part of backup %s", partName, name)
+		}
+		if partData == nil {
+			return nil, fmt.Errorf("%s does not contain %s", partName, kluctlv1.BackupObjectsKey)
+		}
+		data = append(data, partData...)
+	}
+
+	return decodeBackup(data)
+}
+
+func isOwnedBy(o client.Object, owner client.Object) bool {
+	for _, ref := range o.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupRestoredObject removes the labels that kluctl uses to track the objects it deployed, e.g. the discriminator.
+// Otherwise, the next prune would delete restored objects that are not rendered by the project anymore. Objects that
+// are still rendered get these labels again with the next deployment.
+func cleanupRestoredObject(o map[string]any) map[string]any {
+	m, ok := o["metadata"].(map[string]any)
+	if !ok {
+		return o
+	}
+	if l, ok := m["labels"].(map[string]any); ok {
+		for k := range l {
+			if strings.HasPrefix(k, "kluctl.io/") {
+				delete(l, k)
+			}
+		}
+		if len(l) == 0 {
+			delete(m, "labels")
+		}
+	}
+	return o
+}
+
+// checkRequestedRestore returns the name of the backup to restore if the restoreBackup annotation was not handled yet
+func checkRequestedRestore(obj *kluctlv1.KluctlDeployment) (string, bool) {
+	v, ok := obj.GetAnnotations()[kluctlv1.KluctlRestoreBackupAnnotation]
+	if !ok || v == "" || v == obj.Status.LastHandledRestore {
+		return "", false
+	}
+	return v, true
+}
+
+// restoreBackup re-applies all objects of the given backup to the target cluster and records the outcome in
+// status.lastRestoreResult
+func (r *KluctlDeploymentReconciler) restoreBackup(ctx context.Context, pp *preparedProject, name string) {
+	obj := pp.obj
+	obj.Status.LastHandledRestore = name
+
+	rr := &kluctlv1.RestoreResult{
+		Backup:     name,
+		RestoredAt: metav1.Now(),
+	}
+	obj.Status.LastRestoreResult = rr
+
+	var errs []error
+	items, err := r.loadBackup(ctx, obj, name)
+	var k *k8s2.K8sCluster
+	if err == nil {
+		var pt *preparedTarget
+		pt, err = pp.newTarget()
+		if err == nil {
+			k, err = pt.newK8sCluster(ctx)
+		}
+	}
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		for _, item := range items {
+			x := uo.FromMap(cleanupRestoredObject(item))
+			_, _, err := k.ApplyObject(x, k8s2.PatchOptions{ForceApply: true})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore %s: %w", x.GetK8sRef().String(), err))
+				continue
+			}
+			rr.RestoredObjects++
+		}
+	}
+
+	if len(errs) != 0 {
+		rr.Error = trimString(utilerrors.NewAggregate(errs).Error(), kluctlv1.MaxConditionMessageLength)
+		r.event(ctx, obj, pp.sourceRevision, true, fmt.Sprintf("restore of backup %s failed: %s", name, rr.Error), nil)
+		return
+	}
+	r.event(ctx, obj, pp.sourceRevision, false, fmt.Sprintf("restored %d objects from backup %s", rr.RestoredObjects, name), nil)
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBackupEncoding(t *testing.T) {
+	g := NewWithT(t)
+
+	o := cleanupBackupObject(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":            "cm1",
+			"namespace":       "ns",
+			"uid":             "1234",
+			"resourceVersion": "42",
+			"managedFields":   []any{map[string]any{"manager": "kluctl"}},
+			"labels":          map[string]any{"app": "x"},
+			"annotations": map[string]any{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+		},
+		"data":   map[string]any{"k1": "v1"},
+		"status": map[string]any{"x": "y"},
+	})
+	g.Expect(o).ToNot(HaveKey("status"))
+	g.Expect(o["metadata"]).To(Equal(map[string]any{
+		"name":      "cm1",
+		"namespace": "ns",
+		"labels":    map[string]any{"app": "x"},
+	}))
+
+	data, err := encodeBackup([]map[string]any{o})
+	g.Expect(err).ToNot(HaveOccurred())
+	s, err := decompressCommandResult(data)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s).To(HavePrefix("apiVersion: v1\nitems:"))
+	g.Expect(s).To(ContainSubstring("kind: List"))
+
+	items, err := decodeBackup(data)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(items).To(Equal([]map[string]any{o}))
+}
+
+func TestGetBackupKind(t *testing.T) {
+	g := NewWithT(t)
+
+	cm := map[string]any{"apiVersion": "v1", "kind": "ConfigMap"}
+	secret := map[string]any{"apiVersion": "v1", "kind": "Secret"}
+	otherSecret := map[string]any{"apiVersion": "example.com/v1", "kind": "Secret"}
+
+	obj := &kluctlv1.KluctlDeployment{
+		Spec: kluctlv1.KluctlDeploymentSpec{Backup: &kluctlv1.Backup{}},
+	}
+	g.Expect(getBackupKind(obj, []map[string]any{cm})).To(Equal(kluctlv1.BackupKindSecret))
+
+	obj.Spec.Backup.Kind = kluctlv1.BackupKindConfigMap
+	g.Expect(getBackupKind(obj, []map[string]any{cm, otherSecret})).To(Equal(kluctlv1.BackupKindConfigMap))
+	g.Expect(getBackupKind(obj, []map[string]any{cm, secret})).To(Equal(kluctlv1.BackupKindSecret))
+}
+
+func TestSplitBackupData(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(splitBackupData([]byte("abc"), 3)).To(Equal([][]byte{[]byte("abc")}))
+	g.Expect(splitBackupData([]byte("abcdefg"), 3)).To(Equal([][]byte{[]byte("abc"), []byte("def"), []byte("g")}))
+}
+
+func TestBackupParts(t *testing.T) {
+	g := NewWithT(t)
+
+	s := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+	g.Expect(kluctlv1.AddToScheme(s)).To(Succeed())
+	r := &KluctlDeploymentReconciler{
+		Client: fake.NewClientBuilder().WithScheme(s).Build(),
+		Scheme: s,
+	}
+
+	obj := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "ns", UID: "1234"},
+	}
+
+	var items []map[string]any
+	for i := 0; i < 100; i++ {
+		items = append(items, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]any{"name": fmt.Sprintf("cm%d", i), "namespace": "ns"},
+			"data":       map[string]any{"k1": fmt.Sprintf("v%d", i)},
+		})
+	}
+	data, err := encodeBackup(items)
+	g.Expect(err).ToNot(HaveOccurred())
+	parts := splitBackupData(data, 100)
+	g.Expect(len(parts)).To(BeNumerically(">", 2))
+
+	br, err := r.createBackup(context.TODO(), obj, kluctlv1.BackupKindConfigMap, "prune", parts, true)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var cm corev1.ConfigMap
+	g.Expect(r.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: br.Name}, &cm)).To(Succeed())
+	g.Expect(cm.Annotations[kluctlv1.KluctlBackupPartsAnnotation]).To(Equal(strconv.Itoa(len(parts))))
+
+	var part corev1.ConfigMap
+	g.Expect(r.Get(context.TODO(), client.ObjectKey{Namespace: "ns", Name: br.Name + "-part-1"}, &part)).To(Succeed())
+	g.Expect(part.Labels).ToNot(HaveKey(kluctlv1.KluctlBackupOfLabel))
+	g.Expect(part.OwnerReferences).To(HaveLen(1))
+	g.Expect(part.OwnerReferences[0].Name).To(Equal(br.Name))
+
+	loaded, err := r.loadBackup(context.TODO(), obj, br.Name)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loaded).To(Equal(items))
+
+	g.Expect(r.Delete(context.TODO(), &part)).To(Succeed())
+	_, err = r.loadBackup(context.TODO(), obj, br.Name)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to get part 1 of backup")))
+}
+
+func TestCleanupRestoredObject(t *testing.T) {
+	g := NewWithT(t)
+
+	o := cleanupRestoredObject(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name": "cm1",
+			"labels": map[string]any{
+				"kluctl.io/discriminator": "example",
+				"kluctl.io/tag-0":         "cm1",
+				"app":                     "x",
+			},
+		},
+	})
+	g.Expect(o["metadata"]).To(Equal(map[string]any{
+		"name":   "cm1",
+		"labels": map[string]any{"app": "x"},
+	}))
+
+	o = cleanupRestoredObject(map[string]any{
+		"metadata": map[string]any{
+			"name":   "cm2",
+			"labels": map[string]any{"kluctl.io/discriminator": "example"},
+		},
+	})
+	g.Expect(o["metadata"]).To(Equal(map[string]any{"name": "cm2"}))
+}
+
+func TestIsBackupError(t *testing.T) {
+	g := NewWithT(t)
+
+	be := &backupError{err: errors.New("failed to store backup")}
+	g.Expect(be.Error()).To(Equal("backup failed: failed to store backup"))
+	g.Expect(isBackupError(nil)).To(BeFalse())
+	g.Expect(isBackupError(errors.New("cluster unreachable"))).To(BeFalse())
+	g.Expect(isBackupError(be)).To(BeTrue())
+	g.Expect(isBackupError(fmt.Errorf("delete failed: %w", be))).To(BeTrue())
+	g.Expect(isBackupError(utilerrors.NewAggregate([]error{errors.New("cluster unreachable"), be}))).To(BeTrue())
+	g.Expect(isBackupError(utilerrors.NewAggregate([]error{errors.New("cluster unreachable")}))).To(BeFalse())
+}
+
+func TestBuildBackupLabelValue(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{ObjectMeta: metav1.ObjectMeta{Name: "name"}}
+	g.Expect(buildBackupLabelValue(obj)).To(Equal("name"))
+
+	obj.Name = strings.Repeat("x", 100)
+	v := buildBackupLabelValue(obj)
+	g.Expect(v).To(HaveLen(63))
+	g.Expect(v).ToNot(Equal(obj.Name[:63]))
+}
+
+func TestCheckRequestedRestore(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{}
+	_, ok := checkRequestedRestore(obj)
+	g.Expect(ok).To(BeFalse())
+
+	obj.Annotations = map[string]string{kluctlv1.KluctlRestoreBackupAnnotation: "name-prune-backup-abcde"}
+	name, ok := checkRequestedRestore(obj)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(name).To(Equal("name-prune-backup-abcde"))
+
+	obj.Status.LastHandledRestore = name
+	_, ok = checkRequestedRestore(obj)
+	g.Expect(ok).To(BeFalse())
+}
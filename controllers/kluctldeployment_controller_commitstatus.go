@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	types2 "github.com/kluctl/kluctl/v2/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type commitStatusState string
+
+const (
+	commitStatusPending commitStatusState = "pending"
+	commitStatusSuccess commitStatusState = "success"
+	commitStatusFailure commitStatusState = "failure"
+)
+
+// commitStatusMaxDescriptionLength is the maximum length of descriptions accepted by all providers
+const commitStatusMaxDescriptionLength = 140
+
+var commitStatusHttpClient = &http.Client{Timeout: 30 * time.Second}
+
+type commitStatus struct {
+	commit      string
+	name        string
+	state       commitStatusState
+	description string
+}
+
+// commitStatusProvider posts commit statuses to a git hosting provider
+type commitStatusProvider interface {
+	postStatus(ctx context.Context, s commitStatus) error
+}
+
+// newCommitStatusProvider creates the provider specified by spec for the repository at repoUrl
+func newCommitStatusProvider(spec *kluctlv1.CommitStatus, repoUrl *url.URL, secret *corev1.Secret) (commitStatusProvider, error) {
+	repoPath := strings.TrimSuffix(strings.Trim(repoUrl.Path, "/"), ".git")
+	if repoPath == "" {
+		return nil, fmt.Errorf("failed to determine repository path from url")
+	}
+	address := strings.TrimSuffix(spec.Address, "/")
+
+	switch spec.Provider {
+	case kluctlv1.CommitStatusProviderGitHub:
+		if address == "" {
+			if repoUrl.Hostname() == "github.com" {
+				address = "https://api.github.com"
+			} else {
+				address = fmt.Sprintf("https://%s/api/v3", repoUrl.Hostname())
+			}
+		}
+		return newGitHubCommitStatusProvider(address, repoPath, secret)
+	case kluctlv1.CommitStatusProviderGitLab:
+		if address == "" {
+			address = fmt.Sprintf("https://%s", repoUrl.Hostname())
+		}
+		return newGitLabCommitStatusProvider(address, repoPath, secret)
+	case kluctlv1.CommitStatusProviderGitea:
+		if address == "" {
+			address = fmt.Sprintf("https://%s", repoUrl.Hostname())
+		}
+		return newGiteaCommitStatusProvider(address, repoPath, secret)
+	case kluctlv1.CommitStatusProviderBitbucket:
+		if address == "" {
+			address = "https://api.bitbucket.org"
+		}
+		return newBitbucketCommitStatusProvider(address, repoPath, secret)
+	default:
+		return nil, fmt.Errorf("commit status provider '%s' not supported", spec.Provider)
+	}
+}
+
+func getSecretValue(secret *corev1.Secret, key string) (string, error) {
+	v, ok := secret.Data[key]
+	if !ok || len(v) == 0 {
+		return "", fmt.Errorf("secret '%s' has no '%s' key", secret.Name, key)
+	}
+	return strings.TrimSpace(string(v)), nil
+}
+
+func splitOwnerRepo(repoPath string) (string, string, error) {
+	s := strings.Split(repoPath, "/")
+	if len(s) != 2 {
+		return "", "", fmt.Errorf("repository path '%s' is not in the form owner/repo", repoPath)
+	}
+	return s[0], s[1], nil
+}
+
+func postCommitStatusJson(ctx context.Context, u string, body any, setAuth func(req *http.Request)) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	setAuth(req)
+
+	resp, err := commitStatusHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("posting commit status to %s failed with status %s", u, resp.Status)
+	}
+	return nil
+}
+
+type gitHubCommitStatusProvider struct {
+	address string
+	owner   string
+	repo    string
+	token   string
+}
+
+func newGitHubCommitStatusProvider(address string, repoPath string, secret *corev1.Secret) (*gitHubCommitStatusProvider, error) {
+	owner, repo, err := splitOwnerRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	token, err := getSecretValue(secret, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &gitHubCommitStatusProvider{address: address, owner: owner, repo: repo, token: token}, nil
+}
+
+func (p *gitHubCommitStatusProvider) postStatus(ctx context.Context, s commitStatus) error {
+	u := fmt.Sprintf("%s/repos/%s/%s/statuses/%s", p.address, url.PathEscape(p.owner), url.PathEscape(p.repo), s.commit)
+	body := map[string]string{
+		"state":       string(s.state),
+		"context":     s.name,
+		"description": s.description,
+	}
+	return postCommitStatusJson(ctx, u, body, func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	})
+}
+
+type gitLabCommitStatusProvider struct {
+	address  string
+	repoPath string
+	token    string
+}
+
+func newGitLabCommitStatusProvider(address string, repoPath string, secret *corev1.Secret) (*gitLabCommitStatusProvider, error) {
+	token, err := getSecretValue(secret, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &gitLabCommitStatusProvider{address: address, repoPath: repoPath, token: token}, nil
+}
+
+func (p *gitLabCommitStatusProvider) postStatus(ctx context.Context, s commitStatus) error {
+	// GitLab uses 'running' and 'failed' instead of 'pending' and 'failure'
+	state := map[commitStatusState]string{
+		commitStatusPending: "running",
+		commitStatusSuccess: "success",
+		commitStatusFailure: "failed",
+	}[s.state]
+
+	u := fmt.Sprintf("%s/api/v4/projects/%s/statuses/%s", p.address, url.PathEscape(p.repoPath), s.commit)
+	body := map[string]string{
+		"state":       state,
+		"name":        s.name,
+		"description": s.description,
+	}
+	return postCommitStatusJson(ctx, u, body, func(req *http.Request) {
+		req.Header.Set("PRIVATE-TOKEN", p.token)
+	})
+}
+
+type giteaCommitStatusProvider struct {
+	address string
+	owner   string
+	repo    string
+	token   string
+}
+
+func newGiteaCommitStatusProvider(address string, repoPath string, secret *corev1.Secret) (*giteaCommitStatusProvider, error) {
+	owner, repo, err := splitOwnerRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	token, err := getSecretValue(secret, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &giteaCommitStatusProvider{address: address, owner: owner, repo: repo, token: token}, nil
+}
+
+func (p *giteaCommitStatusProvider) postStatus(ctx context.Context, s commitStatus) error {
+	u := fmt.Sprintf("%s/api/v1/repos/%s/%s/statuses/%s", p.address, url.PathEscape(p.owner), url.PathEscape(p.repo), s.commit)
+	body := map[string]string{
+		"state":       string(s.state),
+		"context":     s.name,
+		"description": s.description,
+	}
+	return postCommitStatusJson(ctx, u, body, func(req *http.Request) {
+		req.Header.Set("Authorization", "token "+p.token)
+	})
+}
+
+type bitbucketCommitStatusProvider struct {
+	address  string
+	owner    string
+	repo     string
+	username string
+	password string
+}
+
+func newBitbucketCommitStatusProvider(address string, repoPath string, secret *corev1.Secret) (*bitbucketCommitStatusProvider, error) {
+	owner, repo, err := splitOwnerRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	username, err := getSecretValue(secret, "username")
+	if err != nil {
+		return nil, err
+	}
+	password, err := getSecretValue(secret, "password")
+	if err != nil {
+		return nil, err
+	}
+	return &bitbucketCommitStatusProvider{address: address, owner: owner, repo: repo, username: username, password: password}, nil
+}
+
+func (p *bitbucketCommitStatusProvider) postStatus(ctx context.Context, s commitStatus) error {
+	state := map[commitStatusState]string{
+		commitStatusPending: "INPROGRESS",
+		commitStatusSuccess: "SUCCESSFUL",
+		commitStatusFailure: "FAILED",
+	}[s.state]
+
+	// keys are limited to 40 characters
+	h := sha256.Sum256([]byte(s.name))
+	key := hex.EncodeToString(h[:])[:40]
+
+	u := fmt.Sprintf("%s/2.0/repositories/%s/%s/commit/%s/statuses/build", p.address, url.PathEscape(p.owner), url.PathEscape(p.repo), s.commit)
+	body := map[string]string{
+		"state":       state,
+		"key":         key,
+		"name":        s.name,
+		"description": s.description,
+		// Bitbucket requires a url
+		"url": fmt.Sprintf("https://bitbucket.org/%s/%s/commits/%s", p.owner, p.repo, s.commit),
+	}
+	return postCommitStatusJson(ctx, u, body, func(req *http.Request) {
+		req.SetBasicAuth(p.username, p.password)
+	})
+}
+
+// reportCommitStatus reports the state of the given phase (deploy or validate) as commit status for the checked out
+// commit. Failures are reported as warning events, as commit statuses must never break reconciliation.
+func (pt *preparedTarget) reportCommitStatus(ctx context.Context, phase string, state commitStatusState, description string) {
+	obj := pt.pp.obj
+	spec := obj.Spec.CommitStatus
+	if spec == nil || pt.pp.source == nil || pt.pp.source.URL == "" || pt.pp.sourceCommit == "" {
+		return
+	}
+
+	err := pt.doReportCommitStatus(ctx, spec, commitStatus{
+		commit:      pt.pp.sourceCommit,
+		name:        buildCommitStatusName(obj, phase),
+		state:       state,
+		description: trimString(description, commitStatusMaxDescriptionLength-3),
+	})
+	if err != nil {
+		pt.pp.r.event(ctx, obj, pt.pp.sourceRevision, true, fmt.Sprintf("failed to report commit status: %s", err.Error()), nil)
+	}
+}
+
+func (pt *preparedTarget) doReportCommitStatus(ctx context.Context, spec *kluctlv1.CommitStatus, s commitStatus) error {
+	pp := pt.pp
+	if pp.commitStatusProvider == nil {
+		secret, err := getGitSecret(ctx, pp.r.Client, &spec.SecretRef, pp.obj.GetNamespace())
+		if err != nil {
+			return err
+		}
+		gitUrl, err := types2.ParseGitUrl(pp.source.URL)
+		if err != nil {
+			return err
+		}
+		pp.commitStatusProvider, err = newCommitStatusProvider(spec, &gitUrl.URL, secret)
+		if err != nil {
+			return err
+		}
+	}
+	return pp.commitStatusProvider.postStatus(ctx, s)
+}
+
+// buildCommitStatusName builds the name (or context) of the commit status for the given phase
+func buildCommitStatusName(obj *kluctlv1.KluctlDeployment, phase string) string {
+	name := fmt.Sprintf("kluctl/%s/%s", obj.GetNamespace(), obj.GetName())
+	if obj.Spec.Target != nil {
+		name += "/" + *obj.Spec.Target
+	}
+	return name + "/" + phase
+}
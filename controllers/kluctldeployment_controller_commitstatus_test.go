@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type testCommitStatusRequest struct {
+	path   string
+	header http.Header
+	body   map[string]string
+}
+
+func startTestCommitStatusServer(t *testing.T) (*httptest.Server, *[]testCommitStatusRequest) {
+	var requests []testCommitStatusRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, testCommitStatusRequest{
+			path:   r.URL.EscapedPath(),
+			header: r.Header,
+			body:   body,
+		})
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestCommitStatusProviders(t *testing.T) {
+	repoUrl, _ := url.Parse("https://example.com/org/repo.git")
+	tokenSecret := &corev1.Secret{Data: map[string][]byte{"token": []byte("t1")}}
+	basicSecret := &corev1.Secret{Data: map[string][]byte{"username": []byte("u"), "password": []byte("p")}}
+
+	tests := []struct {
+		provider string
+		secret   *corev1.Secret
+		path     string
+		state    string
+		header   string
+		value    string
+	}{
+		{kluctlv1.CommitStatusProviderGitHub, tokenSecret, "/repos/org/repo/statuses/abc", "failure", "Authorization", "Bearer t1"},
+		{kluctlv1.CommitStatusProviderGitLab, tokenSecret, "/api/v4/projects/org%2Frepo/statuses/abc", "failed", "PRIVATE-TOKEN", "t1"},
+		{kluctlv1.CommitStatusProviderGitea, tokenSecret, "/api/v1/repos/org/repo/statuses/abc", "failure", "Authorization", "token t1"},
+		{kluctlv1.CommitStatusProviderBitbucket, basicSecret, "/2.0/repositories/org/repo/commit/abc/statuses/build", "FAILED", "Authorization", "Basic dTpw"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			g := NewWithT(t)
+			s, requests := startTestCommitStatusServer(t)
+
+			p, err := newCommitStatusProvider(&kluctlv1.CommitStatus{Provider: tc.provider, Address: s.URL}, repoUrl, tc.secret)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = p.postStatus(context.Background(), commitStatus{
+				commit:      "abc",
+				name:        "kluctl/ns/name/deploy",
+				state:       commitStatusFailure,
+				description: "deployment failed",
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(*requests).To(HaveLen(1))
+			req := (*requests)[0]
+			g.Expect(req.path).To(Equal(tc.path))
+			g.Expect(req.header.Get(tc.header)).To(Equal(tc.value))
+			g.Expect(req.body["state"]).To(Equal(tc.state))
+			g.Expect(req.body["description"]).To(Equal("deployment failed"))
+		})
+	}
+}
+
+func TestCommitStatusProviderErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	repoUrl, _ := url.Parse("https://example.com/group/sub/repo.git")
+	tokenSecret := &corev1.Secret{Data: map[string][]byte{"token": []byte("t1")}}
+
+	// only GitLab supports nested groups
+	_, err := newCommitStatusProvider(&kluctlv1.CommitStatus{Provider: kluctlv1.CommitStatusProviderGitHub}, repoUrl, tokenSecret)
+	g.Expect(err).To(MatchError(ContainSubstring("not in the form owner/repo")))
+	_, err = newCommitStatusProvider(&kluctlv1.CommitStatus{Provider: kluctlv1.CommitStatusProviderGitLab}, repoUrl, tokenSecret)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = newCommitStatusProvider(&kluctlv1.CommitStatus{Provider: kluctlv1.CommitStatusProviderBitbucket}, repoUrl, tokenSecret)
+	g.Expect(err).To(HaveOccurred())
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+	p, err := newCommitStatusProvider(&kluctlv1.CommitStatus{Provider: kluctlv1.CommitStatusProviderGitLab, Address: s.URL}, repoUrl, tokenSecret)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = p.postStatus(context.Background(), commitStatus{commit: "abc", state: commitStatusSuccess})
+	g.Expect(err).To(MatchError(ContainSubstring("401")))
+}
+
+func TestBuildCommitStatusName(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "name"}}
+	g.Expect(buildCommitStatusName(obj, "deploy")).To(Equal("kluctl/ns/name/deploy"))
+
+	target := "prod"
+	obj.Spec.Target = &target
+	g.Expect(buildCommitStatusName(obj, "validate")).To(Equal("kluctl/ns/name/prod/validate"))
+
+	// targets selected via spec.targets get their own commit statuses
+	obj.Spec.Target = nil
+	obj.Spec.Targets = &kluctlv1.TargetSelector{NamePattern: ".*"}
+	g.Expect(buildCommitStatusName(newTargetShadow(obj, "t1"), "deploy")).To(Equal("kluctl/ns/name/t1/deploy"))
+	g.Expect(buildCommitStatusName(newTargetShadow(obj, "t2"), "deploy")).To(Equal("kluctl/ns/name/t2/deploy"))
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/acl"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// checkDependencies verifies that all KluctlDeployments listed in spec.dependsOn exist and are Ready at their
+// latest generation.
+func (r *KluctlDeploymentReconciler) checkDependencies(ctx context.Context, obj *kluctlv1.KluctlDeployment) error {
+	for _, d := range obj.Spec.DependsOn {
+		if d.Namespace == "" {
+			d.Namespace = obj.GetNamespace()
+		}
+		dName := types.NamespacedName{
+			Namespace: d.Namespace,
+			Name:      d.Name,
+		}
+
+		if r.NoCrossNamespaceRefs && d.Namespace != obj.GetNamespace() {
+			return acl.AccessDeniedError(
+				fmt.Sprintf("can't access '%s/%s', cross-namespace references have been blocked",
+					kluctlv1.KluctlDeploymentKind, dName))
+		}
+
+		var k kluctlv1.KluctlDeployment
+		err := r.Get(ctx, dName, &k)
+		if err != nil {
+			return fmt.Errorf("unable to get '%s' dependency: %w", dName, err)
+		}
+
+		if len(k.Status.Conditions) == 0 || k.Generation != k.Status.ObservedGeneration {
+			return fmt.Errorf("dependency '%s' is not ready", dName)
+		}
+
+		if !apimeta.IsStatusConditionTrue(k.Status.Conditions, meta.ReadyCondition) {
+			return fmt.Errorf("dependency '%s' is not ready", dName)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"time"
+)
+
+// deployWindowHorizon specifies how far we look into the future when searching for the next deploy window
+const deployWindowHorizon = time.Hour * 24 * 8
+
+// isDeployAllowed checks if deployments are allowed at the given time, taking spec.deployWindows and
+// spec.deployBlackouts into account. Blackouts take precedence over windows.
+func isDeployAllowed(obj *kluctlv1.KluctlDeployment, t time.Time) (bool, error) {
+	for _, spec := range obj.Spec.DeployBlackouts {
+		hit, err := MatchesTimeSpec(t, spec)
+		if err != nil {
+			return false, fmt.Errorf("invalid deployBlackouts entry: %w", err)
+		}
+		if hit {
+			return false, nil
+		}
+	}
+
+	if len(obj.Spec.DeployWindows) == 0 {
+		return true, nil
+	}
+	for _, spec := range obj.Spec.DeployWindows {
+		hit, err := MatchesTimeSpec(t, spec)
+		if err != nil {
+			return false, fmt.Errorf("invalid deployWindows entry: %w", err)
+		}
+		if hit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nextDeployAllowedTime returns the earliest time (starting with t) at which deployments are allowed. It returns nil
+// if no such time can be found within deployWindowHorizon.
+func nextDeployAllowedTime(obj *kluctlv1.KluctlDeployment, t time.Time) (*time.Time, error) {
+	candidates := []time.Time{t}
+	for _, specs := range [][]string{obj.Spec.DeployWindows, obj.Spec.DeployBlackouts} {
+		for _, spec := range specs {
+			b, err := TimeSpecBoundaries(t, spec, deployWindowHorizon)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, b...)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	for _, c := range candidates {
+		allowed, err := isDeployAllowed(obj, c)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func isDeployPending(obj *kluctlv1.KluctlDeployment) bool {
+	return apimeta.IsStatusConditionTrue(obj.Status.Conditions, kluctlv1.DeployPendingCondition)
+}
+
+func setDeployPending(obj *kluctlv1.KluctlDeployment, nextAllowed *time.Time) {
+	msg := "deployment is pending until the next deploy window opens"
+	if nextAllowed != nil {
+		msg = fmt.Sprintf("deployment is pending until %s", nextAllowed.UTC().Format(time.RFC3339))
+	}
+	apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+		Type:    kluctlv1.DeployPendingCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  kluctlv1.OutsideDeployWindowReason,
+		Message: msg,
+	})
+}
+
+func removeDeployPending(obj *kluctlv1.KluctlDeployment) {
+	apimeta.RemoveStatusCondition(&obj.Status.Conditions, kluctlv1.DeployPendingCondition)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/diff"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strings"
+	"time"
+)
+
+var driftedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Subsystem: metricsSubsystem,
+	Name:      "drifted_objects",
+	Help:      "Number of drifted objects found by the last drift detection of a single deployment.",
+}, []string{"namespace", "name", "target"})
+
+func init() {
+	metrics.Registry.MustRegister(driftedObjects)
+}
+
+// nextDriftDetectionTime returns the time when the next drift detection is due
+func (r *KluctlDeploymentReconciler) nextDriftDetectionTime(obj *kluctlv1.KluctlDeployment) *time.Time {
+	if obj.Spec.DriftDetection == nil || obj.Status.LastDriftDetectionResult == nil {
+		return nil
+	}
+	d := obj.Spec.Interval.Duration
+	if obj.Spec.DriftDetection.Interval != nil {
+		d = obj.Spec.DriftDetection.Interval.Duration
+	}
+	t := obj.Status.LastDriftDetectionResult.AttemptedAt.Time.Add(d)
+	return &t
+}
+
+// isDriftDetectionDue checks if drift detection must be performed. Drift can only be detected when the rendered
+// objects match the last successful deployment, as otherwise the diff would also contain the undeployed changes.
+func (r *KluctlDeploymentReconciler) isDriftDetectionDue(obj *kluctlv1.KluctlDeployment, objectsHash string, now time.Time) bool {
+	if obj.Spec.DriftDetection == nil {
+		return false
+	}
+	ldr := obj.Status.LastDeployResult
+	if ldr == nil || ldr.ObjectsHash != objectsHash || ldr.Error != "" {
+		return false
+	}
+	ddr := obj.Status.LastDriftDetectionResult
+	if ddr == nil || ddr.ObjectsHash != objectsHash {
+		return true
+	}
+	return !r.nextDriftDetectionTime(obj).After(now)
+}
+
+// detectDrift performs a diff against the cluster and stores all changed or deleted objects in
+// status.lastDriftDetectionResult. It returns true if drift was detected.
+func (r *KluctlDeploymentReconciler) detectDrift(ctx context.Context, pt *preparedTarget, targetContext *kluctl_project.TargetContext, objectsHash string) bool {
+	obj := pt.pp.obj
+	prev := obj.Status.LastDriftDetectionResult
+
+	ddr := &kluctlv1.DriftDetectionResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{
+			AttemptedAt:        metav1.Now(),
+			Revision:           pt.pp.sourceRevision,
+			Target:             obj.Spec.Target,
+			TargetNameOverride: obj.Spec.TargetNameOverride,
+			ObjectsHash:        objectsHash,
+		},
+	}
+	obj.Status.LastDriftDetectionResult = ddr
+
+	diffResult, err := pt.kluctlDiff(ctx, targetContext)
+	if err == nil {
+		obfuscator := diff.Obfuscator{}
+		err = obfuscator.ObfuscateResult(diffResult)
+	}
+	if err != nil {
+		ddr.Error = err.Error()
+		return false
+	}
+
+	ddr.DriftedObjects, ddr.TotalDriftedObjects = buildDriftedObjects(diffResult)
+	if ddr.TotalDriftedObjects != 0 && (prev == nil || prev.TotalDriftedObjects != ddr.TotalDriftedObjects) {
+		msg := fmt.Sprintf("drift detected. %d drifted objects.", ddr.TotalDriftedObjects)
+		r.event(ctx, obj, pt.pp.sourceRevision, true, msg, nil)
+		pt.notify(ctx, "drift-detection", true, msg, nil)
+	}
+	return ddr.TotalDriftedObjects != 0
+}
+
+// buildDriftedObjects returns the objects of the diff result that were changed or deleted in the cluster, limited to
+// MaxDriftedObjects entries, together with the total number of drifted objects.
+func buildDriftedObjects(diffResult *result.CommandResult) ([]kluctlv1.DriftedObject, int) {
+	var ret []kluctlv1.DriftedObject
+	total := 0
+	for _, o := range diffResult.Objects {
+		if o.Hook || o.Orphan || (!o.New && len(o.Changes) == 0) {
+			continue
+		}
+		total++
+		if len(ret) >= kluctlv1.MaxDriftedObjects {
+			continue
+		}
+
+		var diffs []string
+		for _, c := range o.Changes {
+			diffs = append(diffs, fmt.Sprintf("%s:\n%s", c.JsonPath, c.UnifiedDiff))
+		}
+		ret = append(ret, kluctlv1.DriftedObject{
+			Ref:     o.Ref.String(),
+			Deleted: o.New,
+			Diff:    trimString(strings.Join(diffs, "\n"), kluctlv1.MaxDriftDiffLength),
+		})
+	}
+	return ret, total
+}
+
+// deleteDriftedObjectsMetric deletes the drifted objects metric of all targets of the given KluctlDeployment
+func deleteDriftedObjectsMetric(obj *kluctlv1.KluctlDeployment) {
+	driftedObjects.DeletePartialMatch(prometheus.Labels{"namespace": obj.GetNamespace(), "name": obj.GetName()})
+}
+
+// setDriftedCondition sets the Drifted condition and metric from the last drift detection result
+func setDriftedCondition(obj *kluctlv1.KluctlDeployment) {
+	target := ""
+	if obj.Spec.Target != nil {
+		target = *obj.Spec.Target
+	}
+
+	ddr := obj.Status.LastDriftDetectionResult
+	if obj.Spec.DriftDetection == nil || ddr == nil {
+		removeCondition(obj, kluctlv1.DriftedCondition)
+		driftedObjects.DeleteLabelValues(obj.GetNamespace(), obj.GetName(), target)
+		return
+	}
+	driftedObjects.WithLabelValues(obj.GetNamespace(), obj.GetName(), target).Set(float64(ddr.TotalDriftedObjects))
+
+	suffix := fmt.Sprintf("revision %s at %s", ddr.Revision, ddr.AttemptedAt.Format(time.RFC3339))
+	if ddr.Error != "" {
+		setCondition(obj, kluctlv1.DriftedCondition, metav1.ConditionUnknown, kluctlv1.DriftDetectionFailedReason,
+			fmt.Sprintf("drift detection failed for %s: %s", suffix, ddr.Error))
+	} else if ddr.TotalDriftedObjects != 0 {
+		var refs []string
+		for _, o := range ddr.DriftedObjects {
+			refs = append(refs, o.Ref)
+		}
+		setCondition(obj, kluctlv1.DriftedCondition, metav1.ConditionTrue, kluctlv1.DriftDetectedReason,
+			fmt.Sprintf("%d objects drifted from %s: %s", ddr.TotalDriftedObjects, suffix, strings.Join(refs, ", ")))
+	} else {
+		setCondition(obj, kluctlv1.DriftedCondition, metav1.ConditionFalse, kluctlv1.NoDriftDetectedReason,
+			fmt.Sprintf("no drift detected for %s", suffix))
+	}
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/types/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsDriftDetectionDue(t *testing.T) {
+	g := NewWithT(t)
+	r := &KluctlDeploymentReconciler{}
+	now := time.Now()
+
+	obj := &kluctlv1.KluctlDeployment{
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval:       metav1.Duration{Duration: 5 * time.Minute},
+			DriftDetection: &kluctlv1.DriftDetection{Interval: &metav1.Duration{Duration: time.Hour}},
+		},
+	}
+	g.Expect(r.isDriftDetectionDue(obj, "h1", now)).To(BeFalse(), "never deployed")
+
+	obj.Status.LastDeployResult = &kluctlv1.LastCommandResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{ObjectsHash: "h1"},
+	}
+	g.Expect(r.isDriftDetectionDue(obj, "h2", now)).To(BeFalse(), "undeployed changes")
+	g.Expect(r.isDriftDetectionDue(obj, "h1", now)).To(BeTrue())
+
+	obj.Status.LastDriftDetectionResult = &kluctlv1.DriftDetectionResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{ObjectsHash: "h1", AttemptedAt: metav1.NewTime(now)},
+	}
+	g.Expect(r.isDriftDetectionDue(obj, "h1", now.Add(30*time.Minute))).To(BeFalse())
+	g.Expect(r.isDriftDetectionDue(obj, "h1", now.Add(time.Hour))).To(BeTrue())
+
+	obj.Spec.DriftDetection = nil
+	g.Expect(r.isDriftDetectionDue(obj, "h1", now.Add(time.Hour))).To(BeFalse())
+}
+
+func TestBuildDriftedObjects(t *testing.T) {
+	g := NewWithT(t)
+
+	dr := &result.CommandResult{Objects: []result.ResultObject{
+		{BaseObject: result.BaseObject{
+			Ref:     k8s.ObjectRef{Kind: "ConfigMap", Name: "changed"},
+			Changes: []result.Change{{Type: "update", JsonPath: "data.k1", UnifiedDiff: "-a\n+b"}},
+		}},
+		{BaseObject: result.BaseObject{Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "deleted"}, New: true}},
+		{BaseObject: result.BaseObject{Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "unchanged"}}},
+		{BaseObject: result.BaseObject{Ref: k8s.ObjectRef{Kind: "ConfigMap", Name: "orphan"}, Orphan: true}},
+		{BaseObject: result.BaseObject{Ref: k8s.ObjectRef{Kind: "Job", Name: "hook"}, New: true, Hook: true}},
+	}}
+
+	objs, total := buildDriftedObjects(dr)
+	g.Expect(total).To(Equal(2))
+	g.Expect(objs).To(HaveLen(2))
+	g.Expect(objs[0].Ref).To(ContainSubstring("changed"))
+	g.Expect(objs[0].Diff).To(Equal("data.k1:\n-a\n+b"))
+	g.Expect(objs[1].Deleted).To(BeTrue())
+
+	dr.Objects = nil
+	for i := 0; i < kluctlv1.MaxDriftedObjects+5; i++ {
+		dr.Objects = append(dr.Objects, result.ResultObject{BaseObject: result.BaseObject{
+			Ref:     k8s.ObjectRef{Kind: "ConfigMap", Name: fmt.Sprintf("cm%d", i)},
+			Changes: []result.Change{{JsonPath: "data.k1", UnifiedDiff: strings.Repeat("x", 2*kluctlv1.MaxDriftDiffLength)}},
+		}})
+	}
+	objs, total = buildDriftedObjects(dr)
+	g.Expect(total).To(Equal(kluctlv1.MaxDriftedObjects + 5))
+	g.Expect(objs).To(HaveLen(kluctlv1.MaxDriftedObjects))
+	g.Expect(len(objs[0].Diff)).To(BeNumerically("<=", kluctlv1.MaxDriftDiffLength+3))
+}
+
+func TestSetDriftedCondition(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{
+		Spec: kluctlv1.KluctlDeploymentSpec{DriftDetection: &kluctlv1.DriftDetection{}},
+		Status: kluctlv1.KluctlDeploymentStatus{
+			LastDriftDetectionResult: &kluctlv1.DriftDetectionResult{
+				DriftedObjects:      []kluctlv1.DriftedObject{{Ref: "ns/ConfigMap/cm1"}},
+				TotalDriftedObjects: 1,
+			},
+		},
+	}
+	setDriftedCondition(obj)
+	c := apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.DriftedCondition)
+	g.Expect(c).ToNot(BeNil())
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Message).To(ContainSubstring("ns/ConfigMap/cm1"))
+
+	obj.Status.LastDriftDetectionResult = &kluctlv1.DriftDetectionResult{}
+	setDriftedCondition(obj)
+	c = apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.DriftedCondition)
+	g.Expect(c.Status).To(Equal(metav1.ConditionFalse))
+	g.Expect(c.Reason).To(Equal(kluctlv1.NoDriftDetectedReason))
+
+	obj.Spec.DriftDetection = nil
+	setDriftedCondition(obj)
+	g.Expect(apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.DriftedCondition)).To(BeNil())
+}
+
+func TestDeleteDriftedObjectsMetric(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "metric-test"},
+	}
+	driftedObjects.WithLabelValues("ns", "metric-test", "t1").Set(1)
+	driftedObjects.WithLabelValues("ns", "metric-test", "t2").Set(2)
+	driftedObjects.WithLabelValues("ns", "metric-test-other", "").Set(3)
+	defer driftedObjects.DeleteLabelValues("ns", "metric-test-other", "")
+
+	before := testutil.CollectAndCount(driftedObjects)
+	deleteDriftedObjectsMetric(obj)
+	g.Expect(testutil.CollectAndCount(driftedObjects)).To(Equal(before - 2))
+	g.Expect(testutil.ToFloat64(driftedObjects.WithLabelValues("ns", "metric-test-other", ""))).To(Equal(3.0))
+}
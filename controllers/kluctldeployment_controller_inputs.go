@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	types2 "github.com/kluctl/kluctl/v2/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+// errCommitTimeUnavailable is returned when resolving a ref requires commit times, which are not available without
+// cloning the repository.
+var errCommitTimeUnavailable = errors.New("commit times are not available without cloning")
+
+// calcInputsHash calculates a hash of everything that influences the rendered objects and that can be determined
+// without loading the project: the remote commit of the resolved ref, the spec, the source, the merged args and the
+// resource versions of all referenced Secrets. The resolved remote commit is returned as well. Only git sources are
+// supported, for other sources empty strings are returned.
+func (r *KluctlDeploymentReconciler) calcInputsHash(ctx context.Context, obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource) (string, string, error) {
+	if source == nil || source.URL == "" {
+		return "", "", nil
+	}
+
+	gitSecret, err := getGitSecret(ctx, r.Client, source.SecretRef, obj.GetNamespace())
+	if err != nil {
+		return "", "", err
+	}
+	remoteRefs, err := listRemoteRefs(ctx, source.URL, gitSecret)
+	if err != nil {
+		return "", "", err
+	}
+	commit, err := resolveRemoteCommit(source.Ref, remoteRefs)
+	if err != nil {
+		return "", "", err
+	}
+
+	args, err := r.buildArgs(ctx, obj)
+	if err != nil {
+		return "", "", err
+	}
+
+	secretVersions := map[string]string{}
+	for _, name := range referencedSecretNames(obj, source) {
+		var secret corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}, &secret)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return "", "", err
+			}
+			secretVersions[name] = ""
+			continue
+		}
+		secretVersions[name] = secret.ResourceVersion
+	}
+
+	h := sha256.New()
+	e := json.NewEncoder(h)
+	for _, x := range []any{commit, obj.GetGeneration(), source, args.Object, secretVersions} {
+		if err := e.Encode(x); err != nil {
+			return "", "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), commit, nil
+}
+
+// listRemoteRefs lists the refs of the given repository without cloning it. The returned map has the same format
+// as the remote refs of the repo cache, including peeled tags (suffixed with ^{}) and HEAD.
+func listRemoteRefs(ctx context.Context, url string, gitSecret *corev1.Secret) (map[string]string, error) {
+	gitUrl, err := types2.ParseGitUrl(url)
+	if err != nil {
+		return nil, err
+	}
+	ga, err := buildGitAuth(ctx, gitSecret)
+	if err != nil {
+		return nil, err
+	}
+	auth := ga.BuildAuth(ctx, *gitUrl)
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{gitUrl.String()},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:     auth.AuthMethod,
+		CABundle: auth.CABundle,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	remoteRefs := map[string]string{}
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			head = ref
+			continue
+		}
+		remoteRefs[ref.Name().String()] = ref.Hash().String()
+	}
+	if head != nil {
+		if h, ok := remoteRefs[head.Target().String()]; ok {
+			remoteRefs[head.Name().String()] = h
+		}
+	}
+	return remoteRefs, nil
+}
+
+// resolveRemoteCommit resolves the given GitRef against the remote refs and returns the commit that would be
+// checked out. Refs that can only be resolved by looking at commit times result in errCommitTimeUnavailable.
+func resolveRemoteCommit(ref *kluctlv1.GitRef, remoteRefs map[string]string) (string, error) {
+	if ref != nil && ref.Commit != "" {
+		return ref.Commit, nil
+	}
+
+	resolved, err := resolveGitRef(ref, remoteRefs, func(hash string) (time.Time, error) {
+		return time.Time{}, errCommitTimeUnavailable
+	})
+	if err != nil {
+		return "", err
+	}
+	if resolved == "" {
+		resolved = plumbing.HEAD.String()
+	}
+	if h, ok := remoteRefs[resolved+"^{}"]; ok {
+		// annotated tag
+		return h, nil
+	}
+	h, ok := remoteRefs[resolved]
+	if !ok {
+		return "", fmt.Errorf("ref '%s' not found", resolved)
+	}
+	return h, nil
+}
+
+// referencedSecretNames returns the names of all Secrets that are referenced by the given KluctlDeployment
+func referencedSecretNames(obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource) []string {
+	var names []string
+	if source.SecretRef != nil {
+		names = append(names, source.SecretRef.Name)
+	}
+	if obj.Spec.KubeConfig != nil {
+		names = append(names, obj.Spec.KubeConfig.SecretRef.Name)
+	}
+	if obj.Spec.Decryption != nil && obj.Spec.Decryption.SecretRef != nil {
+		names = append(names, obj.Spec.Decryption.SecretRef.Name)
+	}
+	for _, ref := range obj.Spec.RegistrySecrets {
+		names = append(names, ref.Name)
+	}
+	for _, e := range obj.Spec.HelmCredentials {
+		names = append(names, e.SecretRef.Name)
+	}
+	return names
+}
+
+// isReconcileWorkDue checks if the next reconciliation has to load the project even if the inputs did not change,
+// e.g. because a deployment or validation is due, a previous reconciliation failed or a reconciliation was
+// explicitly requested.
+func (r *KluctlDeploymentReconciler) isReconcileWorkDue(obj *kluctlv1.KluctlDeployment, reconcileRequested bool, now time.Time) bool {
+	if reconcileRequested || obj.Spec.Targets != nil {
+		return true
+	}
+	if obj.Status.ObservedGeneration != obj.GetGeneration() || !apimeta.IsStatusConditionTrue(obj.Status.Conditions, meta.ReadyCondition) {
+		return true
+	}
+	if obj.Status.LastDeployResult == nil || r.checkRequestedDeploy(obj) || isDeployPending(obj) {
+		return true
+	}
+	if _, ok := checkRequestedRestore(obj); ok {
+		return true
+	}
+	if obj.Spec.Approval == kluctlv1.ApprovalManual && isApprovalPending(obj) {
+		return true
+	}
+	if t := r.nextDeployTime(obj); t != nil && !t.After(now) {
+		return true
+	}
+	if obj.Spec.DriftDetection != nil {
+		if obj.Status.LastDriftDetectionResult == nil {
+			return true
+		}
+		if t := r.nextDriftDetectionTime(obj); t != nil && !t.After(now) {
+			return true
+		}
+	}
+	if obj.Spec.Validate {
+		if obj.Status.LastValidateResult == nil {
+			return true
+		}
+		if t := r.nextValidateTime(obj); t != nil && !t.After(now) {
+			return true
+		}
+	}
+	return false
+}
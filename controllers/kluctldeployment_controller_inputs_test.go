@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestResolveRemoteCommit(t *testing.T) {
+	g := NewWithT(t)
+
+	remoteRefs := map[string]string{
+		"HEAD":                    "h-main",
+		"refs/heads/main":         "h-main",
+		"refs/heads/release-1":    "h-r1",
+		"refs/heads/release-2":    "h-r2",
+		"refs/tags/v1.0.0":        "h-tag1",
+		"refs/tags/v1.1.0":        "h-tag2-obj",
+		"refs/tags/v1.1.0^{}":     "h-tag2",
+		"refs/tags/not-a-version": "h-tag3",
+	}
+
+	c, err := resolveRemoteCommit(nil, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c).To(Equal("h-main"))
+
+	c, err = resolveRemoteCommit(&kluctlv1.GitRef{Branch: "release-1"}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c).To(Equal("h-r1"))
+
+	c, err = resolveRemoteCommit(&kluctlv1.GitRef{Tag: "v1\\..*"}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c).To(Equal("h-tag2"))
+
+	c, err = resolveRemoteCommit(&kluctlv1.GitRef{SemVer: "<1.1.0"}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c).To(Equal("h-tag1"))
+
+	c, err = resolveRemoteCommit(&kluctlv1.GitRef{Branch: "main", Commit: "0123"}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c).To(Equal("0123"))
+
+	// multiple matching branches require commit times
+	_, err = resolveRemoteCommit(&kluctlv1.GitRef{Branch: "release-.*"}, remoteRefs)
+	g.Expect(err).To(MatchError(errCommitTimeUnavailable))
+
+	_, err = resolveRemoteCommit(&kluctlv1.GitRef{Branch: "missing"}, remoteRefs)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestIsReconcileWorkDue(t *testing.T) {
+	g := NewWithT(t)
+	r := &KluctlDeploymentReconciler{}
+	now := time.Now()
+
+	newObj := func() *kluctlv1.KluctlDeployment {
+		obj := &kluctlv1.KluctlDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Generation: 2,
+			},
+			Spec: kluctlv1.KluctlDeploymentSpec{
+				Interval: metav1.Duration{Duration: time.Minute},
+				DeployInterval: &kluctlv1.DurationOrNever{
+					Duration: metav1.Duration{Duration: time.Hour},
+				},
+			},
+		}
+		obj.Status.ObservedGeneration = 2
+		obj.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}}
+		obj.Status.LastDeployResult = &kluctlv1.LastCommandResult{
+			ReconcileResultBase: kluctlv1.ReconcileResultBase{
+				AttemptedAt: metav1.NewTime(now.Add(-time.Minute)),
+			},
+		}
+		return obj
+	}
+
+	g.Expect(r.isReconcileWorkDue(newObj(), false, now)).To(BeFalse())
+	g.Expect(r.isReconcileWorkDue(newObj(), true, now)).To(BeTrue())
+
+	obj := newObj()
+	obj.Generation = 3
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+
+	obj = newObj()
+	obj.Status.Conditions[0].Status = metav1.ConditionFalse
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+
+	obj = newObj()
+	obj.Status.LastDeployResult = nil
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+
+	obj = newObj()
+	obj.Annotations = map[string]string{kluctlv1.KluctlDeployRequestAnnotation: "1"}
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+
+	// periodic deployment is due
+	g.Expect(r.isReconcileWorkDue(newObj(), false, now.Add(2*time.Hour))).To(BeTrue())
+
+	obj = newObj()
+	obj.Spec.Validate = true
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+	obj.Status.LastValidateResult = &kluctlv1.LastValidateResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{
+			AttemptedAt: metav1.NewTime(now),
+		},
+	}
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeFalse())
+	g.Expect(r.isReconcileWorkDue(obj, false, now.Add(2*time.Minute))).To(BeTrue())
+
+	obj = newObj()
+	obj.Spec.Targets = &kluctlv1.TargetSelector{}
+	g.Expect(r.isReconcileWorkDue(obj, false, now)).To(BeTrue())
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/url"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var notificationHttpClient = &http.Client{Timeout: 15 * time.Second}
+
+// notificationMessage is the payload sent to generic webhooks and the source of all other payloads
+type notificationMessage struct {
+	Namespace string                   `json:"namespace"`
+	Name      string                   `json:"name"`
+	Command   string                   `json:"command"`
+	Severity  string                   `json:"severity"`
+	Message   string                   `json:"message"`
+	Revision  string                   `json:"revision,omitempty"`
+	Target    string                   `json:"target,omitempty"`
+	Summary   *kluctlv1.CommandSummary `json:"summary,omitempty"`
+	Timestamp time.Time                `json:"timestamp"`
+}
+
+func (m *notificationMessage) title() string {
+	return fmt.Sprintf("KluctlDeployment %s/%s: %s", m.Namespace, m.Name, m.Command)
+}
+
+// facts returns the details of the message as ordered key/value pairs
+func (m *notificationMessage) facts() [][2]string {
+	facts := [][2]string{
+		{"severity", m.Severity},
+	}
+	if m.Revision != "" {
+		facts = append(facts, [2]string{"revision", m.Revision})
+	}
+	if m.Target != "" {
+		facts = append(facts, [2]string{"target", m.Target})
+	}
+	return facts
+}
+
+// notificationRateLimiter drops notifications that are identical to notifications sent within the rate limit interval
+type notificationRateLimiter struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+func newNotificationRateLimiter() *notificationRateLimiter {
+	return &notificationRateLimiter{
+		expires: map[string]time.Time{},
+	}
+}
+
+// allow checks if a notification with the given key may be sent and remembers it for the given interval
+func (l *notificationRateLimiter) allow(key string, interval time.Duration, now time.Time) bool {
+	if interval <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for k, e := range l.expires {
+		if !now.Before(e) {
+			delete(l.expires, k)
+		}
+	}
+	if _, ok := l.expires[key]; ok {
+		return false
+	}
+	l.expires[key] = now.Add(interval)
+	return true
+}
+
+func buildNotificationKey(obj *kluctlv1.KluctlDeployment, n *kluctlv1.Notification, m *notificationMessage) string {
+	h := sha256.New()
+	e := json.NewEncoder(h)
+	for _, x := range []any{obj.GetUID(), n, m.Command, m.Severity, m.Target, m.Revision, m.Message} {
+		_ = e.Encode(x)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// notify sends the outcome of a command to all receivers specified in spec.notifications. Failures are only logged,
+// as notifications must never break reconciliation.
+func (pt *preparedTarget) notify(ctx context.Context, command string, failed bool, msg string, summary *kluctlv1.CommandSummary) {
+	obj := pt.pp.obj
+	if len(obj.Spec.Notifications) == 0 {
+		return
+	}
+	log := ctrl.LoggerFrom(ctx)
+
+	m := &notificationMessage{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Command:   command,
+		Severity:  kluctlv1.NotificationSeverityInfo,
+		Message:   msg,
+		Revision:  pt.pp.sourceRevision,
+		Summary:   summary,
+		Timestamp: time.Now(),
+	}
+	if failed {
+		m.Severity = kluctlv1.NotificationSeverityError
+	}
+	if obj.Spec.Target != nil {
+		m.Target = *obj.Spec.Target
+	}
+
+	for i := range obj.Spec.Notifications {
+		n := &obj.Spec.Notifications[i]
+		if n.Severity == kluctlv1.NotificationSeverityError && !failed {
+			continue
+		}
+		if pt.pp.r.notificationLimiter != nil && !pt.pp.r.notificationLimiter.allow(buildNotificationKey(obj, n, m), n.GetRateLimit(), m.Timestamp) {
+			log.V(1).Info("dropping rate limited notification", "provider", n.Provider)
+			continue
+		}
+
+		var secret *corev1.Secret
+		var err error
+		if n.SecretRef != nil {
+			secret, err = getGitSecret(ctx, pt.pp.r.Client, n.SecretRef, obj.GetNamespace())
+		}
+		if err == nil {
+			err = sendNotification(ctx, n, secret, m)
+		}
+		if err != nil {
+			log.Error(err, "failed to send notification", "provider", n.Provider)
+		}
+	}
+}
+
+// sendNotification sends the given message to the receiver specified by n
+func sendNotification(ctx context.Context, n *kluctlv1.Notification, secret *corev1.Secret, m *notificationMessage) error {
+	address := n.Address
+	token := ""
+	if secret != nil {
+		if v, ok := secret.Data["address"]; ok {
+			address = strings.TrimSpace(string(v))
+		}
+		if v, ok := secret.Data["token"]; ok {
+			token = strings.TrimSpace(string(v))
+		}
+	}
+	if address == "" {
+		return fmt.Errorf("no address specified")
+	}
+
+	switch n.Provider {
+	case kluctlv1.NotificationProviderGeneric:
+		return sendNotificationRequest(ctx, http.MethodPost, address, token, m)
+	case kluctlv1.NotificationProviderSlack:
+		return sendNotificationRequest(ctx, http.MethodPost, address, token, buildSlackPayload(n, m))
+	case kluctlv1.NotificationProviderMSTeams:
+		return sendNotificationRequest(ctx, http.MethodPost, address, token, buildMSTeamsPayload(m))
+	case kluctlv1.NotificationProviderMatrix:
+		if n.Channel == "" || token == "" {
+			return fmt.Errorf("matrix requires a channel (room ID) and a token")
+		}
+		u, payload := buildMatrixRequest(address, n.Channel, m)
+		return sendNotificationRequest(ctx, http.MethodPut, u, token, payload)
+	default:
+		return fmt.Errorf("notification provider '%s' not supported", n.Provider)
+	}
+}
+
+func sendNotificationRequest(ctx context.Context, method string, u string, token string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := notificationHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sending notification failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+func buildSlackPayload(n *kluctlv1.Notification, m *notificationMessage) map[string]any {
+	color := "good"
+	if m.Severity == kluctlv1.NotificationSeverityError {
+		color = "danger"
+	}
+	var fields []map[string]any
+	for _, f := range m.facts() {
+		fields = append(fields, map[string]any{"title": f[0], "value": f[1], "short": false})
+	}
+
+	payload := map[string]any{
+		"username": "kluctl",
+		"attachments": []map[string]any{{
+			"color":     color,
+			"title":     m.title(),
+			"text":      m.Message,
+			"fields":    fields,
+			"mrkdwn_in": []string{"text"},
+		}},
+	}
+	if n.Channel != "" {
+		payload["channel"] = n.Channel
+	}
+	return payload
+}
+
+func buildMSTeamsPayload(m *notificationMessage) map[string]any {
+	color := "00FF00"
+	if m.Severity == kluctlv1.NotificationSeverityError {
+		color = "FF0000"
+	}
+	var facts []map[string]string
+	for _, f := range m.facts() {
+		facts = append(facts, map[string]string{"name": f[0], "value": f[1]})
+	}
+
+	return map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"themeColor": color,
+		"summary":    m.title(),
+		"sections": []map[string]any{{
+			"activityTitle":    m.title(),
+			"activitySubtitle": m.Message,
+			"facts":            facts,
+		}},
+	}
+}
+
+func buildMatrixRequest(address string, room string, m *notificationMessage) (string, map[string]string) {
+	body := fmt.Sprintf("%s\n%s", m.title(), m.Message)
+	for _, f := range m.facts() {
+		body += fmt.Sprintf("\n%s: %s", f[0], f[1])
+	}
+
+	// the transaction id makes retries idempotent
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", body, m.Timestamp.UnixNano())))
+	txnId := hex.EncodeToString(h[:])
+
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", strings.TrimSuffix(address, "/"), url.PathEscape(room), txnId)
+	return u, map[string]string{
+		"msgtype": "m.text",
+		"body":    body,
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testNotificationRequest struct {
+	method string
+	path   string
+	auth   string
+	body   map[string]any
+}
+
+func startTestNotificationServer(t *testing.T) (*httptest.Server, *[]testNotificationRequest) {
+	var requests []testNotificationRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, testNotificationRequest{
+			method: r.Method,
+			path:   r.URL.EscapedPath(),
+			auth:   r.Header.Get("Authorization"),
+			body:   body,
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestNotificationRateLimiter(t *testing.T) {
+	g := NewWithT(t)
+	l := newNotificationRateLimiter()
+	now := time.Now()
+
+	g.Expect(l.allow("k1", time.Minute, now)).To(BeTrue())
+	g.Expect(l.allow("k1", time.Minute, now.Add(30*time.Second))).To(BeFalse())
+	g.Expect(l.allow("k2", time.Minute, now.Add(30*time.Second))).To(BeTrue())
+	g.Expect(l.allow("k1", time.Minute, now.Add(time.Minute))).To(BeTrue())
+
+	// rate limiting disabled
+	g.Expect(l.allow("k3", 0, now)).To(BeTrue())
+	g.Expect(l.allow("k3", 0, now)).To(BeTrue())
+}
+
+func TestNotify(t *testing.T) {
+	g := NewWithT(t)
+	s, requests := startTestNotificationServer(t)
+
+	obj := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "name"},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Notifications: []kluctlv1.Notification{
+				{Provider: kluctlv1.NotificationProviderGeneric, Address: s.URL + "/generic"},
+				{Provider: kluctlv1.NotificationProviderSlack, Address: s.URL + "/slack", Severity: kluctlv1.NotificationSeverityError},
+			},
+		},
+	}
+	pt := &preparedTarget{pp: &preparedProject{
+		r:              &KluctlDeploymentReconciler{notificationLimiter: newNotificationRateLimiter()},
+		obj:            obj,
+		sourceRevision: "main/abc",
+	}}
+
+	pt.notify(context.Background(), "deploy", false, "deploy succeeded. 1 changed objects.", &kluctlv1.CommandSummary{ChangedObjects: 1})
+	g.Expect(*requests).To(HaveLen(1))
+	req := (*requests)[0]
+	g.Expect(req.path).To(Equal("/generic"))
+	g.Expect(req.body["severity"]).To(Equal("info"))
+	g.Expect(req.body["revision"]).To(Equal("main/abc"))
+	g.Expect(req.body["summary"]).To(HaveKeyWithValue("changedObjects", BeEquivalentTo(1)))
+
+	// identical notifications are rate limited
+	pt.notify(context.Background(), "deploy", false, "deploy succeeded. 1 changed objects.", nil)
+	g.Expect(*requests).To(HaveLen(1))
+
+	// errors are sent to all receivers
+	pt.notify(context.Background(), "deploy", true, "deploy failed. boom", nil)
+	g.Expect(*requests).To(HaveLen(3))
+	g.Expect((*requests)[2].path).To(Equal("/slack"))
+	g.Expect((*requests)[2].body["attachments"]).To(ContainElement(HaveKeyWithValue("color", "danger")))
+
+	// targets selected via spec.targets are notified with their own target and not rate limited against each other
+	obj.Spec.Targets = &kluctlv1.TargetSelector{Names: []string{"t1", "t2"}}
+	for _, name := range []string{"t1", "t2"} {
+		spt := &preparedTarget{pp: &preparedProject{
+			r:              pt.pp.r,
+			obj:            newTargetShadow(obj, name),
+			sourceRevision: "main/abc",
+		}}
+		spt.notify(context.Background(), "delete", false, "delete succeeded. 1 deleted objects.", nil)
+	}
+	g.Expect(*requests).To(HaveLen(5))
+	g.Expect((*requests)[3].body["target"]).To(Equal("t1"))
+	g.Expect((*requests)[4].body["target"]).To(Equal("t2"))
+}
+
+func TestSendNotificationProviders(t *testing.T) {
+	g := NewWithT(t)
+	s, requests := startTestNotificationServer(t)
+
+	m := &notificationMessage{Namespace: "ns", Name: "name", Command: "prune", Severity: "error", Message: "prune failed", Revision: "main/abc", Timestamp: time.Now()}
+	secret := &corev1.Secret{Data: map[string][]byte{"address": []byte(s.URL), "token": []byte("t1")}}
+
+	err := sendNotification(context.Background(), &kluctlv1.Notification{Provider: kluctlv1.NotificationProviderMSTeams}, secret, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect((*requests)[0].body["@type"]).To(Equal("MessageCard"))
+	g.Expect((*requests)[0].body["themeColor"]).To(Equal("FF0000"))
+
+	err = sendNotification(context.Background(), &kluctlv1.Notification{Provider: kluctlv1.NotificationProviderMatrix, Channel: "!room:example.com"}, secret, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	req := (*requests)[1]
+	g.Expect(req.method).To(Equal(http.MethodPut))
+	g.Expect(req.path).To(HavePrefix("/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/"))
+	g.Expect(req.auth).To(Equal("Bearer t1"))
+	g.Expect(req.body["body"]).To(Satisfy(func(s string) bool {
+		return strings.Contains(s, "prune failed") && strings.Contains(s, "revision: main/abc")
+	}))
+
+	err = sendNotification(context.Background(), &kluctlv1.Notification{Provider: kluctlv1.NotificationProviderMatrix}, secret, m)
+	g.Expect(err).To(MatchError(ContainSubstring("room ID")))
+
+	err = sendNotification(context.Background(), &kluctlv1.Notification{Provider: kluctlv1.NotificationProviderGeneric}, nil, m)
+	g.Expect(err).To(MatchError(ContainSubstring("no address")))
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"path/filepath"
+	"strings"
+)
+
+// ociUntarLimits are applied when extracting the layer of OCI artifacts. The compressed layer must not exceed maxSize
+// either, so that oversized layers are rejected before they are downloaded.
+var ociUntarLimits = untarLimits{
+	maxSize:  100 << 20,
+	maxFiles: 10000,
+}
+
+func (pp *preparedProject) prepareOciSource(ctx context.Context) error {
+	src := pp.source.Oci
+
+	opts, err := pp.r.buildOciRemoteOptions(ctx, src, pp.obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	ref, err := resolveOciRef(src, opts)
+	if err != nil {
+		return err
+	}
+
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to pull OCI artifact '%s': %w", ref.String(), err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to determine digest of OCI artifact '%s': %w", ref.String(), err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to list layers of OCI artifact '%s': %w", ref.String(), err)
+	}
+	if len(layers) != 1 {
+		return fmt.Errorf("OCI artifact '%s' must contain exactly one layer, found %d", ref.String(), len(layers))
+	}
+
+	layerSize, err := layers[0].Size()
+	if err != nil {
+		return fmt.Errorf("failed to determine layer size of OCI artifact '%s': %w", ref.String(), err)
+	}
+	if ociUntarLimits.maxSize != 0 && layerSize > ociUntarLimits.maxSize {
+		return fmt.Errorf("layer of OCI artifact '%s' exceeds the maximum size of %d bytes", ref.String(), ociUntarLimits.maxSize)
+	}
+
+	blob, err := layers[0].Compressed()
+	if err != nil {
+		return fmt.Errorf("failed to fetch layer of OCI artifact '%s': %w", ref.String(), err)
+	}
+	defer blob.Close()
+
+	pp.repoDir = filepath.Join(pp.tmpDir, "source")
+	err = untar(blob, pp.repoDir, ociUntarLimits)
+	if err != nil {
+		return fmt.Errorf("failed to extract OCI artifact '%s': %w", ref.String(), err)
+	}
+
+	pp.resolvedRef = ref.String()
+	pp.sourceRevision = digest.String()
+	return nil
+}
+
+// resolveOciRef returns the reference to pull. Digests take precedence over semver constraints, which take
+// precedence over tags. If nothing is specified, the "latest" tag is used.
+func resolveOciRef(src *kluctlv1.OciSource, opts []remote.Option) (name.Reference, error) {
+	repoUrl := strings.TrimPrefix(src.Repository, "oci://")
+
+	var nameOpts []name.Option
+	if src.Insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+
+	ref := src.Ref
+	if ref == nil {
+		ref = &kluctlv1.OciRef{}
+	}
+
+	if ref.Digest != "" {
+		return name.NewDigest(fmt.Sprintf("%s@%s", repoUrl, ref.Digest), nameOpts...)
+	}
+
+	tag := ref.Tag
+	if ref.SemVer != "" {
+		repo, err := name.NewRepository(repoUrl, nameOpts...)
+		if err != nil {
+			return nil, err
+		}
+		tags, err := remote.List(repo, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of OCI repository '%s': %w", repoUrl, err)
+		}
+		tag, err = findHighestSemVer(ref.SemVer, tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	return name.NewTag(fmt.Sprintf("%s:%s", repoUrl, tag), nameOpts...)
+}
+
+func (r *KluctlDeploymentReconciler) buildOciRemoteOptions(ctx context.Context, src *kluctlv1.OciSource, objNs string) ([]remote.Option, error) {
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithUserAgent(r.ControllerName),
+	}
+	if src.SecretRef == nil {
+		return opts, nil
+	}
+
+	var secret corev1.Secret
+	secretName := types.NamespacedName{
+		Namespace: objNs,
+		Name:      src.SecretRef.Name,
+	}
+	if err := r.Get(ctx, secretName, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret '%s': %w", secretName.String(), err)
+	}
+
+	dockerConfig, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("secret '%s' does not contain a '%s' field", secretName.String(), corev1.DockerConfigJsonKey)
+	}
+	c := configfile.New(corev1.DockerConfigJsonKey)
+	err := c.LoadFromReader(bytes.NewReader(dockerConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s from secret %s: %w", corev1.DockerConfigJsonKey, secretName.String(), err)
+	}
+
+	repo, err := name.NewRepository(strings.TrimPrefix(src.Repository, "oci://"))
+	if err != nil {
+		return nil, err
+	}
+	ac, err := c.GetAuthConfig(repo.RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, remote.WithAuth(authn.FromConfig(authn.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	})))
+	return opts, nil
+}
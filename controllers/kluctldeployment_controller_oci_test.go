@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func pushTestOciArtifact(t *testing.T, repo string, tag string, files map[string]string) v1.Hash {
+	layer := static.NewLayer(buildTestTarball(t, files), types.MediaType("application/vnd.cncf.flux.content.v1.tar+gzip"))
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag(fmt.Sprintf("%s:%s", repo, tag), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ref, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestPrepareOciSource(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	repo := fmt.Sprintf("%s/org/project", strings.TrimPrefix(server.URL, "http://"))
+
+	d1 := pushTestOciArtifact(t, repo, "v1.0.0", map[string]string{".kluctl.yaml": "v1.0.0"})
+	d2 := pushTestOciArtifact(t, repo, "v1.1.0", map[string]string{".kluctl.yaml": "v1.1.0"})
+	d3 := pushTestOciArtifact(t, repo, "v2.0.0", map[string]string{".kluctl.yaml": "v2.0.0"})
+	pushTestOciArtifact(t, repo, "latest", map[string]string{".kluctl.yaml": "latest"})
+
+	tests := []struct {
+		name            string
+		ref             *kluctlv1.OciRef
+		expectedContent string
+		expectedDigest  string
+	}{
+		{name: "tag", ref: &kluctlv1.OciRef{Tag: "v1.0.0"}, expectedContent: "v1.0.0", expectedDigest: d1.String()},
+		{name: "semver", ref: &kluctlv1.OciRef{SemVer: "<2.0.0"}, expectedContent: "v1.1.0", expectedDigest: d2.String()},
+		{name: "digest", ref: &kluctlv1.OciRef{Digest: d3.String()}, expectedContent: "v2.0.0", expectedDigest: d3.String()},
+		{name: "latest", ref: nil, expectedContent: "latest"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pp := &preparedProject{
+				r:   &KluctlDeploymentReconciler{},
+				obj: &kluctlv1.KluctlDeployment{},
+				source: &kluctlv1.ProjectSource{
+					Oci: &kluctlv1.OciSource{
+						Repository: "oci://" + repo,
+						Ref:        tc.ref,
+						Insecure:   true,
+					},
+				},
+				tmpDir: t.TempDir(),
+			}
+			err := pp.prepareOciSource(ctx)
+			g.Expect(err).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(pp.repoDir, ".kluctl.yaml"))
+			g.Expect(err).To(Succeed())
+			g.Expect(string(content)).To(Equal(tc.expectedContent))
+			if tc.expectedDigest != "" {
+				g.Expect(pp.sourceRevision).To(Equal(tc.expectedDigest))
+			}
+		})
+	}
+
+	t.Run("no matching semver", func(t *testing.T) {
+		pp := &preparedProject{
+			r:   &KluctlDeploymentReconciler{},
+			obj: &kluctlv1.KluctlDeployment{},
+			source: &kluctlv1.ProjectSource{
+				Oci: &kluctlv1.OciSource{
+					Repository: repo,
+					Ref:        &kluctlv1.OciRef{SemVer: ">=3.0.0"},
+					Insecure:   true,
+				},
+			},
+			tmpDir: t.TempDir(),
+		}
+		err := pp.prepareOciSource(ctx)
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		pushTestOciArtifact(t, repo, "large", map[string]string{
+			".kluctl.yaml": "large",
+			"a.txt":        strings.Repeat("a", 1000),
+		})
+
+		oldLimits := ociUntarLimits
+		defer func() {
+			ociUntarLimits = oldLimits
+		}()
+
+		prepare := func() error {
+			pp := &preparedProject{
+				r:   &KluctlDeploymentReconciler{},
+				obj: &kluctlv1.KluctlDeployment{},
+				source: &kluctlv1.ProjectSource{
+					Oci: &kluctlv1.OciSource{
+						Repository: repo,
+						Ref:        &kluctlv1.OciRef{Tag: "large"},
+						Insecure:   true,
+					},
+				},
+				tmpDir: t.TempDir(),
+			}
+			return pp.prepareOciSource(ctx)
+		}
+
+		ociUntarLimits = untarLimits{maxSize: 10}
+		g.Expect(prepare()).To(MatchError(ContainSubstring("exceeds the maximum size of 10 bytes")))
+		ociUntarLimits = untarLimits{maxSize: 500}
+		g.Expect(prepare()).To(MatchError(ContainSubstring("maximum extracted size of 500 bytes")))
+		ociUntarLimits = untarLimits{maxFiles: 1}
+		g.Expect(prepare()).To(MatchError(ContainSubstring("more than 1 files")))
+		ociUntarLimits = untarLimits{maxSize: 2000, maxFiles: 2}
+		g.Expect(prepare()).To(Succeed())
+	})
+}
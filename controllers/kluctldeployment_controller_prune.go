@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	"github.com/kluctl/kluctl/v2/pkg/types/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// errPruneDryRun is returned from the prune confirmation callback to skip all deletions
+var errPruneDryRun = errors.New("prune dry-run")
+
+// errPruneDeniedKinds is returned from the prune confirmation callback to abort the prune of kluctl when objects of
+// denied kinds would be deleted, as kluctl can only delete all or none of the objects
+var errPruneDeniedKinds = errors.New("prune contains denied kinds")
+
+// runPrune performs a prune via run, which must call the given callback with the objects that are about to be deleted
+// and abort if it returns an error. confirm is called with the objects that are actually deleted and can abort the
+// prune as well. Objects of kinds listed in spec.pruneSafety.denyKinds are excluded from the prune: the prune of
+// kluctl is aborted and the remaining objects are deleted via deleteRefs instead, while the excluded objects are
+// reported as warnings. Errors returned by confirm are returned as is, as kluctl does not necessarily wrap them.
+func runPrune(obj *kluctlv1.KluctlDeployment,
+	run func(cb func(refs []k8s.ObjectRef) error) (*result.CommandResult, error),
+	confirm func(refs []k8s.ObjectRef, excluded int) error,
+	deleteRefs func(refs []k8s.ObjectRef) error) (*result.CommandResult, error) {
+
+	var denyKinds []string
+	if obj.Spec.PruneSafety != nil {
+		denyKinds = obj.Spec.PruneSafety.DenyKinds
+	}
+
+	var allowed, denied []k8s.ObjectRef
+	var confirmErr error
+	cmdResult, err := run(func(refs []k8s.ObjectRef) error {
+		allowed, denied = splitPruneDeniedKinds(denyKinds, refs)
+		if len(denied) != 0 {
+			confirmErr = errPruneDeniedKinds
+		} else {
+			confirmErr = confirm(refs, 0)
+		}
+		return confirmErr
+	})
+	if confirmErr == nil {
+		return cmdResult, err
+	}
+	if !errors.Is(confirmErr, errPruneDeniedKinds) {
+		return nil, confirmErr
+	}
+
+	cmdResult = &result.CommandResult{}
+	for _, ref := range denied {
+		cmdResult.Warnings = append(cmdResult.Warnings, result.DeploymentError{
+			Ref:     ref,
+			Message: "not pruned, as its kind is listed in spec.pruneSafety.denyKinds",
+		})
+	}
+	if len(allowed) == 0 {
+		return cmdResult, nil
+	}
+	err = confirm(allowed, len(denied))
+	if err != nil {
+		return nil, err
+	}
+	err = deleteRefs(allowed)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range allowed {
+		cmdResult.Objects = append(cmdResult.Objects, result.ResultObject{
+			BaseObject: result.BaseObject{Ref: ref, Deleted: true},
+		})
+	}
+	return cmdResult, nil
+}
+
+// splitPruneDeniedKinds splits the given refs into the ones that may be pruned and the ones of denied kinds
+func splitPruneDeniedKinds(denyKinds []string, refs []k8s.ObjectRef) ([]k8s.ObjectRef, []k8s.ObjectRef) {
+	var allowed, denied []k8s.ObjectRef
+	for _, ref := range refs {
+		if isPruneDeniedKind(denyKinds, ref) {
+			denied = append(denied, ref)
+		} else {
+			allowed = append(allowed, ref)
+		}
+	}
+	return allowed, denied
+}
+
+// checkPruneSafety checks the refs that are about to be pruned against spec.pruneSafety. total is the number of all
+// other objects, which includes the rendered objects and orphans that are excluded from the prune. If the prune must
+// not be performed, the PruneBlocked condition is set and an error is returned.
+func checkPruneSafety(obj *kluctlv1.KluctlDeployment, total int, refs []k8s.ObjectRef) error {
+	ps := obj.Spec.PruneSafety
+	if ps == nil || len(refs) == 0 {
+		return nil
+	}
+
+	var reasons []string
+	if ps.MaxDeletions != nil && len(refs) > *ps.MaxDeletions {
+		reasons = append(reasons, fmt.Sprintf("%d deletions exceed the maximum of %d", len(refs), *ps.MaxDeletions))
+	}
+	if ps.MaxDeletionPercentage != nil {
+		all := total + len(refs)
+		// compare without integer division, which would round down the percentage
+		if len(refs)*100 > *ps.MaxDeletionPercentage*all {
+			percentage := float64(len(refs)) * 100 / float64(all)
+			reasons = append(reasons, fmt.Sprintf("deleting %.1f%% of all objects exceeds the maximum of %d%%", percentage, *ps.MaxDeletionPercentage))
+		}
+	}
+
+	var refStrs []string
+	for _, ref := range refs {
+		refStrs = append(refStrs, ref.String())
+	}
+
+	if len(reasons) != 0 {
+		msg := fmt.Sprintf("prune of %d objects blocked, %s", len(refs), strings.Join(reasons, ", "))
+		setCondition(obj, kluctlv1.PruneBlockedCondition, metav1.ConditionTrue, kluctlv1.PruneLimitExceededReason,
+			fmt.Sprintf("%s. Objects: %s", msg, strings.Join(refStrs, ", ")))
+		return errors.New(msg)
+	}
+	if ps.DryRun {
+		setCondition(obj, kluctlv1.PruneBlockedCondition, metav1.ConditionTrue, kluctlv1.PruneDryRunReason,
+			fmt.Sprintf("dry-run, %d objects would be deleted: %s", len(refs), strings.Join(refStrs, ", ")))
+		return errPruneDryRun
+	}
+	return nil
+}
+
+// isPruneDeniedKind checks if the given ref matches one of the kinds or kind.group entries
+func isPruneDeniedKind(denyKinds []string, ref k8s.ObjectRef) bool {
+	for _, k := range denyKinds {
+		kind, group, hasGroup := strings.Cut(k, ".")
+		if kind == ref.Kind && (!hasGroup || group == ref.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+// countRenderedObjects returns the number of rendered objects of the given target
+func countRenderedObjects(targetContext *kluctl_project.TargetContext) int {
+	n := 0
+	for _, di := range targetContext.DeploymentCollection.Deployments {
+		n += len(di.Objects)
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/types/k8s"
+	"github.com/kluctl/kluctl/v2/pkg/types/result"
+	. "github.com/onsi/gomega"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"testing"
+)
+
+func TestCheckPruneSafety(t *testing.T) {
+	g := NewWithT(t)
+	intPtr := func(i int) *int { return &i }
+
+	refs := []k8s.ObjectRef{
+		{Version: "v1", Kind: "ConfigMap", Name: "cm1", Namespace: "ns"},
+		{Version: "v1", Kind: "ConfigMap", Name: "cm2", Namespace: "ns"},
+	}
+	obj := &kluctlv1.KluctlDeployment{}
+	g.Expect(checkPruneSafety(obj, 2, refs)).To(Succeed(), "no limits")
+
+	obj.Spec.PruneSafety = &kluctlv1.PruneSafety{MaxDeletions: intPtr(2), MaxDeletionPercentage: intPtr(50)}
+	g.Expect(checkPruneSafety(obj, 2, refs)).To(Succeed())
+	g.Expect(apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.PruneBlockedCondition)).To(BeNil())
+
+	obj.Spec.PruneSafety.MaxDeletions = intPtr(1)
+	g.Expect(checkPruneSafety(obj, 10, refs)).To(MatchError(ContainSubstring("maximum of 1")))
+	c := apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.PruneBlockedCondition)
+	g.Expect(c).ToNot(BeNil())
+	g.Expect(c.Reason).To(Equal(kluctlv1.PruneLimitExceededReason))
+	g.Expect(c.Message).To(ContainSubstring("cm1"))
+	g.Expect(c.Message).To(ContainSubstring("cm2"))
+
+	obj.Spec.PruneSafety.MaxDeletions = nil
+	g.Expect(checkPruneSafety(obj, 1, refs)).To(MatchError(ContainSubstring("66.7%")))
+
+	// 1 of 3 objects is 33.3%, which must not be rounded down to the limit
+	obj.Spec.PruneSafety.MaxDeletionPercentage = intPtr(33)
+	g.Expect(checkPruneSafety(obj, 2, refs[:1])).ToNot(Succeed())
+	obj.Spec.PruneSafety.MaxDeletionPercentage = intPtr(34)
+	g.Expect(checkPruneSafety(obj, 2, refs[:1])).To(Succeed())
+
+	// denied kinds are excluded by runPrune and never block the prune
+	obj.Spec.PruneSafety = &kluctlv1.PruneSafety{DenyKinds: []string{"Namespace"}}
+	g.Expect(checkPruneSafety(obj, 10, append(refs, k8s.ObjectRef{Version: "v1", Kind: "Namespace", Name: "ns"}))).To(Succeed())
+
+	obj.Spec.PruneSafety = &kluctlv1.PruneSafety{DryRun: true}
+	err := checkPruneSafety(obj, 10, refs)
+	g.Expect(errors.Is(err, errPruneDryRun)).To(BeTrue())
+	c = apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.PruneBlockedCondition)
+	g.Expect(c.Reason).To(Equal(kluctlv1.PruneDryRunReason))
+	g.Expect(c.Message).To(ContainSubstring("2 objects would be deleted"))
+}
+
+func TestRunPrune(t *testing.T) {
+	g := NewWithT(t)
+
+	refs := []k8s.ObjectRef{
+		{Version: "v1", Kind: "ConfigMap", Name: "cm1", Namespace: "ns"},
+		{Version: "v1", Kind: "Namespace", Name: "ns"},
+		{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition", Name: "x"},
+		{Group: "other.io", Version: "v1", Kind: "CustomResourceDefinition", Name: "y"},
+	}
+
+	var pruned []k8s.ObjectRef
+	// behaves like the prune command of kluctl, which does not wrap the error returned by the callback
+	run := func(cb func(refs []k8s.ObjectRef) error) (*result.CommandResult, error) {
+		err := cb(refs)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		pruned = append(pruned, refs...)
+		return &result.CommandResult{}, nil
+	}
+	var confirmed []k8s.ObjectRef
+	var excluded int
+	confirm := func(refs []k8s.ObjectRef, e int) error {
+		confirmed = refs
+		excluded = e
+		return nil
+	}
+	var deleted []k8s.ObjectRef
+	deleteRefs := func(refs []k8s.ObjectRef) error {
+		deleted = append(deleted, refs...)
+		return nil
+	}
+
+	obj := &kluctlv1.KluctlDeployment{}
+	_, err := runPrune(obj, run, confirm, deleteRefs)
+	g.Expect(err).To(Succeed())
+	g.Expect(pruned).To(Equal(refs))
+	g.Expect(deleted).To(BeEmpty())
+
+	pruned = nil
+	obj.Spec.PruneSafety = &kluctlv1.PruneSafety{DenyKinds: []string{"Namespace", "CustomResourceDefinition.apiextensions.k8s.io"}}
+	cmdResult, err := runPrune(obj, run, confirm, deleteRefs)
+	g.Expect(err).To(Succeed())
+	g.Expect(pruned).To(BeEmpty())
+	g.Expect(confirmed).To(Equal([]k8s.ObjectRef{refs[0], refs[3]}))
+	g.Expect(excluded).To(Equal(2))
+	g.Expect(deleted).To(Equal([]k8s.ObjectRef{refs[0], refs[3]}))
+	g.Expect(cmdResult.Warnings).To(HaveLen(2))
+	g.Expect(cmdResult.Warnings[0].Ref).To(Equal(refs[1]))
+	g.Expect(cmdResult.Warnings[1].Ref).To(Equal(refs[2]))
+	g.Expect(cmdResult.Objects).To(HaveLen(2))
+	g.Expect(cmdResult.Objects[0].Deleted).To(BeTrue())
+
+	obj.Spec.PruneSafety = &kluctlv1.PruneSafety{DryRun: true}
+	_, err = runPrune(obj, run, func(refs []k8s.ObjectRef, excluded int) error {
+		return checkPruneSafety(obj, 0, refs)
+	}, deleteRefs)
+	g.Expect(errors.Is(err, errPruneDryRun)).To(BeTrue())
+	g.Expect(pruned).To(BeEmpty())
+}
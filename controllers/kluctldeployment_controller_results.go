@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+)
+
+// storeCommandResult moves the raw result of the given command result into an owned Secret or ConfigMap, depending
+// on spec.resultsStore.kind. On success, the raw result is removed from the status and replaced by a reference.
+// If storing fails, the raw result is kept in the status.
+func (r *KluctlDeploymentReconciler) storeCommandResult(ctx context.Context, obj *kluctlv1.KluctlDeployment, command string, lr *kluctlv1.LastCommandResult) {
+	log := ctrl.LoggerFrom(ctx)
+
+	kind := obj.Spec.GetResultsStoreKind()
+	if kind == kluctlv1.ResultsStoreKindStatus || lr == nil || lr.RawResult == nil {
+		return
+	}
+
+	compressed, err := compressCommandResult(*lr.RawResult)
+	if err != nil {
+		log.Error(err, "failed to compress command result")
+		return
+	}
+
+	objMeta := metav1.ObjectMeta{
+		GenerateName: fmt.Sprintf("%s-%s-", obj.Name, command),
+		Namespace:    obj.Namespace,
+		Labels: map[string]string{
+			kluctlv1.KluctlDeploymentUidLabel: string(obj.UID),
+			kluctlv1.KluctlCommandLabel:       command,
+		},
+	}
+
+	var o client.Object
+	switch kind {
+	case kluctlv1.ResultsStoreKindSecret:
+		o = &corev1.Secret{
+			ObjectMeta: objMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				kluctlv1.CommandResultKey: compressed,
+			},
+		}
+	case kluctlv1.ResultsStoreKindConfigMap:
+		o = &corev1.ConfigMap{
+			ObjectMeta: objMeta,
+			BinaryData: map[string][]byte{
+				kluctlv1.CommandResultKey: compressed,
+			},
+		}
+	default:
+		log.Error(fmt.Errorf("results store kind '%s' not supported", kind), "failed to store command result")
+		return
+	}
+
+	err = controllerutil.SetOwnerReference(obj, o, r.Scheme)
+	if err != nil {
+		log.Error(err, "failed to set owner reference on command result")
+		return
+	}
+
+	err = r.Create(ctx, o)
+	if err != nil {
+		log.Error(err, "failed to store command result", "kind", kind)
+		return
+	}
+
+	lr.ResultRef = &kluctlv1.ResultRef{
+		Kind: kind,
+		Name: o.GetName(),
+	}
+	lr.RawResult = nil
+}
+
+// cleanupCommandResults deletes result objects that exceed spec.resultsStore.retention. Objects that are referenced
+// from the status are never deleted.
+func (r *KluctlDeploymentReconciler) cleanupCommandResults(ctx context.Context, obj *kluctlv1.KluctlDeployment) error {
+	if obj.UID == "" {
+		return nil
+	}
+
+	opts := []client.ListOption{
+		client.InNamespace(obj.Namespace),
+		client.MatchingLabels{kluctlv1.KluctlDeploymentUidLabel: string(obj.UID)},
+	}
+
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, opts...); err != nil {
+		return err
+	}
+	var configMaps corev1.ConfigMapList
+	if err := r.List(ctx, &configMaps, opts...); err != nil {
+		return err
+	}
+
+	type resultObject struct {
+		kind string
+		obj  client.Object
+	}
+	var objs []resultObject
+	for i := range secrets.Items {
+		objs = append(objs, resultObject{kind: kluctlv1.ResultsStoreKindSecret, obj: &secrets.Items[i]})
+	}
+	for i := range configMaps.Items {
+		objs = append(objs, resultObject{kind: kluctlv1.ResultsStoreKindConfigMap, obj: &configMaps.Items[i]})
+	}
+
+	// newest first
+	sort.SliceStable(objs, func(i, j int) bool {
+		ti := objs[i].obj.GetCreationTimestamp()
+		tj := objs[j].obj.GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return objs[i].obj.GetName() > objs[j].obj.GetName()
+	})
+
+	isReferenced := func(kind string, name string) bool {
+		lrs := []*kluctlv1.LastCommandResult{obj.Status.LastDeployResult, obj.Status.LastPruneResult}
+		for _, ts := range obj.Status.Targets {
+			lrs = append(lrs, ts.LastDeployResult, ts.LastPruneResult)
+		}
+		for _, lr := range lrs {
+			if lr != nil && lr.ResultRef != nil && lr.ResultRef.Kind == kind && lr.ResultRef.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	retention := obj.Spec.GetResultsStoreRetention()
+	if obj.Spec.GetResultsStoreKind() == kluctlv1.ResultsStoreKindStatus {
+		// results are not stored in objects anymore, so only referenced objects need to be kept
+		retention = 0
+	}
+
+	kept := 0
+	for _, x := range objs {
+		if isReferenced(x.kind, x.obj.GetName()) {
+			kept++
+			continue
+		}
+		if kept < retention {
+			kept++
+			continue
+		}
+		err := r.Delete(ctx, x.obj)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func compressCommandResult(raw string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressCommandResult(compressed []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
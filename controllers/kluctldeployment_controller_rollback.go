@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"time"
+)
+
+// isRollbackEnabled checks if rollbacks can be performed for the given object
+func isRollbackEnabled(obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource) bool {
+	return obj.Spec.Rollback != nil && obj.Spec.Targets == nil && source != nil && source.URL != ""
+}
+
+// clearRollback removes the rollback status and the RolledBack condition
+func clearRollback(obj *kluctlv1.KluctlDeployment) {
+	obj.Status.Rollback = nil
+	removeCondition(obj, kluctlv1.RolledBackCondition)
+}
+
+// buildRollbackSource returns a copy of the given source that is pinned to the last successful revision if the
+// revision that caused the active rollback is still the latest one. Otherwise, nil is returned and the latest
+// revision is tried again.
+func buildRollbackSource(obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource, latestCommit string) *kluctlv1.ProjectSource {
+	rb := obj.Status.Rollback
+	good := obj.Status.LastSuccessfulRevision
+	if rb == nil || good == nil || !isRollbackEnabled(obj, source) {
+		return nil
+	}
+	if rb.FailedCommit != latestCommit || rb.FailedGeneration != obj.GetGeneration() {
+		return nil
+	}
+
+	ret := source.DeepCopy()
+	ret.Ref = &kluctlv1.GitRef{
+		Commit: good.Commit,
+	}
+	if strings.HasPrefix(good.Ref, "refs/heads/") {
+		ret.Ref.Branch = strings.TrimPrefix(good.Ref, "refs/heads/")
+	} else if strings.HasPrefix(good.Ref, "refs/tags/") {
+		ret.Ref.Tag = strings.TrimPrefix(good.Ref, "refs/tags/")
+	}
+	return ret
+}
+
+// checkRollbackNeeded checks if the revision of the given prepared project has to be rolled back and returns the
+// reason for it. An empty reason means that no rollback is needed. If a failed validation is still within the
+// timeout, the remaining time is returned.
+func checkRollbackNeeded(obj *kluctlv1.KluctlDeployment, pp *preparedProject, now time.Time) (string, time.Duration) {
+	good := obj.Status.LastSuccessfulRevision
+	if !isRollbackEnabled(obj, pp.source) || good == nil || pp.sourceCommit == "" || good.Commit == pp.sourceCommit {
+		return "", 0
+	}
+
+	ldr := obj.Status.LastDeployResult
+	if ldr == nil || ldr.Revision != pp.sourceRevision {
+		return "", 0
+	}
+	if ldr.Error != "" || ldr.ErrorCount() != 0 {
+		if obj.Spec.Rollback.OnDeployFailure {
+			return "deploy failed", 0
+		}
+		return "", 0
+	}
+
+	if !obj.Spec.Validate || !obj.Spec.Rollback.OnValidateFailure || isValidateOk(obj) {
+		return "", 0
+	}
+	lvr := obj.Status.LastValidateResult
+	if lvr == nil || lvr.Revision != pp.sourceRevision {
+		return "", 0
+	}
+	remaining := ldr.AttemptedAt.Add(obj.Spec.Rollback.GetTimeout()).Sub(now)
+	if remaining > 0 {
+		return "", remaining
+	}
+	return fmt.Sprintf("validate still failing after %s", obj.Spec.Rollback.GetTimeout()), 0
+}
+
+// isValidateOk checks if the last validation succeeded and reported all objects as ready
+func isValidateOk(obj *kluctlv1.KluctlDeployment) bool {
+	lvr := obj.Status.LastValidateResult
+	if lvr == nil || lvr.Error != "" {
+		return false
+	}
+	vr := lvr.ParseResult()
+	return vr != nil && len(vr.Errors) == 0 && vr.Ready
+}
+
+// needRollbackValidate checks if validation must be repeated on every reconciliation, so that a failing validation
+// of a new revision is detected as soon as it recovers or the rollback timeout has passed.
+func needRollbackValidate(obj *kluctlv1.KluctlDeployment) bool {
+	return obj.Spec.Rollback != nil && obj.Spec.Rollback.OnValidateFailure && obj.Status.Rollback == nil && !isValidateOk(obj)
+}
+
+// rollback redeploys the last successful revision after the revision of the failed prepared project failed. The
+// returned prepared project is pinned to the last successful revision and must be cleaned up by the caller.
+func (r *KluctlDeploymentReconciler) rollback(ctx context.Context, obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource, failed *preparedProject, reason string) (*preparedProject, error) {
+	log := ctrl.LoggerFrom(ctx)
+	good := obj.Status.LastSuccessfulRevision
+
+	obj.Status.Rollback = &kluctlv1.RollbackStatus{
+		FailedRevision:   failed.sourceRevision,
+		FailedCommit:     failed.sourceCommit,
+		FailedGeneration: obj.GetGeneration(),
+		Reason:           reason,
+		Revision:         good.Revision,
+		RolledBackAt:     metav1.Now(),
+	}
+
+	msg := fmt.Sprintf("%s for revision %s, rolling back to revision %s", reason, failed.sourceRevision, good.Revision)
+	log.Info(msg)
+	r.event(ctx, obj, failed.sourceRevision, true, msg, nil)
+
+	pp, err := prepareProject(ctx, r, obj, buildRollbackSource(obj, source, failed.sourceCommit))
+	if err != nil {
+		setCondition(obj, kluctlv1.RolledBackCondition, metav1.ConditionFalse, kluctlv1.RollbackFailedReason,
+			fmt.Sprintf("failed to prepare revision %s: %s", good.Revision, err.Error()))
+		return nil, err
+	}
+	pp.rolledBack = true
+	pp.rollbackDeploy = true
+
+	pt, err := pp.newTarget()
+	if err == nil {
+		err = pt.withKluctlProjectTarget(ctx, func(targetContext *kluctl_project.TargetContext) error {
+			// rollbacks restore a state that was already deployed, so deploy windows and approvals are not honored
+			_, _, err := r.reconcileTarget(ctx, pt, targetContext, true)
+			return err
+		})
+	}
+	if err != nil {
+		setCondition(obj, kluctlv1.RolledBackCondition, metav1.ConditionFalse, kluctlv1.RollbackFailedReason,
+			fmt.Sprintf("failed to deploy revision %s: %s", good.Revision, err.Error()))
+		return pp, err
+	}
+	setRolledBackCondition(obj)
+	return pp, nil
+}
+
+// setRolledBackCondition sets the RolledBack condition from the active rollback and the last deploy result
+func setRolledBackCondition(obj *kluctlv1.KluctlDeployment) {
+	rb := obj.Status.Rollback
+	if rb == nil {
+		return
+	}
+	ldr := obj.Status.LastDeployResult
+	if ldr != nil && ldr.Revision == rb.Revision && (ldr.Error != "" || ldr.ErrorCount() != 0) {
+		setCondition(obj, kluctlv1.RolledBackCondition, metav1.ConditionFalse, kluctlv1.RollbackFailedReason,
+			fmt.Sprintf("rollback of revision %s to revision %s failed", rb.FailedRevision, rb.Revision))
+		return
+	}
+	setCondition(obj, kluctlv1.RolledBackCondition, metav1.ConditionTrue, kluctlv1.RollbackSucceededReason,
+		fmt.Sprintf("revision %s was rolled back to revision %s: %s", rb.FailedRevision, rb.Revision, rb.Reason))
+}
+
+// recordSuccessfulRevision remembers the revision of the given prepared project as the last successful one if it
+// was actually deployed. Must only be called when the object is ready.
+func recordSuccessfulRevision(obj *kluctlv1.KluctlDeployment, pp *preparedProject) {
+	if pp.sourceCommit == "" || obj.Status.LastDeployResult == nil || obj.Status.LastDeployResult.Revision != pp.sourceRevision {
+		return
+	}
+	obj.Status.LastSuccessfulRevision = &kluctlv1.SuccessfulRevision{
+		Revision: pp.sourceRevision,
+		Ref:      pp.resolvedRef,
+		Commit:   pp.sourceCommit,
+	}
+}
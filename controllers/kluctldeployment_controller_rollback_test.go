@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func buildTestRollbackObj() *kluctlv1.KluctlDeployment {
+	return &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Validate: true,
+			Rollback: &kluctlv1.Rollback{
+				OnDeployFailure:   true,
+				OnValidateFailure: true,
+			},
+		},
+		Status: kluctlv1.KluctlDeploymentStatus{
+			LastSuccessfulRevision: &kluctlv1.SuccessfulRevision{
+				Revision: "refs/heads/main/good",
+				Ref:      "refs/heads/main",
+				Commit:   "good",
+			},
+		},
+	}
+}
+
+func TestCheckRollbackNeeded(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	source := &kluctlv1.ProjectSource{URL: "https://example.com/repo.git"}
+	pp := &preparedProject{source: source, sourceRevision: "refs/heads/main/bad", sourceCommit: "bad"}
+
+	obj := buildTestRollbackObj()
+	obj.Status.LastDeployResult = &kluctlv1.LastCommandResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{AttemptedAt: metav1.NewTime(now), Revision: pp.sourceRevision},
+		Error:               "failed",
+	}
+	reason, _ := checkRollbackNeeded(obj, pp, now)
+	g.Expect(reason).To(Equal("deploy failed"))
+
+	obj.Spec.Rollback.OnDeployFailure = false
+	reason, _ = checkRollbackNeeded(obj, pp, now)
+	g.Expect(reason).To(BeEmpty())
+
+	// a failed validation is only rolled back after the timeout
+	obj.Status.LastDeployResult.Error = ""
+	obj.Status.LastValidateResult = &kluctlv1.LastValidateResult{
+		ReconcileResultBase: kluctlv1.ReconcileResultBase{AttemptedAt: metav1.NewTime(now), Revision: pp.sourceRevision},
+		Error:               "failed",
+	}
+	reason, remaining := checkRollbackNeeded(obj, pp, now.Add(time.Minute))
+	g.Expect(reason).To(BeEmpty())
+	g.Expect(remaining).To(Equal(kluctlv1.DefaultRollbackTimeout - time.Minute))
+
+	reason, _ = checkRollbackNeeded(obj, pp, now.Add(kluctlv1.DefaultRollbackTimeout))
+	g.Expect(reason).To(ContainSubstring("validate still failing"))
+
+	// the last successful revision is never rolled back
+	obj.Status.LastSuccessfulRevision.Commit = "bad"
+	reason, _ = checkRollbackNeeded(obj, pp, now.Add(kluctlv1.DefaultRollbackTimeout))
+	g.Expect(reason).To(BeEmpty())
+
+	// nothing to roll back to
+	obj.Status.LastSuccessfulRevision = nil
+	reason, _ = checkRollbackNeeded(obj, pp, now.Add(kluctlv1.DefaultRollbackTimeout))
+	g.Expect(reason).To(BeEmpty())
+}
+
+func TestBuildRollbackSource(t *testing.T) {
+	g := NewWithT(t)
+	source := &kluctlv1.ProjectSource{URL: "https://example.com/repo.git", Ref: &kluctlv1.GitRef{Branch: "ma.*"}, Path: "p"}
+
+	obj := buildTestRollbackObj()
+	g.Expect(buildRollbackSource(obj, source, "bad")).To(BeNil())
+
+	obj.Status.Rollback = &kluctlv1.RollbackStatus{FailedCommit: "bad", FailedGeneration: 2, Revision: "refs/heads/main/good"}
+	rs := buildRollbackSource(obj, source, "bad")
+	g.Expect(rs).ToNot(BeNil())
+	g.Expect(rs.Path).To(Equal("p"))
+	g.Expect(rs.Ref).To(Equal(&kluctlv1.GitRef{Branch: "main", Commit: "good"}))
+	g.Expect(source.Ref.Branch).To(Equal("ma.*"))
+
+	// a new commit or a spec change retries the latest revision
+	g.Expect(buildRollbackSource(obj, source, "new")).To(BeNil())
+	obj.Generation = 3
+	g.Expect(buildRollbackSource(obj, source, "bad")).To(BeNil())
+}
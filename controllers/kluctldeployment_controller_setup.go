@@ -1,13 +1,16 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"github.com/fluxcd/pkg/runtime/predicates"
 	"github.com/hashicorp/go-retryablehttp"
 	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"time"
 )
@@ -15,6 +18,7 @@ import (
 // SetupWithManager sets up the controller with the Manager.
 func (r *KluctlDeploymentReconciler) SetupWithManager(mgr ctrl.Manager, opts KluctlDeploymentReconcilerOpts) error {
 	r.statusManager = fmt.Sprintf("gotk-%s", r.ControllerName)
+	r.requeueDependency = opts.DependencyRequeueInterval
 
 	// Configure the retryable http client used for fetching artifacts.
 	// By default it retries 10 times within a 3.5 minutes window.
@@ -25,10 +29,31 @@ func (r *KluctlDeploymentReconciler) SetupWithManager(mgr ctrl.Manager, opts Klu
 	httpClient.Logger = nil
 	r.httpClient = httpClient
 
+	r.notificationLimiter = newNotificationRateLimiter()
+
+	// Index the KluctlDeployments by the ConfigMaps and Secrets they reference via argsFrom
+	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), &kluctlv1.KluctlDeployment{}, argsFromIndexKey, indexArgsFrom); err != nil {
+		return fmt.Errorf("failed setting index fields: %w", err)
+	}
+
+	// ConfigMaps and Secrets are only watched for their metadata, as the mapping to KluctlDeployments only needs the
+	// name and namespace. This avoids caching the content of all ConfigMaps and Secrets in the cluster.
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kluctlv1.KluctlDeployment{}, builder.WithPredicates(
-			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}, DeployRequestedPredicate{}),
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}, DeployRequestedPredicate{}, ApprovedHashChangedPredicate{}, RestoreBackupRequestedPredicate{}),
 		)).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArgsFrom("ConfigMap")),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			builder.OnlyMetadata,
+		).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForArgsFrom("Secret")),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			builder.OnlyMetadata,
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
 		Complete(r)
 }
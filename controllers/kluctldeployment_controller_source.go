@@ -2,64 +2,94 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"github.com/Masterminds/semver/v3"
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/acl"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
 	"github.com/kluctl/kluctl/v2/pkg/git/auth"
 	"github.com/kluctl/kluctl/v2/pkg/git/messages"
 	"github.com/kluctl/kluctl/v2/pkg/repocache"
-	"github.com/kluctl/kluctl/v2/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
-	"os"
-	"path/filepath"
+	"regexp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
 )
 
 func (r *KluctlDeploymentReconciler) getProjectSource(ctx context.Context, obj *kluctlv1.KluctlDeployment, noCrossNamespaceRefs bool) (*kluctlv1.ProjectSource, error) {
 	if obj.Spec.Source != nil && obj.Spec.SourceRef != nil {
-		return nil, fmt.Errorf("sourceRef and source can't be specified at the same time")
+		return nil, newNonRetryableError(fmt.Errorf("sourceRef and source can't be specified at the same time"))
 	}
 	if obj.Spec.Source == nil && obj.Spec.SourceRef == nil {
-		return nil, fmt.Errorf("source not specified")
+		return nil, newNonRetryableError(fmt.Errorf("source not specified"))
 	}
 	if obj.Spec.Path != "" && obj.Spec.Source != nil {
-		return nil, fmt.Errorf("path and source can't be specified at the same time")
+		return nil, newNonRetryableError(fmt.Errorf("path and source can't be specified at the same time"))
 	}
 
 	var sourceSpec kluctlv1.ProjectSource
 
 	if obj.Spec.SourceRef != nil {
-		source, err := r.getDeprecatedSource(ctx, *obj.Spec.SourceRef, obj.GetNamespace(), noCrossNamespaceRefs)
+		source, err := r.getSourceObject(ctx, *obj.Spec.SourceRef, obj.GetNamespace(), noCrossNamespaceRefs)
 		if err != nil {
 			return nil, err
 		}
-		if source.Spec.Reference.Commit != "" || source.Spec.Reference.SemVer != "" {
-			return nil, fmt.Errorf("commit and semVer are not supported as git ref")
-		}
-		sourceSpec = kluctlv1.ProjectSource{
-			URL: source.Spec.URL,
-		}
-		if source.Spec.Reference != nil {
-			sourceSpec.Ref = &kluctlv1.GitRef{
-				Branch: source.Spec.Reference.Branch,
-				Tag:    source.Spec.Reference.Tag,
-				//Commit: source.Spec.Reference.Commit,
+		switch source := source.(type) {
+		case *sourcev1.GitRepository:
+			sourceSpec = kluctlv1.ProjectSource{
+				URL: source.Spec.URL,
 			}
-		}
-		if source.Spec.SecretRef != nil {
-			sourceSpec.SecretRef = &meta.LocalObjectReference{
-				Name: source.Spec.SecretRef.Name,
+			if source.Spec.Reference != nil {
+				sourceSpec.Ref = &kluctlv1.GitRef{
+					Branch: source.Spec.Reference.Branch,
+					Tag:    source.Spec.Reference.Tag,
+					SemVer: source.Spec.Reference.SemVer,
+					Commit: source.Spec.Reference.Commit,
+				}
+			}
+			if source.Spec.SecretRef != nil {
+				sourceSpec.SecretRef = &meta.LocalObjectReference{
+					Name: source.Spec.SecretRef.Name,
+				}
+			}
+		case *sourcev1.Bucket:
+			sourceSpec = kluctlv1.ProjectSource{
+				Bucket: &meta.NamespacedObjectReference{
+					Name:      source.GetName(),
+					Namespace: source.GetNamespace(),
+				},
 			}
 		}
 	} else {
 		sourceSpec = *obj.Spec.Source
+		cnt := 0
+		if sourceSpec.URL != "" {
+			cnt++
+		}
+		if sourceSpec.Bucket != nil {
+			cnt++
+		}
+		if sourceSpec.Oci != nil {
+			cnt++
+		}
+		if cnt != 1 {
+			return nil, newNonRetryableError(fmt.Errorf("exactly one of url, bucket or oci must be specified in source"))
+		}
+		if sourceSpec.Bucket != nil {
+			// ensure that the bucket exists and that we're allowed to access it
+			_, err := r.getSourceObject(ctx, bucketSourceRef(sourceSpec.Bucket), obj.GetNamespace(), noCrossNamespaceRefs)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	if obj.Spec.Path != "" {
 		sourceSpec.Path = obj.Spec.Path
@@ -68,8 +98,33 @@ func (r *KluctlDeploymentReconciler) getProjectSource(ctx context.Context, obj *
 	return &sourceSpec, nil
 }
 
-func (r *KluctlDeploymentReconciler) getDeprecatedSource(ctx context.Context, ref meta.NamespacedObjectKindReference, holderNs string, noCrossNamespaceRefs bool) (*sourcev1.GitRepository, error) {
-	var source *sourcev1.GitRepository
+// buildSourceUrl returns the url of the given source. Buckets and OCI artifacts have no git url, so a url built from
+// the Bucket reference or the OCI repository is returned instead.
+func buildSourceUrl(obj *kluctlv1.KluctlDeployment, source *kluctlv1.ProjectSource) string {
+	if source.Bucket != nil {
+		ns := source.Bucket.Namespace
+		if ns == "" {
+			ns = obj.GetNamespace()
+		}
+		return fmt.Sprintf("bucket://%s/%s", ns, source.Bucket.Name)
+	}
+	if source.Oci != nil {
+		return "oci://" + strings.TrimPrefix(source.Oci.Repository, "oci://")
+	}
+	return source.URL
+}
+
+func bucketSourceRef(ref *meta.NamespacedObjectReference) meta.NamespacedObjectKindReference {
+	return meta.NamespacedObjectKindReference{
+		APIVersion: sourcev1.GroupVersion.String(),
+		Kind:       sourcev1.BucketKind,
+		Name:       ref.Name,
+		Namespace:  ref.Namespace,
+	}
+}
+
+func (r *KluctlDeploymentReconciler) getSourceObject(ctx context.Context, ref meta.NamespacedObjectKindReference, holderNs string, noCrossNamespaceRefs bool) (sourcev1.Source, error) {
+	var source sourcev1.Source
 	sourceNamespace := holderNs
 	if ref.Namespace != "" {
 		sourceNamespace = ref.Namespace
@@ -96,31 +151,41 @@ func (r *KluctlDeploymentReconciler) getDeprecatedSource(ctx context.Context, re
 			return source, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
 		}
 		source = &repository
+	case sourcev1.BucketKind:
+		var bucket sourcev1.Bucket
+		err := r.Get(ctx, namespacedName, &bucket)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return source, err
+			}
+			return source, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
+		}
+		source = &bucket
 	default:
-		return source, fmt.Errorf("source `%s` kind '%s' not supported",
-			ref.Name, ref.Kind)
+		return source, newNonRetryableError(fmt.Errorf("source `%s` kind '%s' not supported",
+			ref.Name, ref.Kind))
 	}
 	return source, nil
 }
 
-func (r *KluctlDeploymentReconciler) getGitSecret(ctx context.Context, source *kluctlv1.ProjectSource, objNs string) (*corev1.Secret, error) {
-	if source == nil || source.SecretRef == nil {
+func getGitSecret(ctx context.Context, c client.Reader, secretRef *meta.LocalObjectReference, objNs string) (*corev1.Secret, error) {
+	if secretRef == nil {
 		return nil, nil
 	}
 
 	// Attempt to retrieve secret
 	name := types.NamespacedName{
 		Namespace: objNs,
-		Name:      source.SecretRef.Name,
+		Name:      secretRef.Name,
 	}
 	var secret corev1.Secret
-	if err := r.Get(ctx, name, &secret); err != nil {
+	if err := c.Get(ctx, name, &secret); err != nil {
 		return nil, fmt.Errorf("failed to get secret '%s': %w", name.String(), err)
 	}
 	return &secret, nil
 }
 
-func (r *KluctlDeploymentReconciler) buildGitAuth(ctx context.Context, gitSecret *corev1.Secret) (*auth.GitAuthProviders, error) {
+func buildGitAuth(ctx context.Context, gitSecret *corev1.Secret) (*auth.GitAuthProviders, error) {
 	log := ctrl.LoggerFrom(ctx)
 	ga := auth.NewDefaultAuthProviders("KLUCTL_GIT", &messages.MessageCallbacks{
 		WarningFn: func(s string) {
@@ -162,33 +227,191 @@ func (r *KluctlDeploymentReconciler) buildGitAuth(ctx context.Context, gitSecret
 	return ga, nil
 }
 
-func (r *KluctlDeploymentReconciler) buildRepoCache(ctx context.Context, secret *corev1.Secret) (*repocache.GitRepoCache, error) {
-	// make sure we use a unique repo cache per set of credentials
-	h := sha256.New()
-	if secret == nil {
-		h.Write([]byte("no-secret"))
-	} else {
-		m := json.NewEncoder(h)
-		err := m.Encode(secret.Data)
+// checkoutCommit checks out the given commit inside an already cloned repository and returns the full commit hash.
+func checkoutCommit(dir string, commit string) (string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to open cloned repository: %w", err)
+	}
+	h, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve commit '%s': %w", commit, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	err = wt.Checkout(&git.CheckoutOptions{
+		Hash:  *h,
+		Force: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to checkout commit '%s': %w", commit, err)
+	}
+	return h.String(), nil
+}
+
+// commitTimeGetter returns the commit time of the given commit (or annotated tag) hash
+type commitTimeGetter func(hash string) (time.Time, error)
+
+// newCommitTimeGetter returns a commitTimeGetter that lazily clones the repository on first use, so that commit
+// objects can be looked up.
+func newCommitTimeGetter(rpEntry *repocache.CacheEntry) commitTimeGetter {
+	var repo *git.Repository
+	return func(hash string) (time.Time, error) {
+		if repo == nil {
+			dir, _, err := rpEntry.GetClonedDir("")
+			if err != nil {
+				return time.Time{}, err
+			}
+			repo, err = git.PlainOpen(dir)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to open cloned repository: %w", err)
+			}
+		}
+		h := plumbing.NewHash(hash)
+		c, err := repo.CommitObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			// might be an annotated tag
+			tag, err2 := repo.TagObject(h)
+			if err2 != nil {
+				return time.Time{}, fmt.Errorf("failed to lookup commit %s: %w", hash, err)
+			}
+			c, err = tag.Commit()
+		}
 		if err != nil {
-			return nil, err
+			return time.Time{}, fmt.Errorf("failed to lookup commit %s: %w", hash, err)
 		}
+		return c.Committer.When, nil
 	}
-	h2 := hex.EncodeToString(h.Sum(nil))
+}
 
-	tmpBaseDir := filepath.Join(os.TempDir(), "kluctl-controller-repo-cache", h2)
-	err := os.MkdirAll(tmpBaseDir, 0o700)
+// resolveGitRef resolves the given GitRef against the remote refs of the repository and returns the full ref that
+// must be checked out. An empty string means that the default branch should be used.
+//
+// Branch and Tag are first matched literally. If no such ref exists, they are treated as regular expressions that
+// must match the whole branch/tag name. See resolveRefPattern for how a ref is chosen when multiple refs match.
+func resolveGitRef(ref *kluctlv1.GitRef, remoteRefs map[string]string, getCommitTime commitTimeGetter) (string, error) {
+	if ref == nil {
+		return "", nil
+	}
+	if ref.SemVer != "" {
+		return resolveSemVerTag(ref.SemVer, remoteRefs)
+	}
+	if ref.Tag != "" {
+		return resolveRefPattern("refs/tags/", ref.Tag, true, remoteRefs, getCommitTime)
+	}
+	if ref.Branch != "" {
+		return resolveRefPattern("refs/heads/", ref.Branch, false, remoteRefs, getCommitTime)
+	}
+	return "", nil
+}
+
+// resolveRefPattern resolves a branch or tag pattern. If preferSemVer is true and at least one of the matching refs
+// is a semantic version, the highest version is chosen. Otherwise, the ref with the most recent commit is chosen.
+// Ties are resolved by choosing the lexicographically smallest ref name.
+func resolveRefPattern(prefix string, pattern string, preferSemVer bool, remoteRefs map[string]string, getCommitTime commitTimeGetter) (string, error) {
+	if _, ok := remoteRefs[prefix+pattern]; ok {
+		return prefix + pattern, nil
+	}
+
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("ref '%s' not found and is not a valid regex: %w", prefix+pattern, err)
 	}
 
-	ctx = utils.WithTmpBaseDir(ctx, tmpBaseDir)
+	var matches []string
+	for r := range remoteRefs {
+		if !strings.HasPrefix(r, prefix) || strings.HasSuffix(r, "^{}") {
+			continue
+		}
+		if re.MatchString(strings.TrimPrefix(r, prefix)) {
+			matches = append(matches, r)
+		}
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no ref found that matches '%s'", prefix+pattern)
+	}
+	sort.Strings(matches)
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
 
-	ga, err := r.buildGitAuth(ctx, secret)
+	if preferSemVer {
+		var bestRef string
+		var bestVersion *semver.Version
+		for _, r := range matches {
+			v, err := semver.NewVersion(strings.TrimPrefix(r, prefix))
+			if err != nil {
+				continue
+			}
+			if bestVersion == nil || v.GreaterThan(bestVersion) {
+				bestRef = r
+				bestVersion = v
+			}
+		}
+		if bestVersion != nil {
+			return bestRef, nil
+		}
+	}
+
+	var bestRef string
+	var bestTime time.Time
+	for _, r := range matches {
+		t, err := getCommitTime(remoteRefs[r])
+		if err != nil {
+			return "", err
+		}
+		if bestRef == "" || t.After(bestTime) {
+			bestRef = r
+			bestTime = t
+		}
+	}
+	return bestRef, nil
+}
+
+// resolveSemVerTag returns the tag ref with the highest version that matches the given semver constraint.
+func resolveSemVerTag(constraint string, remoteRefs map[string]string) (string, error) {
+	var tags []string
+	for r := range remoteRefs {
+		if !strings.HasPrefix(r, "refs/tags/") || strings.HasSuffix(r, "^{}") {
+			continue
+		}
+		tags = append(tags, strings.TrimPrefix(r, "refs/tags/"))
+	}
+	tag, err := findHighestSemVer(constraint, tags)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	return "refs/tags/" + tag, nil
+}
 
-	rc := repocache.NewGitRepoCache(ctx, r.SshPool, ga, nil, 0)
-	return rc, nil
+// findHighestSemVer returns the tag with the highest version that matches the given semver constraint. Tags that
+// are not valid semantic versions are ignored.
+func findHighestSemVer(constraint string, tags []string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("semver constraint '%s' is invalid: %w", constraint, err)
+	}
+
+	var bestTag string
+	var bestVersion *semver.Version
+	for _, t := range tags {
+		v, err := semver.NewVersion(t)
+		if err != nil {
+			// not a version tag
+			continue
+		}
+		if !c.Check(v) {
+			continue
+		}
+		if bestVersion == nil || v.GreaterThan(bestVersion) || (v.Equal(bestVersion) && t < bestTag) {
+			bestTag = t
+			bestVersion = v
+		}
+	}
+	if bestVersion == nil {
+		return "", fmt.Errorf("no tag found that matches semver constraint '%s'", constraint)
+	}
+	return bestTag, nil
 }
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestResolveSemVerTag(t *testing.T) {
+	g := NewWithT(t)
+
+	remoteRefs := map[string]string{
+		"refs/heads/main":    "c0",
+		"refs/tags/v1.0.0":   "c1",
+		"refs/tags/v1.2.0":   "c2",
+		"refs/tags/v1.10.1":  "c3",
+		"refs/tags/v2.0.0":   "c4",
+		"refs/tags/latest":   "c5",
+		"refs/tags/1.3.0-rc": "c6",
+	}
+
+	tests := []struct {
+		constraint string
+		ref        string
+	}{
+		{">=1.0.0", "refs/tags/v2.0.0"},
+		{">=1.2.0 <2.0.0", "refs/tags/v1.10.1"},
+		{"~1.2", "refs/tags/v1.2.0"},
+		{"1.0.0", "refs/tags/v1.0.0"},
+	}
+	for _, tc := range tests {
+		ref, err := resolveSemVerTag(tc.constraint, remoteRefs)
+		g.Expect(err).To(Succeed(), tc.constraint)
+		g.Expect(ref).To(Equal(tc.ref), tc.constraint)
+	}
+
+	_, err := resolveSemVerTag(">=3.0.0", remoteRefs)
+	g.Expect(err).To(HaveOccurred())
+	_, err = resolveSemVerTag("invalid", remoteRefs)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestResolveGitRefPatterns(t *testing.T) {
+	g := NewWithT(t)
+
+	remoteRefs := map[string]string{
+		"refs/heads/main":        "c0",
+		"refs/heads/release-1.0": "c1",
+		"refs/heads/release-1.1": "c2",
+		"refs/heads/release-2.0": "c3",
+		"refs/tags/v1.0.0":       "c4",
+		"refs/tags/v1.10.0":      "c5",
+		"refs/tags/v1.9.0":       "c6",
+		"refs/tags/prod-a":       "c7",
+		"refs/tags/prod-b":       "c8",
+	}
+	commitTimes := map[string]time.Time{}
+	for i, c := range []string{"c0", "c1", "c3", "c2", "c4", "c5", "c6", "c8", "c7"} {
+		commitTimes[c] = time.Date(2023, 1, 1, i, 0, 0, 0, time.UTC)
+	}
+	getCommitTime := func(hash string) (time.Time, error) {
+		return commitTimes[hash], nil
+	}
+
+	tests := []struct {
+		ref      kluctlv1.GitRef
+		expected string
+	}{
+		{kluctlv1.GitRef{}, ""},
+		{kluctlv1.GitRef{Branch: "main"}, "refs/heads/main"},
+		{kluctlv1.GitRef{Branch: "release-1.0"}, "refs/heads/release-1.0"},
+		{kluctlv1.GitRef{Branch: "release-.*"}, "refs/heads/release-1.1"},
+		{kluctlv1.GitRef{Branch: "release-2.*"}, "refs/heads/release-2.0"},
+		{kluctlv1.GitRef{Tag: "v1.*"}, "refs/tags/v1.10.0"},
+		{kluctlv1.GitRef{Tag: "prod-.*"}, "refs/tags/prod-a"},
+		{kluctlv1.GitRef{Tag: "v1.0.0"}, "refs/tags/v1.0.0"},
+	}
+	for _, tc := range tests {
+		ref, err := resolveGitRef(&tc.ref, remoteRefs, getCommitTime)
+		g.Expect(err).To(Succeed(), tc.ref.String())
+		g.Expect(ref).To(Equal(tc.expected), tc.ref.String())
+	}
+
+	_, err := resolveGitRef(&kluctlv1.GitRef{Branch: "feature-.*"}, remoteRefs, getCommitTime)
+	g.Expect(err).To(HaveOccurred())
+	_, err = resolveGitRef(&kluctlv1.GitRef{Branch: "("}, remoteRefs, getCommitTime)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestBuildSourceUrl(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns"}}
+	g.Expect(buildSourceUrl(obj, &kluctlv1.ProjectSource{URL: "https://example.com/repo.git"})).To(Equal("https://example.com/repo.git"))
+	g.Expect(buildSourceUrl(obj, &kluctlv1.ProjectSource{Bucket: &meta.NamespacedObjectReference{Name: "b"}})).To(Equal("bucket://ns/b"))
+	g.Expect(buildSourceUrl(obj, &kluctlv1.ProjectSource{Bucket: &meta.NamespacedObjectReference{Name: "b", Namespace: "other"}})).To(Equal("bucket://other/b"))
+	g.Expect(buildSourceUrl(obj, &kluctlv1.ProjectSource{Oci: &kluctlv1.OciSource{Repository: "ghcr.io/example/project"}})).To(Equal("oci://ghcr.io/example/project"))
+	g.Expect(buildSourceUrl(obj, &kluctlv1.ProjectSource{Oci: &kluctlv1.OciSource{Repository: "oci://ghcr.io/example/project"}})).To(Equal("oci://ghcr.io/example/project"))
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	internal_metrics "github.com/kluctl/kluctl/v2/pkg/controllers/metrics"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"time"
+)
+
+// targetReconcileResult is the outcome of reconciling a single target selected via spec.targets
+type targetReconcileResult struct {
+	status          kluctlv1.TargetStatus
+	nextReconcile   time.Time
+	deployPending   bool
+	approvalPending bool
+	err             error
+}
+
+// doReconcileTargets reconciles all targets selected via spec.targets. The project is loaded only once and all targets
+// are rendered from the same clone. Results and conditions are stored per target in status.targets, while the
+// top-level Ready condition aggregates the readiness of all targets.
+func (r *KluctlDeploymentReconciler) doReconcileTargets(ctx context.Context, pp *preparedProject, deployAllowed bool) (*ctrl.Result, string, error) {
+	obj := pp.obj
+
+	if obj.Spec.Target != nil || obj.Spec.TargetNameOverride != nil {
+		err := newNonRetryableError(fmt.Errorf("spec.targets can not be used together with spec.target or spec.targetNameOverride"))
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
+		setStalled(obj, kluctlv1.PrepareFailedReason, err.Error())
+		return nil, pp.sourceRevision, err
+	}
+
+	pt, err := pp.newTarget()
+	if err != nil {
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
+		return nil, pp.sourceRevision, err
+	}
+
+	var results []targetReconcileResult
+	selected := map[string]bool{}
+	err = pp.withKluctlProject(ctx, pt, func(p *kluctl_project.LoadedKluctlProject) error {
+		names, err := selectTargets(p, obj.Spec.Targets)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			selected[name] = true
+			results = append(results, r.reconcileSelectedTarget(ctx, pp, p, name, deployAllowed))
+		}
+		return nil
+	})
+	if err != nil {
+		setReadinessWithRevision(obj, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
+		if isNonRetryableError(err) {
+			setStalled(obj, kluctlv1.PrepareFailedReason, err.Error())
+		}
+		return nil, pp.sourceRevision, err
+	}
+
+	// per target results are only reported in status.targets. The discriminator is kept so that objects deployed
+	// before switching to spec.targets are still deleted on finalization.
+	obj.Status.RawTarget = nil
+	obj.Status.LastDeployResult = nil
+	obj.Status.LastPruneResult = nil
+	obj.Status.LastValidateResult = nil
+	obj.Status.PendingApproval = nil
+	obj.Status.LastDriftDetectionResult = nil
+	for _, c := range []string{kluctlv1.DeployedCondition, kluctlv1.PrunedCondition, kluctlv1.HealthyCondition,
+		kluctlv1.DeployPendingCondition, kluctlv1.ApprovalPendingCondition, kluctlv1.DriftedCondition, kluctlv1.PruneBlockedCondition} {
+		removeCondition(obj, c)
+	}
+
+	// targets that are not selected anymore are kept until their objects are pruned, as the discriminator would
+	// otherwise be lost. Failed prunes are reported like failed targets.
+	var deselected []kluctlv1.TargetStatus
+	for _, ts := range obj.Status.Targets {
+		if selected[ts.Name] || ts.Discriminator == "" {
+			continue
+		}
+		tr := r.pruneDeselectedTarget(ctx, pp, ts.Name)
+		if tr == nil {
+			continue
+		}
+		if tr.err != nil {
+			results = append(results, *tr)
+		} else {
+			deselected = append(deselected, tr.status)
+		}
+	}
+
+	obj.Status.Targets = nil
+	anyPending := false
+	for _, tr := range results {
+		obj.Status.Targets = append(obj.Status.Targets, tr.status)
+		if tr.deployPending || tr.approvalPending {
+			anyPending = true
+		}
+	}
+	obj.Status.Targets = append(obj.Status.Targets, deselected...)
+
+	obj.Status.ObservedGeneration = obj.GetGeneration()
+	if cleanupErr := r.cleanupCommandResults(ctx, obj); cleanupErr != nil {
+		ctrl.LoggerFrom(ctx).Error(cleanupErr, "failed to cleanup old command results")
+	}
+	if v, ok := obj.GetAnnotations()[kluctlv1.KluctlDeployRequestAnnotation]; ok && !anyPending {
+		obj.Status.LastHandledDeployAt = v
+	}
+
+	var ctrlResult ctrl.Result
+	nextReconcile := time.Now().Add(obj.Spec.Interval.Duration)
+	for _, tr := range results {
+		if tr.nextReconcile.Before(nextReconcile) {
+			nextReconcile = tr.nextReconcile
+		}
+	}
+	ctrlResult.RequeueAfter = nextReconcile.Sub(time.Now())
+	if ctrlResult.RequeueAfter < 0 {
+		ctrlResult.RequeueAfter = 0
+		ctrlResult.Requeue = true
+	}
+
+	err = aggregateTargetReadiness(obj, pp.sourceRevision, results)
+	if err != nil {
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(0.0)
+		return &ctrlResult, pp.sourceRevision, err
+	}
+	if apimeta.IsStatusConditionTrue(obj.Status.Conditions, meta.ReadyCondition) {
+		internal_metrics.NewKluctlLastObjectStatus(obj.Namespace, obj.Name).Set(1.0)
+	}
+	return &ctrlResult, pp.sourceRevision, nil
+}
+
+// reconcileSelectedTarget reconciles a single target of the already loaded project. It works on a copy of the
+// KluctlDeployment that has spec.target set to the given target and the per-target status fields taken from
+// status.targets, so that the single target reconciliation logic can be reused as is.
+func (r *KluctlDeploymentReconciler) reconcileSelectedTarget(ctx context.Context, pp *preparedProject, p *kluctl_project.LoadedKluctlProject, name string, deployAllowed bool) targetReconcileResult {
+	obj := pp.obj
+	shadow := newTargetShadow(obj, name)
+
+	spp := *pp
+	spp.obj = shadow
+	spp.selectedTarget = name
+
+	var tr targetReconcileResult
+	pt, err := spp.newTarget()
+	if err == nil {
+		var targetContext *kluctl_project.TargetContext
+		targetContext, err = pt.newTargetContext(ctx, p)
+		if err == nil {
+			tr.deployPending, tr.approvalPending, err = r.reconcileTarget(ctx, pt, targetContext, deployAllowed)
+		}
+	}
+	if err != nil {
+		setReadinessWithRevision(shadow, metav1.ConditionFalse, kluctlv1.PrepareFailedReason, err.Error(), pp.sourceRevision)
+		if isNonRetryableError(err) {
+			setStalled(shadow, kluctlv1.PrepareFailedReason, err.Error())
+		}
+		tr.nextReconcile = time.Now().Add(obj.Spec.GetRetryInterval())
+	} else {
+		err = r.updateReadiness(shadow, pp.sourceRevision, tr.deployPending, tr.approvalPending)
+		tr.nextReconcile = r.nextReconcileTime(shadow)
+	}
+	tr.err = err
+
+	// history and backups are shared between all targets
+	obj.Status.History = shadow.Status.History
+	obj.Status.Backups = shadow.Status.Backups
+
+	tr.status = buildTargetStatus(shadow, name)
+	return tr
+}
+
+// pruneDeselectedTarget prunes all objects of a target that is not selected by spec.targets anymore. nil is returned
+// when the target was pruned and can be removed from status.targets. If spec.prune is disabled, the target is only
+// marked as deselected, so that its objects are still deleted on finalization.
+func (r *KluctlDeploymentReconciler) pruneDeselectedTarget(ctx context.Context, pp *preparedProject, name string) *targetReconcileResult {
+	obj := pp.obj
+	shadow := newTargetShadow(obj, name)
+
+	var tr targetReconcileResult
+	if obj.Spec.Prune {
+		spp := *pp
+		spp.obj = shadow
+		spp.selectedTarget = name
+
+		pt, err := spp.newTarget()
+		if err == nil {
+			_, err = pt.kluctlPruneDeselected(ctx, shadow.Status.Discriminator)
+		}
+
+		// history and backups are shared between all targets
+		obj.Status.History = shadow.Status.History
+		obj.Status.Backups = shadow.Status.Backups
+
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errPruneDryRun) {
+			setReadinessWithRevision(shadow, metav1.ConditionFalse, kluctlv1.PruneFailedReason,
+				fmt.Sprintf("prune of deselected target failed: %s", err.Error()), pp.sourceRevision)
+			tr.err = err
+			tr.nextReconcile = time.Now().Add(obj.Spec.GetRetryInterval())
+		}
+	}
+
+	tr.status = buildTargetStatus(shadow, name)
+	tr.status.Deselected = true
+	return &tr
+}
+
+func buildTargetStatus(shadow *kluctlv1.KluctlDeployment, name string) kluctlv1.TargetStatus {
+	return kluctlv1.TargetStatus{
+		Name:                     name,
+		Conditions:               shadow.Status.Conditions,
+		Discriminator:            shadow.Status.Discriminator,
+		RawTarget:                shadow.Status.RawTarget,
+		LastDeployResult:         shadow.Status.LastDeployResult,
+		LastPruneResult:          shadow.Status.LastPruneResult,
+		LastValidateResult:       shadow.Status.LastValidateResult,
+		PendingApproval:          shadow.Status.PendingApproval,
+		LastDriftDetectionResult: shadow.Status.LastDriftDetectionResult,
+	}
+}
+
+// newTargetShadow returns a copy of the given KluctlDeployment that looks like a single target KluctlDeployment for
+// the given target.
+func newTargetShadow(obj *kluctlv1.KluctlDeployment, name string) *kluctlv1.KluctlDeployment {
+	shadow := obj.DeepCopy()
+	shadow.Spec.Target = &name
+	shadow.Spec.Targets = nil
+	// rollbacks are not supported with spec.targets
+	shadow.Spec.Rollback = nil
+
+	// approvals are scoped per target
+	if hash := getTargetApprovedHash(obj, name); hash != "" {
+		shadow.Annotations[kluctlv1.KluctlApprovedHashAnnotation] = hash
+	} else {
+		delete(shadow.Annotations, kluctlv1.KluctlApprovedHashAnnotation)
+	}
+
+	shadow.Status.Conditions = nil
+	shadow.Status.Discriminator = ""
+	shadow.Status.RawTarget = nil
+	shadow.Status.LastDeployResult = nil
+	shadow.Status.LastPruneResult = nil
+	shadow.Status.LastValidateResult = nil
+	shadow.Status.PendingApproval = nil
+	shadow.Status.LastDriftDetectionResult = nil
+	shadow.Status.Targets = nil
+
+	if ts := obj.Status.GetTargetStatus(name); ts != nil {
+		ts = ts.DeepCopy()
+		shadow.Status.Conditions = ts.Conditions
+		shadow.Status.Discriminator = ts.Discriminator
+		shadow.Status.RawTarget = ts.RawTarget
+		shadow.Status.LastDeployResult = ts.LastDeployResult
+		shadow.Status.LastPruneResult = ts.LastPruneResult
+		shadow.Status.LastValidateResult = ts.LastValidateResult
+		shadow.Status.PendingApproval = ts.PendingApproval
+		shadow.Status.LastDriftDetectionResult = ts.LastDriftDetectionResult
+	}
+	return shadow
+}
+
+// aggregateTargetReadiness sets the top-level Ready and Stalled conditions from the per-target conditions. An error
+// is returned if any of the targets failed.
+func aggregateTargetReadiness(obj *kluctlv1.KluctlDeployment, revision string, results []targetReconcileResult) error {
+	var failedReason string
+	var failedMsgs []string
+	var stalled *metav1.Condition
+	failed := false
+	for _, tr := range results {
+		if tr.err != nil {
+			failed = true
+		}
+		if c := apimeta.FindStatusCondition(tr.status.Conditions, meta.StalledCondition); c != nil && c.Status == metav1.ConditionTrue && stalled == nil {
+			stalled = c
+		}
+		c := apimeta.FindStatusCondition(tr.status.Conditions, meta.ReadyCondition)
+		if c != nil && c.Status == metav1.ConditionTrue {
+			continue
+		}
+		msg := "unknown"
+		reason := meta.ProgressingReason
+		if c != nil {
+			msg = c.Message
+			reason = c.Reason
+		}
+		if failedReason == "" {
+			failedReason = reason
+		}
+		failedMsgs = append(failedMsgs, fmt.Sprintf("%s: %s", tr.status.Name, msg))
+	}
+
+	if stalled != nil {
+		setStalled(obj, stalled.Reason, stalled.Message)
+	}
+	if len(failedMsgs) != 0 {
+		msg := strings.Join(failedMsgs, ", ")
+		setReadinessWithRevision(obj, metav1.ConditionFalse, failedReason, msg, revision)
+		if failed {
+			return errors.New(msg)
+		}
+		return nil
+	}
+	setReadinessWithRevision(obj, metav1.ConditionTrue, kluctlv1.ReconciliationSucceededReason,
+		fmt.Sprintf("%d targets reconciled", len(results)), revision)
+	return nil
+}
+
+// selectTargets returns the names of all targets matching the given selector, in the order in which they appear in
+// the project. All explicitly listed names must exist in the project.
+func selectTargets(p *kluctl_project.LoadedKluctlProject, selector *kluctlv1.TargetSelector) ([]string, error) {
+	for _, n := range selector.Names {
+		if !hasTarget(p, n) {
+			return nil, newNonRetryableError(fmt.Errorf("target %s not found in kluctl project", n))
+		}
+	}
+
+	var re *regexp.Regexp
+	if selector.NamePattern != "" {
+		var err error
+		re, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", selector.NamePattern))
+		if err != nil {
+			return nil, newNonRetryableError(fmt.Errorf("invalid target name pattern: %w", err))
+		}
+	}
+
+	var ret []string
+	for _, t := range p.Targets {
+		selected := false
+		for _, n := range selector.Names {
+			if t.Name == n {
+				selected = true
+				break
+			}
+		}
+		if re != nil && re.MatchString(t.Name) {
+			selected = true
+		}
+		if selected {
+			ret = append(ret, t.Name)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, newNonRetryableError(fmt.Errorf("spec.targets did not select any target"))
+	}
+	return ret, nil
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/kluctl_project"
+	"github.com/kluctl/kluctl/v2/pkg/types"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestSelectTargets(t *testing.T) {
+	g := NewWithT(t)
+
+	p := &kluctl_project.LoadedKluctlProject{
+		Targets: []*types.Target{
+			{Name: "prod-eu"},
+			{Name: "test"},
+			{Name: "prod-us"},
+			{Name: "prod"},
+		},
+	}
+
+	names, err := selectTargets(p, &kluctlv1.TargetSelector{Names: []string{"test", "prod"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(names).To(Equal([]string{"test", "prod"}))
+
+	names, err = selectTargets(p, &kluctlv1.TargetSelector{NamePattern: "prod-.*"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(names).To(Equal([]string{"prod-eu", "prod-us"}))
+
+	// the pattern must match the whole name and targets are not selected twice
+	names, err = selectTargets(p, &kluctlv1.TargetSelector{Names: []string{"prod-us"}, NamePattern: "prod|prod-us"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(names).To(Equal([]string{"prod-us", "prod"}))
+
+	_, err = selectTargets(p, &kluctlv1.TargetSelector{Names: []string{"missing"}})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(isNonRetryableError(err)).To(BeTrue())
+
+	_, err = selectTargets(p, &kluctlv1.TargetSelector{NamePattern: "dev.*"})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(isNonRetryableError(err)).To(BeTrue())
+
+	_, err = selectTargets(p, &kluctlv1.TargetSelector{NamePattern: "("})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(isNonRetryableError(err)).To(BeTrue())
+}
+
+func TestNewTargetShadow(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "name",
+			Annotations: map[string]string{
+				kluctlv1.KluctlApprovedHashAnnotation: "t1=hash1, t2=hash2",
+			},
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Targets: &kluctlv1.TargetSelector{NamePattern: ".*"},
+		},
+		Status: kluctlv1.KluctlDeploymentStatus{
+			Targets: []kluctlv1.TargetStatus{
+				{Name: "t1", Discriminator: "d1"},
+				{Name: "t2", Discriminator: "d2", Deselected: true},
+			},
+		},
+	}
+
+	shadow := newTargetShadow(obj, "t1")
+	g.Expect(*shadow.Spec.Target).To(Equal("t1"))
+	g.Expect(shadow.Spec.Targets).To(BeNil())
+	g.Expect(shadow.Status.Targets).To(BeNil())
+	g.Expect(shadow.Status.Discriminator).To(Equal("d1"))
+	g.Expect(shadow.Annotations[kluctlv1.KluctlApprovedHashAnnotation]).To(Equal("hash1"))
+
+	shadow = newTargetShadow(obj, "t2")
+	g.Expect(shadow.Status.Discriminator).To(Equal("d2"))
+	g.Expect(shadow.Annotations[kluctlv1.KluctlApprovedHashAnnotation]).To(Equal("hash2"))
+
+	// approvals of other targets do not apply
+	shadow = newTargetShadow(obj, "t3")
+	g.Expect(shadow.Status.Discriminator).To(BeEmpty())
+	g.Expect(shadow.Annotations).ToNot(HaveKey(kluctlv1.KluctlApprovedHashAnnotation))
+
+	// plain hashes are not scoped to a target and thus not accepted with spec.targets
+	obj.Annotations[kluctlv1.KluctlApprovedHashAnnotation] = "hash1"
+	shadow = newTargetShadow(obj, "t1")
+	g.Expect(shadow.Annotations).ToNot(HaveKey(kluctlv1.KluctlApprovedHashAnnotation))
+	g.Expect(obj.Annotations[kluctlv1.KluctlApprovedHashAnnotation]).To(Equal("hash1"))
+}
+
+func TestAggregateTargetReadiness(t *testing.T) {
+	g := NewWithT(t)
+
+	ready := func(name string, status metav1.ConditionStatus, reason string, msg string) targetReconcileResult {
+		return targetReconcileResult{status: kluctlv1.TargetStatus{
+			Name: name,
+			Conditions: []metav1.Condition{
+				{Type: meta.ReadyCondition, Status: status, Reason: reason, Message: msg},
+			},
+		}}
+	}
+
+	obj := &kluctlv1.KluctlDeployment{}
+	err := aggregateTargetReadiness(obj, "rev", []targetReconcileResult{
+		ready("t1", metav1.ConditionTrue, kluctlv1.ReconciliationSucceededReason, "deploy: ok"),
+		ready("t2", metav1.ConditionTrue, kluctlv1.ReconciliationSucceededReason, "deploy: ok"),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(obj.Status.Conditions).To(HaveLen(1))
+	g.Expect(obj.Status.Conditions[0].Status).To(Equal(metav1.ConditionTrue))
+
+	failed := ready("t2", metav1.ConditionFalse, kluctlv1.DeployFailedReason, "deploy: failed")
+	failed.err = fmt.Errorf("deploy: failed")
+	obj = &kluctlv1.KluctlDeployment{}
+	err = aggregateTargetReadiness(obj, "rev", []targetReconcileResult{
+		ready("t1", metav1.ConditionFalse, kluctlv1.OutsideDeployWindowReason, "pending"),
+		failed,
+	})
+	g.Expect(err).To(MatchError("t1: pending, t2: deploy: failed"))
+	g.Expect(obj.Status.Conditions[0].Status).To(Equal(metav1.ConditionFalse))
+	g.Expect(obj.Status.Conditions[0].Reason).To(Equal(kluctlv1.OutsideDeployWindowReason))
+	g.Expect(obj.Status.Conditions[0].Message).To(Equal("t1: pending, t2: deploy: failed"))
+
+	// messages are not interpreted as format strings
+	failed = ready("t1", metav1.ConditionFalse, kluctlv1.PruneFailedReason, "deleting 66% of all objects exceeds the maximum of 50%")
+	failed.err = fmt.Errorf("prune failed")
+	obj = &kluctlv1.KluctlDeployment{}
+	err = aggregateTargetReadiness(obj, "rev", []targetReconcileResult{failed})
+	g.Expect(err).To(MatchError("t1: deleting 66% of all objects exceeds the maximum of 50%"))
+
+	// pending targets alone are not an error
+	obj = &kluctlv1.KluctlDeployment{}
+	err = aggregateTargetReadiness(obj, "rev", []targetReconcileResult{
+		ready("t1", metav1.ConditionFalse, kluctlv1.OutsideDeployWindowReason, "pending"),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(obj.Status.Conditions[0].Status).To(Equal(metav1.ConditionFalse))
+}
@@ -2,8 +2,11 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
 	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
 	"github.com/kluctl/kluctl/v2/e2e/test-utils"
 	"github.com/kluctl/kluctl/v2/pkg/utils"
@@ -11,9 +14,12 @@ import (
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"net/http/httptest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"strings"
 	"testing"
@@ -485,6 +491,39 @@ data:
 		}, cm)
 		g.Expect(err).To(MatchError("configmaps \"cm2\" not found"))
 	})
+
+	t.Run("Deployed and Pruned conditions are set", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.TODO(), kluctlDeploymentKey, kluctlDeployment)).To(Succeed())
+		deployed := apimeta.FindStatusCondition(kluctlDeployment.Status.Conditions, kluctlv1.DeployedCondition)
+		g.Expect(deployed).ToNot(BeNil())
+		g.Expect(deployed.Status).To(Equal(metav1.ConditionTrue))
+		g.Expect(deployed.Reason).To(Equal(kluctlv1.DeploySucceededReason))
+		g.Expect(deployed.Message).To(ContainSubstring(getHeadRevision(t, p)))
+		pruned := apimeta.FindStatusCondition(kluctlDeployment.Status.Conditions, kluctlv1.PrunedCondition)
+		g.Expect(pruned).ToNot(BeNil())
+		g.Expect(pruned.Status).To(Equal(metav1.ConditionTrue))
+		g.Expect(pruned.Reason).To(Equal(kluctlv1.PruneSucceededReason))
+	})
+
+	t.Run("history contains deploy and prune entries", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.TODO(), kluctlDeploymentKey, kluctlDeployment)).To(Succeed())
+		history := kluctlDeployment.Status.History
+		// initial deploy, deploy after source change, deploy after enabling prune and the prune itself
+		g.Expect(history).To(HaveLen(4))
+
+		deploy := history[len(history)-2]
+		prune := history[len(history)-1]
+		g.Expect(deploy.Command).To(Equal("deploy"))
+		g.Expect(deploy.Initiator).To(Equal(kluctlv1.InitiatorSpecChange))
+		g.Expect(deploy.Revision).To(Equal(getHeadRevision(t, p)))
+		g.Expect(deploy.Error).To(BeEmpty())
+		g.Expect(prune.Command).To(Equal("prune"))
+		g.Expect(prune.Summary).ToNot(BeNil())
+		g.Expect(prune.Summary.DeletedObjects).To(Equal(1))
+
+		g.Expect(history[0].Initiator).To(Equal(kluctlv1.InitiatorInitial))
+		g.Expect(history[1].Initiator).To(Equal(kluctlv1.InitiatorSourceChange))
+	})
 }
 
 func doTestDelete(t *testing.T, delete bool) {
@@ -592,3 +631,936 @@ func TestKluctlDeploymentReconciler_Delete_True(t *testing.T) {
 func TestKluctlDeploymentReconciler_Delete_False(t *testing.T) {
 	doTestDelete(t, false)
 }
+
+func TestKluctlDeploymentReconciler_Commit(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-commit-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	h, err := p.GetGitRepo().Head()
+	g.Expect(err).To(Succeed())
+	pinnedCommit := h.Hash().String()
+
+	p.UpdateYaml("d1/cm1.yaml", func(o *uo.UnstructuredObject) error {
+		_ = o.SetNestedField("v2", "data", "k1")
+		return nil
+	}, "")
+
+	err = createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	kluctlDeploymentKey := types.NamespacedName{
+		Name:      "kluctl-commit-" + randStringRunes(5),
+		Namespace: namespace,
+	}
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kluctlDeploymentKey.Name,
+			Namespace: kluctlDeploymentKey.Namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+				Ref: &kluctlv1.GitRef{
+					Commit: pinnedCommit,
+				},
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj kluctlv1.KluctlDeployment
+		_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		if obj.Status.LastDeployResult == nil {
+			return false
+		}
+		return obj.Status.LastDeployResult.Revision == "HEAD/"+pinnedCommit
+	}, timeout, time.Second).Should(BeTrue())
+
+	t.Run("cm1 is deployed from pinned commit", func(t *testing.T) {
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{
+			Name:      "cm1",
+			Namespace: namespace,
+		}, cm)
+		g.Expect(err).To(Succeed())
+		g.Expect(cm.Data).To(HaveKeyWithValue("k1", "v1"))
+	})
+}
+
+func TestKluctlDeploymentReconciler_SemVer(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-semver-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	createTag := func(name string) string {
+		h, err := p.GetGitRepo().Head()
+		g.Expect(err).To(Succeed())
+		_, err = p.GetGitRepo().CreateTag(name, h.Hash(), nil)
+		g.Expect(err).To(Succeed())
+		return h.Hash().String()
+	}
+	updateCm := func(v string) {
+		p.UpdateYaml("d1/cm1.yaml", func(o *uo.UnstructuredObject) error {
+			_ = o.SetNestedField(v, "data", "k1")
+			return nil
+		}, "")
+	}
+
+	createTag("v1.0.0")
+	updateCm("v2")
+	expectedCommit := createTag("v1.1.0")
+	updateCm("v3")
+	createTag("v2.0.0")
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	kluctlDeploymentKey := types.NamespacedName{
+		Name:      "kluctl-semver-" + randStringRunes(5),
+		Namespace: namespace,
+	}
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kluctlDeploymentKey.Name,
+			Namespace: kluctlDeploymentKey.Namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+				Ref: &kluctlv1.GitRef{
+					SemVer: ">=1.0.0 <2.0.0",
+				},
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	expectedRevision := fmt.Sprintf("refs/tags/v1.1.0/%s", expectedCommit)
+	g.Eventually(func() bool {
+		var obj kluctlv1.KluctlDeployment
+		_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		if obj.Status.LastDeployResult == nil {
+			return false
+		}
+		return obj.Status.LastDeployResult.Revision == expectedRevision && obj.Status.LastAttemptedRevision == expectedRevision &&
+			obj.Status.ResolvedRef == "refs/tags/v1.1.0"
+	}, timeout, time.Second).Should(BeTrue())
+
+	t.Run("constraint and resolved tag are reported", func(t *testing.T) {
+		var obj kluctlv1.KluctlDeployment
+		g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)).To(Succeed())
+		g.Expect(obj.Status.ResolvedSemVer).To(Equal(&kluctlv1.ResolvedSemVer{
+			Constraint: ">=1.0.0 <2.0.0",
+			Tag:        "v1.1.0",
+		}))
+	})
+
+	t.Run("cm1 is deployed from highest matching tag", func(t *testing.T) {
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{
+			Name:      "cm1",
+			Namespace: namespace,
+		}, cm)
+		g.Expect(err).To(Succeed())
+		g.Expect(cm.Data).To(HaveKeyWithValue("k1", "v2"))
+	})
+}
+
+func TestKluctlDeploymentReconciler_GitRepositorySourceRef(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-gitrepo-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	gitRepository := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-gitrepo-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: sourcev1.GitRepositorySpec{
+			URL:      p.GitUrl(),
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+		},
+	}
+	g.Expect(k8sClient.Create(context.TODO(), gitRepository)).To(Succeed())
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-gitrepo-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			SourceRef: &meta.NamespacedObjectKindReference{
+				Kind: sourcev1.GitRepositoryKind,
+				Name: gitRepository.Name,
+			},
+			Delete: true,
+		},
+	}
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj kluctlv1.KluctlDeployment
+		_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		if obj.Status.LastDeployResult == nil {
+			return false
+		}
+		return obj.Status.LastDeployResult.Revision == getHeadRevision(t, p)
+	}, timeout, time.Second).Should(BeTrue())
+
+	cm := &corev1.ConfigMap{}
+	t.Run("cm1 got deployed", func(t *testing.T) {
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{
+			Name:      "cm1",
+			Namespace: namespace,
+		}, cm)
+		g.Expect(err).To(Succeed())
+	})
+
+	g.Expect(k8sClient.Delete(context.TODO(), kluctlDeployment)).To(Succeed())
+	g.Eventually(func() bool {
+		var obj kluctlv1.KluctlDeployment
+		err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		return errors.IsNotFound(err)
+	}, timeout, time.Second).Should(BeTrue())
+
+	t.Run("cm1 was deleted", func(t *testing.T) {
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{
+			Name:      "cm1",
+			Namespace: namespace,
+		}, cm)
+		g.Expect(err).To(MatchError("configmaps \"cm1\" not found"))
+	})
+}
+
+func TestKluctlDeploymentReconciler_BucketSourceRef(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-bucket-" + randStringRunes(5)
+
+	tarball := buildTestTarball(t, map[string]string{
+		".kluctl.yaml": `targets:
+- name: target1
+`,
+		"deployment.yaml": `deployments:
+- path: d1
+`,
+		"d1/kustomization.yaml": `resources:
+- cm1.yaml
+`,
+		"d1/cm1.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`,
+	})
+	h := sha256.Sum256(tarball)
+	digest := "sha256:" + hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(tarball)
+	}))
+	defer server.Close()
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	bucket := &sourcev1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-bucket-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: sourcev1.BucketSpec{
+			BucketName: "bucket",
+			Endpoint:   "localhost",
+			Interval:   metav1.Duration{Duration: reconciliationInterval},
+		},
+	}
+	g.Expect(k8sClient.Create(context.TODO(), bucket)).To(Succeed())
+	bucket.Status.Artifact = &sourcev1.Artifact{
+		Path:           "bucket/artifact.tar.gz",
+		URL:            server.URL + "/artifact.tar.gz",
+		Revision:       digest,
+		Digest:         digest,
+		LastUpdateTime: metav1.Now(),
+	}
+	g.Expect(k8sClient.Status().Update(context.TODO(), bucket)).To(Succeed())
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-bucket-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			SourceRef: &meta.NamespacedObjectKindReference{
+				Kind: sourcev1.BucketKind,
+				Name: bucket.Name,
+			},
+		},
+	}
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	g.Eventually(func() bool {
+		var obj kluctlv1.KluctlDeployment
+		_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		if obj.Status.LastDeployResult == nil {
+			return false
+		}
+		return obj.Status.LastDeployResult.Revision == digest
+	}, timeout, time.Second).Should(BeTrue())
+
+	t.Run("cm1 is deployed from the bucket artifact", func(t *testing.T) {
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{
+			Name:      "cm1",
+			Namespace: namespace,
+		}, cm)
+		g.Expect(err).To(Succeed())
+		g.Expect(cm.Data).To(HaveKeyWithValue("k1", "v1"))
+	})
+}
+
+func TestKluctlDeploymentReconciler_DependsOn(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-depends-on-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	newKluctlDeployment := func(name string, dependsOn []meta.NamespacedObjectReference) *kluctlv1.KluctlDeployment {
+		return &kluctlv1.KluctlDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: kluctlv1.KluctlDeploymentSpec{
+				Interval: metav1.Duration{Duration: reconciliationInterval},
+				Timeout:  &metav1.Duration{Duration: timeout},
+				Target:   utils.StrPtr("target1"),
+				Args: runtime.RawExtension{
+					Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+				},
+				Source: &kluctlv1.ProjectSource{
+					URL: p.GitUrl(),
+				},
+				DependsOn: dependsOn,
+			},
+		}
+	}
+
+	dependency := newKluctlDeployment("kluctl-dependency-"+randStringRunes(5), nil)
+	dependent := newKluctlDeployment("kluctl-dependent-"+randStringRunes(5), []meta.NamespacedObjectReference{
+		{Name: dependency.Name},
+	})
+
+	g.Expect(k8sClient.Create(context.TODO(), dependent)).To(Succeed())
+
+	t.Run("dependent waits for dependency", func(t *testing.T) {
+		g.Eventually(func() bool {
+			var obj kluctlv1.KluctlDeployment
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(dependent), &obj)
+			for _, c := range obj.Status.Conditions {
+				if c.Type == meta.ReadyCondition && c.Reason == kluctlv1.DependencyNotReadyReason {
+					return obj.Status.LastDeployResult == nil
+				}
+			}
+			return false
+		}, timeout, time.Second).Should(BeTrue())
+	})
+
+	g.Expect(k8sClient.Create(context.TODO(), dependency)).To(Succeed())
+
+	t.Run("dependent gets deployed after dependency is ready", func(t *testing.T) {
+		g.Eventually(func() bool {
+			var obj kluctlv1.KluctlDeployment
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(dependent), &obj)
+			if obj.Status.LastDeployResult == nil {
+				return false
+			}
+			return obj.Status.LastDeployResult.Revision == getHeadRevision(t, p)
+		}, timeout, time.Second).Should(BeTrue())
+	})
+}
+
+func TestKluctlDeploymentReconciler_Stalled(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-stalled-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-stalled-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("missing-target"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	t.Run("missing target stalls reconciliation", func(t *testing.T) {
+		g.Eventually(func() bool {
+			var obj kluctlv1.KluctlDeployment
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			return apimeta.IsStatusConditionTrue(obj.Status.Conditions, meta.StalledCondition)
+		}, timeout, time.Second).Should(BeTrue())
+
+		var obj kluctlv1.KluctlDeployment
+		g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)).To(Succeed())
+		g.Expect(apimeta.IsStatusConditionFalse(obj.Status.Conditions, meta.ReadyCondition)).To(BeTrue())
+		g.Expect(apimeta.FindStatusCondition(obj.Status.Conditions, meta.ReconcilingCondition)).To(BeNil())
+	})
+
+	t.Run("fixing the target recovers from stalled state", func(t *testing.T) {
+		var obj kluctlv1.KluctlDeployment
+		g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)).To(Succeed())
+		patch := client.MergeFrom(obj.DeepCopy())
+		obj.Spec.Target = utils.StrPtr("target1")
+		g.Expect(k8sClient.Patch(context.Background(), &obj, patch)).To(Succeed())
+
+		g.Eventually(func() bool {
+			var obj kluctlv1.KluctlDeployment
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			return apimeta.IsStatusConditionTrue(obj.Status.Conditions, meta.ReadyCondition) &&
+				apimeta.FindStatusCondition(obj.Status.Conditions, meta.StalledCondition) == nil
+		}, timeout, time.Second).Should(BeTrue())
+	})
+}
+
+func TestKluctlDeploymentReconciler_ResultsStore(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-results-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	retention := 1
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-results-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+			},
+			ResultsStore: &kluctlv1.ResultsStore{
+				Kind:      kluctlv1.ResultsStoreKindSecret,
+				Retention: &retention,
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	var obj kluctlv1.KluctlDeployment
+	g.Eventually(func() bool {
+		_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+		return obj.Status.LastDeployResult != nil
+	}, timeout, time.Second).Should(BeTrue())
+
+	listResults := func() []corev1.Secret {
+		var l corev1.SecretList
+		g.Expect(k8sClient.List(context.TODO(), &l, client.InNamespace(namespace),
+			client.MatchingLabels{kluctlv1.KluctlDeploymentUidLabel: string(obj.UID)})).To(Succeed())
+		return l.Items
+	}
+
+	t.Run("result is stored in secret", func(t *testing.T) {
+		lr := obj.Status.LastDeployResult
+		g.Expect(lr.RawResult).To(BeNil())
+		g.Expect(lr.ResultRef).ToNot(BeNil())
+		g.Expect(lr.ResultRef.Kind).To(Equal(kluctlv1.ResultsStoreKindSecret))
+		g.Expect(lr.Summary).ToNot(BeNil())
+		g.Expect(lr.Summary.NewObjects).To(Equal(1))
+
+		var secret corev1.Secret
+		g.Expect(k8sClient.Get(context.TODO(), client.ObjectKey{Name: lr.ResultRef.Name, Namespace: namespace}, &secret)).To(Succeed())
+		g.Expect(secret.OwnerReferences).To(HaveLen(1))
+		g.Expect(secret.OwnerReferences[0].UID).To(Equal(obj.UID))
+
+		raw, err := decompressCommandResult(secret.Data[kluctlv1.CommandResultKey])
+		g.Expect(err).To(Succeed())
+		g.Expect(raw).To(ContainSubstring("cm1"))
+	})
+
+	t.Run("old results are garbage-collected", func(t *testing.T) {
+		firstRef := obj.Status.LastDeployResult.ResultRef.Name
+
+		patch := client.MergeFrom(obj.DeepCopy())
+		metav1.SetMetaDataAnnotation(&obj.ObjectMeta, kluctlv1.KluctlDeployRequestAnnotation, time.Now().String())
+		g.Expect(k8sClient.Patch(context.TODO(), &obj, patch)).To(Succeed())
+
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			lr := obj.Status.LastDeployResult
+			return lr != nil && lr.ResultRef != nil && lr.ResultRef.Name != firstRef
+		}, timeout, time.Second).Should(BeTrue())
+
+		g.Eventually(func() int {
+			return len(listResults())
+		}, timeout, time.Second).Should(Equal(1))
+		g.Expect(listResults()[0].Name).To(Equal(obj.Status.LastDeployResult.ResultRef.Name))
+	})
+}
+
+func TestKluctlDeploymentReconciler_Approval(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-approval-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-approval-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+			},
+			Approval: kluctlv1.ApprovalManual,
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	var obj kluctlv1.KluctlDeployment
+	t.Run("deployment waits for approval", func(t *testing.T) {
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			return obj.Status.PendingApproval != nil
+		}, timeout, time.Second).Should(BeTrue())
+
+		g.Expect(obj.Status.LastDeployResult).To(BeNil())
+		g.Expect(apimeta.IsStatusConditionTrue(obj.Status.Conditions, kluctlv1.ApprovalPendingCondition)).To(BeTrue())
+		ready := apimeta.FindStatusCondition(obj.Status.Conditions, meta.ReadyCondition)
+		g.Expect(ready).ToNot(BeNil())
+		g.Expect(ready.Reason).To(Equal(kluctlv1.AwaitingApprovalReason))
+		g.Expect(*obj.Status.PendingApproval.RawChanges).To(ContainSubstring("cm1"))
+
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: "cm1", Namespace: namespace}, cm)
+		g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	t.Run("wrong hash does not deploy", func(t *testing.T) {
+		patch := client.MergeFrom(obj.DeepCopy())
+		metav1.SetMetaDataAnnotation(&obj.ObjectMeta, kluctlv1.KluctlApprovedHashAnnotation, "invalid")
+		g.Expect(k8sClient.Patch(context.TODO(), &obj, patch)).To(Succeed())
+
+		g.Consistently(func() bool {
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			return obj.Status.LastDeployResult == nil
+		}, 5*time.Second, time.Second).Should(BeTrue())
+	})
+
+	t.Run("approved change set gets deployed", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)).To(Succeed())
+		patch := client.MergeFrom(obj.DeepCopy())
+		metav1.SetMetaDataAnnotation(&obj.ObjectMeta, kluctlv1.KluctlApprovedHashAnnotation, obj.Status.PendingApproval.Hash)
+		g.Expect(k8sClient.Patch(context.TODO(), &obj, patch)).To(Succeed())
+
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(kluctlDeployment), &obj)
+			return obj.Status.LastDeployResult != nil
+		}, timeout, time.Second).Should(BeTrue())
+
+		g.Expect(obj.Status.PendingApproval).To(BeNil())
+		g.Expect(apimeta.FindStatusCondition(obj.Status.Conditions, kluctlv1.ApprovalPendingCondition)).To(BeNil())
+
+		cm := &corev1.ConfigMap{}
+		g.Expect(k8sClient.Get(context.TODO(), client.ObjectKey{Name: "cm1", Namespace: namespace}, cm)).To(Succeed())
+	})
+}
+
+func TestKluctlDeploymentReconciler_ArgsFrom(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-args-from-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+  namespace: "{{ args.namespace }}"
+data:
+  k1: "{{ args.k1 }}"
+  k2: "{{ args.secret.password }}"
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	argsCm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "args",
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			"args.yaml": "k1: v1\n",
+		},
+	}
+	argsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "args",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"password": []byte("secret"),
+		},
+	}
+	g.Expect(k8sClient.Create(context.TODO(), argsCm)).To(Succeed())
+	g.Expect(k8sClient.Create(context.TODO(), argsSecret)).To(Succeed())
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-args-from-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Target:   utils.StrPtr("target1"),
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			ArgsFrom: []kluctlv1.ArgsReference{
+				{Kind: "ConfigMap", Name: "args"},
+				{Kind: "Secret", Name: "args", Key: "password", TargetPath: "secret.password"},
+				{Kind: "ConfigMap", Name: "missing", Optional: true},
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	getCm1 := func() *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: "cm1", Namespace: namespace}, cm)
+		if err != nil {
+			return nil
+		}
+		return cm
+	}
+
+	t.Run("args are loaded from ConfigMap and Secret", func(t *testing.T) {
+		g.Eventually(func() *corev1.ConfigMap {
+			return getCm1()
+		}, timeout, time.Second).ShouldNot(BeNil())
+		cm := getCm1()
+		g.Expect(cm.Data).To(HaveKeyWithValue("k1", "v1"))
+		g.Expect(cm.Data).To(HaveKeyWithValue("k2", "secret"))
+	})
+
+	t.Run("changing the ConfigMap triggers a deployment", func(t *testing.T) {
+		patch := client.MergeFrom(argsCm.DeepCopy())
+		argsCm.Data["args.yaml"] = "k1: v2\n"
+		g.Expect(k8sClient.Patch(context.TODO(), argsCm, patch)).To(Succeed())
+
+		g.Eventually(func() string {
+			cm := getCm1()
+			if cm == nil {
+				return ""
+			}
+			return cm.Data["k1"]
+		}, timeout, time.Second).Should(Equal("v2"))
+	})
+}
+
+func TestKluctlDeploymentReconciler_Targets(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-targets-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+	p.UpdateTarget("target2", nil)
+	p.UpdateTarget("other", nil)
+
+	p.AddKustomizeDeployment("d1", []test_utils.KustomizeResource{
+		{Name: "cm1.yaml", Content: uo.FromStringMust(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: "cm-{{ target.name }}"
+  namespace: "{{ args.namespace }}"
+data:
+  k1: v1
+`)},
+	}, nil)
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	kluctlDeployment := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kluctl-targets-" + randStringRunes(5),
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Timeout:  &metav1.Duration{Duration: timeout},
+			Targets: &kluctlv1.TargetSelector{
+				NamePattern: "target.*",
+			},
+			Args: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"namespace": "%s"}`, namespace)),
+			},
+			Source: &kluctlv1.ProjectSource{
+				URL: p.GitUrl(),
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), kluctlDeployment)).To(Succeed())
+
+	kluctlDeploymentKey := client.ObjectKeyFromObject(kluctlDeployment)
+	getCm := func(name string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		err := k8sClient.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: namespace}, cm)
+		if err != nil {
+			return nil
+		}
+		return cm
+	}
+
+	t.Run("all selected targets are deployed", func(t *testing.T) {
+		g.Eventually(func() bool {
+			return getCm("cm-target1") != nil && getCm("cm-target2") != nil
+		}, timeout, time.Second).Should(BeTrue())
+		g.Expect(getCm("cm-other")).To(BeNil())
+
+		resultingDeployment := &kluctlv1.KluctlDeployment{}
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), kluctlDeploymentKey, resultingDeployment)
+			return apimeta.IsStatusConditionTrue(resultingDeployment.Status.Conditions, meta.ReadyCondition)
+		}, timeout, time.Second).Should(BeTrue())
+
+		g.Expect(resultingDeployment.Status.LastDeployResult).To(BeNil())
+		g.Expect(resultingDeployment.Status.Targets).To(HaveLen(2))
+		for i, name := range []string{"target1", "target2"} {
+			ts := resultingDeployment.Status.Targets[i]
+			g.Expect(ts.Name).To(Equal(name))
+			g.Expect(ts.LastDeployResult).ToNot(BeNil())
+			g.Expect(apimeta.IsStatusConditionTrue(ts.Conditions, meta.ReadyCondition)).To(BeTrue())
+			g.Expect(apimeta.IsStatusConditionTrue(ts.Conditions, kluctlv1.DeployedCondition)).To(BeTrue())
+		}
+
+		var historyTargets []string
+		for _, h := range resultingDeployment.Status.History {
+			historyTargets = append(historyTargets, h.Target)
+		}
+		g.Expect(historyTargets).To(ContainElements("target1", "target2"))
+	})
+
+	t.Run("deselected targets are kept while prune is disabled", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.TODO(), kluctlDeploymentKey, kluctlDeployment)).To(Succeed())
+		patch := client.MergeFrom(kluctlDeployment.DeepCopy())
+		kluctlDeployment.Spec.Targets = &kluctlv1.TargetSelector{
+			Names: []string{"target1"},
+		}
+		g.Expect(k8sClient.Patch(context.TODO(), kluctlDeployment, patch)).To(Succeed())
+
+		resultingDeployment := &kluctlv1.KluctlDeployment{}
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), kluctlDeploymentKey, resultingDeployment)
+			ts := resultingDeployment.Status.GetTargetStatus("target2")
+			return ts != nil && ts.Deselected
+		}, timeout, time.Second).Should(BeTrue())
+		g.Expect(resultingDeployment.Status.Targets).To(HaveLen(2))
+		g.Expect(resultingDeployment.Status.GetTargetStatus("target2").Discriminator).ToNot(BeEmpty())
+		g.Expect(resultingDeployment.Status.GetTargetStatus("target1").Deselected).To(BeFalse())
+		g.Expect(getCm("cm-target2")).ToNot(BeNil())
+	})
+
+	t.Run("deselected targets are pruned", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.TODO(), kluctlDeploymentKey, kluctlDeployment)).To(Succeed())
+		patch := client.MergeFrom(kluctlDeployment.DeepCopy())
+		kluctlDeployment.Spec.Prune = true
+		g.Expect(k8sClient.Patch(context.TODO(), kluctlDeployment, patch)).To(Succeed())
+
+		g.Eventually(func() bool {
+			return getCm("cm-target2") == nil
+		}, timeout, time.Second).Should(BeTrue())
+		g.Expect(getCm("cm-target1")).ToNot(BeNil())
+
+		resultingDeployment := &kluctlv1.KluctlDeployment{}
+		g.Eventually(func() bool {
+			_ = k8sClient.Get(context.Background(), kluctlDeploymentKey, resultingDeployment)
+			return resultingDeployment.Status.GetTargetStatus("target2") == nil
+		}, timeout, time.Second).Should(BeTrue())
+		g.Expect(resultingDeployment.Status.Targets).To(HaveLen(1))
+	})
+
+	t.Run("unknown target names stall the deployment", func(t *testing.T) {
+		g.Expect(k8sClient.Get(context.TODO(), kluctlDeploymentKey, kluctlDeployment)).To(Succeed())
+		patch := client.MergeFrom(kluctlDeployment.DeepCopy())
+		kluctlDeployment.Spec.Targets = &kluctlv1.TargetSelector{
+			Names: []string{"target1", "missing"},
+		}
+		g.Expect(k8sClient.Patch(context.TODO(), kluctlDeployment, patch)).To(Succeed())
+
+		g.Eventually(func() bool {
+			resultingDeployment := &kluctlv1.KluctlDeployment{}
+			_ = k8sClient.Get(context.Background(), kluctlDeploymentKey, resultingDeployment)
+			return apimeta.IsStatusConditionTrue(resultingDeployment.Status.Conditions, meta.StalledCondition)
+		}, timeout, time.Second).Should(BeTrue())
+	})
+}
@@ -10,6 +10,9 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// metricsSubsystem is the subsystem of all metrics exported by the KluctlDeployment controller
+const metricsSubsystem = "kluctldeployment_controller"
+
 func (r *KluctlDeploymentReconciler) event(ctx context.Context, obj *kluctlv1.KluctlDeployment, revision string, warning bool, msg string, metadata map[string]string) {
 	if metadata == nil {
 		metadata = map[string]string{}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/predicates"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	types2 "github.com/kluctl/kluctl/v2/pkg/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kuberecorder "k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sort"
+)
+
+// KluctlDeploymentGeneratorReconciler generates KluctlDeployments for the branches and pull requests of a Git
+// repository.
+type KluctlDeploymentGeneratorReconciler struct {
+	client.Client
+	Scheme         *runtime.Scheme
+	EventRecorder  kuberecorder.EventRecorder
+	ControllerName string
+	statusManager  string
+
+	RepoCache *RepoCache
+}
+
+// +kubebuilder:rbac:groups=flux.kluctl.io,resources=kluctldeploymentgenerators,verbs=get;list;watch
+// +kubebuilder:rbac:groups=flux.kluctl.io,resources=kluctldeploymentgenerators/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=flux.kluctl.io,resources=kluctldeploymentgenerators/finalizers,verbs=update
+
+func (r *KluctlDeploymentGeneratorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	obj := &kluctlv1.KluctlDeploymentGenerator{}
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// generated KluctlDeployments are deleted by the garbage collector via their owner references
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if obj.Spec.Suspend {
+		log.Info("Reconciliation is suspended for this object")
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopy())
+
+	if v, ok := meta.ReconcileAnnotationValue(obj.GetAnnotations()); ok {
+		obj.Status.SetLastHandledReconcileRequest(v)
+	}
+
+	reconcileErr := r.doReconcile(ctx, obj)
+	obj.Status.ObservedGeneration = obj.GetGeneration()
+
+	requeueAfter := obj.Spec.Interval.Duration
+	if reconcileErr != nil {
+		requeueAfter = obj.Spec.GetRetryInterval()
+		apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+			Type:    meta.ReadyCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  kluctlv1.GenerateFailedReason,
+			Message: trimString(reconcileErr.Error(), kluctlv1.MaxConditionMessageLength),
+		})
+		log.Error(reconcileErr, "Generating KluctlDeployments failed")
+		r.EventRecorder.Event(obj, "Warning", kluctlv1.GenerateFailedReason, reconcileErr.Error())
+	} else {
+		apimeta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+			Type:    meta.ReadyCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  kluctlv1.GenerateSucceededReason,
+			Message: fmt.Sprintf("Generated %d KluctlDeployments", len(obj.Status.GeneratedDeployments)),
+		})
+	}
+
+	if err := r.Status().Patch(ctx, obj, patch, client.FieldOwner(r.statusManager)); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+func (r *KluctlDeploymentGeneratorReconciler) doReconcile(ctx context.Context, obj *kluctlv1.KluctlDeploymentGenerator) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	remoteRefs, err := r.listRemoteRefs(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	items, err := selectGeneratorItems(obj.Spec, remoteRefs)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	var generated []kluctlv1.GeneratedDeployment
+	for _, item := range items {
+		kd, err := renderGeneratedDeployment(obj, item)
+		if err != nil {
+			return err
+		}
+		if desired[kd.Name] {
+			return fmt.Errorf("multiple branches result in the same KluctlDeployment name %s", kd.Name)
+		}
+		desired[kd.Name] = true
+
+		err = r.applyGeneratedDeployment(ctx, obj, kd)
+		if err != nil {
+			return err
+		}
+		generated = append(generated, kluctlv1.GeneratedDeployment{
+			Name:        kd.Name,
+			Branch:      item.branch,
+			PullRequest: item.pullRequest,
+			Commit:      item.commit,
+		})
+	}
+
+	// delete generated KluctlDeployments for branches that disappeared. Their finalizers will take care of deleting
+	// the deployed objects if spec.delete is set
+	var list kluctlv1.KluctlDeploymentList
+	err = r.List(ctx, &list, client.InNamespace(obj.Namespace), client.MatchingLabels{
+		kluctlv1.KluctlDeploymentGeneratorLabel: obj.Name,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		kd := &list.Items[i]
+		if desired[kd.Name] || !metav1.IsControlledBy(kd, obj) {
+			continue
+		}
+		log.Info("Deleting generated KluctlDeployment", "name", kd.Name)
+		err = r.Delete(ctx, kd)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.EventRecorder.Eventf(obj, "Normal", "Deleted", "Deleted KluctlDeployment %s", kd.Name)
+	}
+
+	sort.Slice(generated, func(i, j int) bool {
+		return generated[i].Name < generated[j].Name
+	})
+	obj.Status.GeneratedDeployments = generated
+	return nil
+}
+
+func (r *KluctlDeploymentGeneratorReconciler) listRemoteRefs(ctx context.Context, obj *kluctlv1.KluctlDeploymentGenerator) (map[string]string, error) {
+	gitSecret, err := getGitSecret(ctx, r.Client, obj.Spec.Source.SecretRef, obj.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+
+	rp, _, release, err := r.RepoCache.Get(ctx, obj.Spec.Source.URL, gitSecret, "")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	gitUrl, err := types2.ParseGitUrl(obj.Spec.Source.URL)
+	if err != nil {
+		return nil, err
+	}
+	rpEntry, err := rp.GetEntry(*gitUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+	return rpEntry.GetRepoInfo().RemoteRefs, nil
+}
+
+// applyGeneratedDeployment creates or updates the given generated KluctlDeployment. Labels and annotations are
+// merged into the existing ones, so that annotations like deploy.flux.kluctl.io/requestedAt can still be set on
+// generated KluctlDeployments.
+func (r *KluctlDeploymentGeneratorReconciler) applyGeneratedDeployment(ctx context.Context, obj *kluctlv1.KluctlDeploymentGenerator, kd *kluctlv1.KluctlDeployment) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	existing := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kd.Name,
+			Namespace: kd.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, existing, func() error {
+		if !existing.CreationTimestamp.IsZero() && !metav1.IsControlledBy(existing, obj) {
+			return fmt.Errorf("KluctlDeployment %s already exists and is not owned by this generator", existing.Name)
+		}
+		if existing.Labels == nil {
+			existing.Labels = map[string]string{}
+		}
+		for k, v := range kd.Labels {
+			existing.Labels[k] = v
+		}
+		if len(kd.Annotations) != 0 && existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		for k, v := range kd.Annotations {
+			existing.Annotations[k] = v
+		}
+		existing.Spec = kd.Spec
+		return controllerutil.SetControllerReference(obj, existing, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info(fmt.Sprintf("KluctlDeployment %s %s", kd.Name, op))
+	}
+	if op == controllerutil.OperationResultCreated {
+		r.EventRecorder.Eventf(obj, "Normal", "Created", "Created KluctlDeployment %s", kd.Name)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KluctlDeploymentGeneratorReconciler) SetupWithManager(mgr ctrl.Manager, opts KluctlDeploymentReconcilerOpts) error {
+	r.statusManager = fmt.Sprintf("gotk-%s", r.ControllerName)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kluctlv1.KluctlDeploymentGenerator{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{}),
+		)).
+		Owns(&kluctlv1.KluctlDeployment{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
+		Complete(r)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/e2e/test-utils"
+	"github.com/kluctl/kluctl/v2/pkg/utils"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKluctlDeploymentGeneratorReconciler(t *testing.T) {
+	g := NewWithT(t)
+	namespace := "kluctl-generator-" + randStringRunes(5)
+
+	p := test_utils.NewTestProject(t)
+	p.UpdateTarget("target1", nil)
+
+	createBranch := func(name string) {
+		r := p.GetGitRepo()
+		h, err := r.Head()
+		g.Expect(err).ToNot(HaveOccurred())
+		err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), h.Hash()))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	deleteBranch := func(name string) {
+		err := p.GetGitRepo().Storer.RemoveReference(plumbing.NewBranchReferenceName(name))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	createBranch("preview/a")
+	createBranch("preview/b")
+	createBranch("other")
+
+	err := createNamespace(namespace)
+	g.Expect(err).NotTo(HaveOccurred(), "failed to create test namespace")
+
+	generator := &kluctlv1.KluctlDeploymentGenerator{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gen",
+			Namespace: namespace,
+		},
+		Spec: kluctlv1.KluctlDeploymentGeneratorSpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Source: kluctlv1.GeneratorSource{
+				URL: p.GitUrl(),
+			},
+			Branches: &kluctlv1.GeneratorBranchSelector{
+				Pattern: "preview/.*",
+			},
+			Template: kluctlv1.KluctlDeploymentTemplate{
+				Spec: kluctlv1.KluctlDeploymentSpec{
+					Interval:           metav1.Duration{Duration: reconciliationInterval},
+					Timeout:            &metav1.Duration{Duration: timeout},
+					Target:             utils.StrPtr("target1"),
+					TargetNameOverride: utils.StrPtr("preview-${slug}"),
+					Args: runtime.RawExtension{
+						Raw: []byte(fmt.Sprintf(`{"namespace": "%s", "branch": "${branch}"}`, namespace)),
+					},
+					Suspend: true,
+				},
+			},
+		},
+	}
+
+	g.Expect(k8sClient.Create(context.TODO(), generator)).To(Succeed())
+
+	listGenerated := func() []string {
+		var list kluctlv1.KluctlDeploymentList
+		err := k8sClient.List(context.TODO(), &list, client.InNamespace(namespace), client.MatchingLabels{
+			kluctlv1.KluctlDeploymentGeneratorLabel: generator.Name,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		var names []string
+		for _, kd := range list.Items {
+			if kd.DeletionTimestamp != nil {
+				continue
+			}
+			names = append(names, kd.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	t.Run("KluctlDeployments are generated for matching branches", func(t *testing.T) {
+		g.Eventually(listGenerated, timeout, time.Second).Should(Equal([]string{"gen-preview-a", "gen-preview-b"}))
+
+		var kd kluctlv1.KluctlDeployment
+		g.Expect(k8sClient.Get(context.TODO(), client.ObjectKey{Name: "gen-preview-a", Namespace: namespace}, &kd)).To(Succeed())
+		g.Expect(kd.Spec.Source.URL).To(Equal(p.GitUrl()))
+		g.Expect(kd.Spec.Source.Ref.Branch).To(Equal("preview/a"))
+		g.Expect(*kd.Spec.TargetNameOverride).To(Equal("preview-preview-a"))
+		g.Expect(string(kd.Spec.Args.Raw)).To(MatchJSON(fmt.Sprintf(`{"namespace": "%s", "branch": "preview/a"}`, namespace)))
+		g.Expect(metav1.IsControlledBy(&kd, generator)).To(BeTrue())
+	})
+
+	t.Run("KluctlDeployments are deleted when the branch disappears", func(t *testing.T) {
+		deleteBranch("preview/b")
+		createBranch("preview/c")
+
+		g.Eventually(listGenerated, timeout, time.Second).Should(Equal([]string{"gen-preview-a", "gen-preview-c"}))
+
+		var gen kluctlv1.KluctlDeploymentGenerator
+		g.Expect(k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(generator), &gen)).To(Succeed())
+		g.Expect(gen.Status.GeneratedDeployments).To(HaveLen(2))
+	})
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// generatorItem is a branch or pull request for which a KluctlDeployment is generated
+type generatorItem struct {
+	branch      string
+	pullRequest string
+	commit      string
+}
+
+var pullRequestRefRegex = regexp.MustCompile(`^refs/(?:pull|merge-requests)/([0-9]+)/head$`)
+
+// selectGeneratorItems selects the branches and pull requests that match the selectors of the given generator spec.
+// Pull requests are only selected if their head commit is also the head of a branch, which is then deployed.
+// Branches that are selected via a pull request are not selected a second time via the branch selector.
+func selectGeneratorItems(spec kluctlv1.KluctlDeploymentGeneratorSpec, remoteRefs map[string]string) ([]generatorItem, error) {
+	branches := map[string]string{}
+	var branchNames []string
+	for ref, hash := range remoteRefs {
+		if !strings.HasPrefix(ref, "refs/heads/") {
+			continue
+		}
+		name := strings.TrimPrefix(ref, "refs/heads/")
+		branches[name] = hash
+		branchNames = append(branchNames, name)
+	}
+	sort.Strings(branchNames)
+
+	var items []generatorItem
+	selectedBranches := map[string]bool{}
+
+	if spec.PullRequests != nil {
+		var re *regexp.Regexp
+		if spec.PullRequests.BranchPattern != "" {
+			var err error
+			re, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", spec.PullRequests.BranchPattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pull request branch pattern: %w", err)
+			}
+		}
+
+		var prRefs []string
+		for ref := range remoteRefs {
+			if pullRequestRefRegex.MatchString(ref) {
+				prRefs = append(prRefs, ref)
+			}
+		}
+		sort.Strings(prRefs)
+
+		for _, ref := range prRefs {
+			hash := remoteRefs[ref]
+			number := pullRequestRefRegex.FindStringSubmatch(ref)[1]
+			branch := ""
+			for _, b := range branchNames {
+				if branches[b] == hash && (re == nil || re.MatchString(b)) {
+					branch = b
+					break
+				}
+			}
+			if branch == "" || selectedBranches[branch] {
+				// pull requests from forks or for branches that are already selected
+				continue
+			}
+			selectedBranches[branch] = true
+			items = append(items, generatorItem{
+				branch:      branch,
+				pullRequest: number,
+				commit:      hash,
+			})
+		}
+	}
+
+	if spec.Branches != nil {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", spec.Branches.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch pattern: %w", err)
+		}
+		for _, b := range branchNames {
+			if selectedBranches[b] || !re.MatchString(b) {
+				continue
+			}
+			selectedBranches[b] = true
+			items = append(items, generatorItem{
+				branch: b,
+				commit: branches[b],
+			})
+		}
+	}
+
+	return items, nil
+}
+
+var slugInvalidCharsRegex = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// buildSlug converts the given string into a string that can be used as part of Kubernetes object names
+func buildSlug(s string, maxLen int) string {
+	slug := strings.ToLower(s)
+	slug = slugInvalidCharsRegex.ReplaceAllString(slug, "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > maxLen {
+		// keep it unique by appending a hash of the full string
+		h := sha256.Sum256([]byte(s))
+		suffix := hex.EncodeToString(h[:])[:8]
+		slug = strings.Trim(slug[:maxLen-len(suffix)-1], "-") + "-" + suffix
+	}
+	return slug
+}
+
+func (i generatorItem) templateVars() map[string]string {
+	shortCommit := i.commit
+	if len(shortCommit) > 7 {
+		shortCommit = shortCommit[:7]
+	}
+	return map[string]string{
+		"branch":      i.branch,
+		"slug":        buildSlug(i.branch, 40),
+		"commit":      i.commit,
+		"shortCommit": shortCommit,
+		"pullRequest": i.pullRequest,
+	}
+}
+
+// renderGeneratedDeployment renders the template of the given generator for the given item
+func renderGeneratedDeployment(obj *kluctlv1.KluctlDeploymentGenerator, item generatorItem) (*kluctlv1.KluctlDeployment, error) {
+	vars := item.templateVars()
+
+	var template kluctlv1.KluctlDeploymentTemplate
+	err := replaceTemplateVars(&obj.Spec.Template, &template, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+
+	name := buildSlug(fmt.Sprintf("%s-%s", obj.Name, vars["slug"]), 63)
+
+	kd := &kluctlv1.KluctlDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   obj.Namespace,
+			Labels:      template.Metadata.Labels,
+			Annotations: template.Metadata.Annotations,
+		},
+		Spec: template.Spec,
+	}
+	if kd.Labels == nil {
+		kd.Labels = map[string]string{}
+	}
+	kd.Labels[kluctlv1.KluctlDeploymentGeneratorLabel] = obj.Name
+
+	source := &kluctlv1.ProjectSource{}
+	if kd.Spec.Source != nil {
+		source.Path = kd.Spec.Source.Path
+	}
+	source.URL = obj.Spec.Source.URL
+	source.SecretRef = obj.Spec.Source.SecretRef
+	source.Ref = &kluctlv1.GitRef{
+		Branch: item.branch,
+	}
+	kd.Spec.Source = source
+	kd.Spec.SourceRef = nil
+
+	return kd, nil
+}
+
+// replaceTemplateVars replaces ${name} placeholders in all string values of in and stores the result in out
+func replaceTemplateVars(in any, out any, vars map[string]string) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var m any
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return err
+	}
+
+	var oldnew []string
+	for k, v := range vars {
+		oldnew = append(oldnew, fmt.Sprintf("${%s}", k), v)
+	}
+	replacer := strings.NewReplacer(oldnew...)
+
+	var walk func(o any) any
+	walk = func(o any) any {
+		switch x := o.(type) {
+		case map[string]any:
+			for k, v := range x {
+				x[k] = walk(v)
+			}
+			return x
+		case []any:
+			for i, v := range x {
+				x[i] = walk(v)
+			}
+			return x
+		case string:
+			return replacer.Replace(x)
+		default:
+			return o
+		}
+	}
+	m = walk(m)
+
+	b, err = json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
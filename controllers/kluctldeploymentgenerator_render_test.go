@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	"github.com/kluctl/kluctl/v2/pkg/utils"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectGeneratorItems(t *testing.T) {
+	g := NewWithT(t)
+
+	remoteRefs := map[string]string{
+		"HEAD":                         "h-main",
+		"refs/heads/main":              "h-main",
+		"refs/heads/preview/a":         "h-a",
+		"refs/heads/preview/b":         "h-b",
+		"refs/heads/feature-x":         "h-x",
+		"refs/tags/v1.0.0":             "h-main",
+		"refs/pull/1/head":             "h-x",
+		"refs/pull/2/head":             "h-fork",
+		"refs/pull/3/head":             "h-a",
+		"refs/merge-requests/4/head":   "h-b",
+		"refs/merge-requests/4/merge":  "h-merge",
+		"refs/pull/5/head/something":   "h-x",
+		"refs/heads/preview/c-unknown": "h-c",
+	}
+
+	items, err := selectGeneratorItems(kluctlv1.KluctlDeploymentGeneratorSpec{
+		Branches: &kluctlv1.GeneratorBranchSelector{Pattern: "preview/.*"},
+	}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(items).To(Equal([]generatorItem{
+		{branch: "preview/a", commit: "h-a"},
+		{branch: "preview/b", commit: "h-b"},
+		{branch: "preview/c-unknown", commit: "h-c"},
+	}))
+
+	items, err = selectGeneratorItems(kluctlv1.KluctlDeploymentGeneratorSpec{
+		PullRequests: &kluctlv1.GeneratorPullRequestSelector{},
+	}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(items).To(Equal([]generatorItem{
+		{branch: "preview/b", pullRequest: "4", commit: "h-b"},
+		{branch: "feature-x", pullRequest: "1", commit: "h-x"},
+		{branch: "preview/a", pullRequest: "3", commit: "h-a"},
+	}))
+
+	// branches of pull requests are not selected twice
+	items, err = selectGeneratorItems(kluctlv1.KluctlDeploymentGeneratorSpec{
+		Branches:     &kluctlv1.GeneratorBranchSelector{Pattern: "preview/.*"},
+		PullRequests: &kluctlv1.GeneratorPullRequestSelector{BranchPattern: "preview/.*"},
+	}, remoteRefs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(items).To(Equal([]generatorItem{
+		{branch: "preview/b", pullRequest: "4", commit: "h-b"},
+		{branch: "preview/a", pullRequest: "3", commit: "h-a"},
+		{branch: "preview/c-unknown", commit: "h-c"},
+	}))
+
+	_, err = selectGeneratorItems(kluctlv1.KluctlDeploymentGeneratorSpec{
+		Branches: &kluctlv1.GeneratorBranchSelector{Pattern: "("},
+	}, remoteRefs)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestBuildSlug(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(buildSlug("feature/My_Branch", 40)).To(Equal("feature-my-branch"))
+	g.Expect(buildSlug("--a--", 40)).To(Equal("a"))
+
+	long := strings.Repeat("a", 50)
+	s1 := buildSlug(long, 40)
+	s2 := buildSlug(long+"b", 40)
+	g.Expect(len(s1)).To(BeNumerically("<=", 40))
+	g.Expect(s1).ToNot(Equal(s2))
+}
+
+func TestRenderGeneratedDeployment(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &kluctlv1.KluctlDeploymentGenerator{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gen",
+			Namespace: "ns",
+		},
+		Spec: kluctlv1.KluctlDeploymentGeneratorSpec{
+			Source: kluctlv1.GeneratorSource{
+				URL: "https://example.com/repo.git",
+			},
+			Template: kluctlv1.KluctlDeploymentTemplate{
+				Metadata: kluctlv1.GeneratorTemplateMetadata{
+					Labels: map[string]string{"branch": "${slug}"},
+				},
+				Spec: kluctlv1.KluctlDeploymentSpec{
+					Interval: metav1.Duration{Duration: time.Minute},
+					Source: &kluctlv1.ProjectSource{
+						URL:  "https://ignored.com/repo.git",
+						Path: "sub/dir",
+					},
+					TargetNameOverride: utils.StrPtr("preview-${slug}"),
+					Args: runtime.RawExtension{
+						Raw: []byte(`{"branch":"${branch}","pr":"${pullRequest}","list":["${shortCommit}"]}`),
+					},
+				},
+			},
+		},
+	}
+
+	kd, err := renderGeneratedDeployment(obj, generatorItem{
+		branch:      "feature/x",
+		pullRequest: "12",
+		commit:      "0123456789abcdef",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(kd.Name).To(Equal("gen-feature-x"))
+	g.Expect(kd.Namespace).To(Equal("ns"))
+	g.Expect(kd.Labels).To(Equal(map[string]string{
+		"branch":                                "feature-x",
+		kluctlv1.KluctlDeploymentGeneratorLabel: "gen",
+	}))
+	g.Expect(*kd.Spec.TargetNameOverride).To(Equal("preview-feature-x"))
+	g.Expect(string(kd.Spec.Args.Raw)).To(MatchJSON(`{"branch":"feature/x","pr":"12","list":["0123456"]}`))
+	g.Expect(kd.Spec.Source).To(Equal(&kluctlv1.ProjectSource{
+		URL:  "https://example.com/repo.git",
+		Path: "sub/dir",
+		Ref:  &kluctlv1.GitRef{Branch: "feature/x"},
+	}))
+
+	// the template itself must not be modified
+	g.Expect(*obj.Spec.Template.Spec.TargetNameOverride).To(Equal("preview-${slug}"))
+}
@@ -39,3 +39,37 @@ func (DeployRequestedPredicate) Update(e event.UpdateEvent) bool {
 	}
 	return false
 }
+
+type ApprovedHashChangedPredicate struct {
+	predicate.Funcs
+}
+
+func (ApprovedHashChangedPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	val, ok := e.ObjectNew.GetAnnotations()[kluctlv1.KluctlApprovedHashAnnotation]
+	if !ok {
+		return false
+	}
+	valOld := e.ObjectOld.GetAnnotations()[kluctlv1.KluctlApprovedHashAnnotation]
+	return val != valOld
+}
+
+type RestoreBackupRequestedPredicate struct {
+	predicate.Funcs
+}
+
+func (RestoreBackupRequestedPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+
+	val, ok := e.ObjectNew.GetAnnotations()[kluctlv1.KluctlRestoreBackupAnnotation]
+	if !ok {
+		return false
+	}
+	valOld := e.ObjectOld.GetAnnotations()[kluctlv1.KluctlRestoreBackupAnnotation]
+	return val != valOld
+}
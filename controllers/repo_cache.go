@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	ssh_pool "github.com/kluctl/kluctl/v2/pkg/git/ssh-pool"
+	"github.com/kluctl/kluctl/v2/pkg/repocache"
+	"github.com/kluctl/kluctl/v2/pkg/status"
+	types2 "github.com/kluctl/kluctl/v2/pkg/types"
+	"github.com/kluctl/kluctl/v2/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/fs"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+	"time"
+)
+
+// sharedRepoCacheMaxAge is the maximum age of a GitRepoCache that is shared with concurrent reconciliations. Older
+// ones are not shared anymore, as their fetched refs might be outdated.
+const sharedRepoCacheMaxAge = 10 * time.Second
+
+var (
+	repoCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "repo_cache_hits_total",
+		Help:      "Number of times an existing git repo cache directory was reused.",
+	})
+	repoCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "repo_cache_misses_total",
+		Help:      "Number of times a new git repo cache directory had to be created.",
+	})
+	repoCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "repo_cache_evictions_total",
+		Help:      "Number of git repo cache directories that were evicted.",
+	})
+	repoCacheShared = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "repo_cache_shared_total",
+		Help:      "Number of times a reconciliation shared the fetch and checkouts of a concurrent reconciliation.",
+	})
+	repoCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: metricsSubsystem,
+		Name:      "repo_cache_size_bytes",
+		Help:      "Disk usage of the git repo cache in bytes.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(repoCacheHits, repoCacheMisses, repoCacheEvictions, repoCacheShared, repoCacheSize)
+}
+
+// RepoCache manages the git mirrors used by all reconciliations. Mirrors are stored in a dedicated directory per
+// repository url and set of credentials, which survives reconciliations and controller restarts (e.g. when dir is
+// on a persistent volume). When the total size exceeds maxSize, the least recently used directories that are
+// currently not in use are deleted.
+//
+// Concurrent reconciliations that use the same repository url and credentials share a single GitRepoCache, so that
+// the repository is only fetched and checked out once. They also share a work directory, which is used as the
+// temporary base directory of Kluctl, so that Helm charts that are pulled while rendering are only pulled once.
+// Shared instances are bound to the lifetime of the controller instead of the reconciliation that created them, as
+// they outlive it when other reconciliations joined.
+type RepoCache struct {
+	ctx     context.Context
+	dir     string
+	maxSize int64
+	sshPool *ssh_pool.SshPool
+
+	mutex   sync.Mutex
+	entries map[string]*repoCacheDir
+}
+
+type repoCacheDir struct {
+	key      string
+	dir      string
+	lastUsed time.Time
+	users    int
+	size     int64
+
+	// shared contains the GitRepoCache instances that can be joined by concurrent reconciliations, keyed by variant
+	shared map[string]*sharedRepoCache
+}
+
+type sharedRepoCache struct {
+	rp      *repocache.GitRepoCache
+	workDir string
+	cancel  context.CancelFunc
+	users   int
+	created time.Time
+
+	// deadline is the time when the context of rp expires. It is zero if the context has no deadline
+	deadline time.Time
+}
+
+// NewRepoCache creates a RepoCache in the given directory. Existing cache directories are picked up, so that
+// mirrors survive restarts. A maxSize of 0 disables eviction. ctx must live as long as the controller, as all
+// GitRepoCache instances are derived from it.
+func NewRepoCache(ctx context.Context, dir string, maxSize int64, sshPool *ssh_pool.SshPool) (*RepoCache, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &RepoCache{
+		ctx:     ctx,
+		dir:     dir,
+		maxSize: maxSize,
+		sshPool: sshPool,
+		entries: map[string]*repoCacheDir{},
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range des {
+		if !de.IsDir() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		e := &repoCacheDir{
+			key:      de.Name(),
+			dir:      filepath.Join(dir, de.Name()),
+			lastUsed: info.ModTime(),
+			shared:   map[string]*sharedRepoCache{},
+		}
+		e.size = calcDirSize(e.dir)
+		c.entries[e.key] = e
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gc()
+
+	return c, nil
+}
+
+// Get returns a GitRepoCache that stores its mirrors in the cache directory for the given url and credentials. If a
+// concurrent reconciliation uses the same url, credentials and variant, its GitRepoCache is shared so that fetches
+// and checkouts are only performed once. The variant must differ when checkouts are modified, e.g. when checking
+// out a specific commit.
+//
+// The deadline of ctx is also applied to the GitRepoCache. A shared GitRepoCache is only joined if its deadline does
+// not expire before the deadline of ctx.
+//
+// The returned work directory is shared the same way and must be passed to Kluctl via utils.WithTmpBaseDir, so that
+// Helm charts that are pulled on-demand while loading and rendering the project are shared as well.
+//
+// The returned release function must be called when the GitRepoCache is not used anymore. When the last user
+// releases a GitRepoCache, all its checkouts and the work directory are removed while the mirrors are kept.
+func (c *RepoCache) Get(ctx context.Context, url string, secret *corev1.Secret, variant string) (*repocache.GitRepoCache, string, func(), error) {
+	key, err := buildRepoCacheKey(url, secret)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ga, err := buildGitAuth(ctx, secret)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// messages of shared fetches must not be logged for a single KluctlDeployment
+	log := ctrl.Log.WithName("repo-cache").WithValues("url", url)
+
+	deadline, _ := ctx.Deadline()
+	e, s, err := c.acquire(key, variant, deadline, func(rpCtx context.Context, dir string) *repocache.GitRepoCache {
+		rpCtx = ctrl.LoggerInto(rpCtx, log)
+		rpCtx = status.NewContext(rpCtx, status.NewSimpleStatusHandler(func(message string) {
+			log.Info(message)
+		}, false, false))
+		return repocache.NewGitRepoCache(utils.WithTmpBaseDir(rpCtx, dir), c.sshPool, ga, nil, 0)
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	release := func() {
+		c.release(e, variant, s)
+	}
+	return s.rp, s.workDir, release, nil
+}
+
+// GetPrivate returns a GitRepoCache that is not shared with other reconciliations and stores its clones in dir. It
+// is used when there is no Git URL to share the cache on, e.g. for Bucket and OCI sources, which can still reference
+// Git repositories via includes. The caller owns dir and the returned release function only clears the GitRepoCache.
+func (c *RepoCache) GetPrivate(ctx context.Context, secret *corev1.Secret, dir string) (*repocache.GitRepoCache, func(), error) {
+	ga, err := buildGitAuth(ctx, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	rp := repocache.NewGitRepoCache(utils.WithTmpBaseDir(ctx, dir), c.sshPool, ga, nil, 0)
+	return rp, rp.Clear, nil
+}
+
+// buildRepoCacheKey builds a unique key for the given url and credentials
+func buildRepoCacheKey(url string, secret *corev1.Secret) (string, error) {
+	if gitUrl, err := types2.ParseGitUrl(url); err == nil {
+		url = gitUrl.NormalizedRepoKey()
+	}
+
+	h := sha256.New()
+	e := json.NewEncoder(h)
+	if err := e.Encode(url); err != nil {
+		return "", err
+	}
+	if secret == nil {
+		h.Write([]byte("no-secret"))
+	} else if err := e.Encode(secret.Data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *RepoCache) acquire(key string, variant string, deadline time.Time, newRp func(ctx context.Context, dir string) *repocache.GitRepoCache) (*repoCacheDir, *sharedRepoCache, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if ok {
+		repoCacheHits.Inc()
+	} else {
+		repoCacheMisses.Inc()
+		e = &repoCacheDir{
+			key:    key,
+			dir:    filepath.Join(c.dir, key),
+			shared: map[string]*sharedRepoCache{},
+		}
+		err := os.MkdirAll(e.dir, 0o700)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.entries[key] = e
+	}
+	s, ok := e.shared[variant]
+	if ok && time.Since(s.created) < sharedRepoCacheMaxAge && (s.deadline.IsZero() || (!deadline.IsZero() && !deadline.After(s.deadline))) {
+		repoCacheShared.Inc()
+	} else {
+		// the work directory is not stored inside the cache directory, as it must not survive restarts
+		workDir, err := os.MkdirTemp("", "kluctl-controller-work-")
+		if err != nil {
+			return nil, nil, err
+		}
+		s = &sharedRepoCache{
+			workDir: workDir,
+			created: time.Now(),
+		}
+		var rpCtx context.Context
+		if deadline.IsZero() {
+			rpCtx, s.cancel = context.WithCancel(c.ctx)
+		} else {
+			// leave room for reconciliations with the same timeout that join later
+			s.deadline = deadline.Add(sharedRepoCacheMaxAge)
+			rpCtx, s.cancel = context.WithDeadline(c.ctx, s.deadline)
+		}
+		s.rp = newRp(rpCtx, e.dir)
+		e.shared[variant] = s
+	}
+	s.users++
+	e.users++
+	e.lastUsed = time.Now()
+
+	return e, s, nil
+}
+
+func (c *RepoCache) release(e *repoCacheDir, variant string, s *sharedRepoCache) {
+	c.mutex.Lock()
+	s.users--
+	last := s.users == 0
+	if last && e.shared[variant] == s {
+		delete(e.shared, variant)
+	}
+	c.mutex.Unlock()
+
+	if last {
+		s.rp.Clear()
+		s.cancel()
+		_ = os.RemoveAll(s.workDir)
+	}
+
+	// calculate the size outside of the lock as it might take a while
+	size := calcDirSize(e.dir)
+	now := time.Now()
+	_ = os.Chtimes(e.dir, now, now)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e.users--
+	e.lastUsed = now
+	e.size = size
+	c.gc()
+}
+
+// gc evicts the least recently used directories that are not in use until the total size is below maxSize.
+// Must be called with the mutex being held.
+func (c *RepoCache) gc() {
+	var total int64
+	for _, e := range c.entries {
+		total += e.size
+	}
+
+	for c.maxSize > 0 && total > c.maxSize {
+		var oldest *repoCacheDir
+		for _, e := range c.entries {
+			if e.users != 0 {
+				continue
+			}
+			if oldest == nil || e.lastUsed.Before(oldest.lastUsed) {
+				oldest = e
+			}
+		}
+		if oldest == nil {
+			// everything is in use
+			break
+		}
+
+		err := os.RemoveAll(oldest.dir)
+		if err != nil {
+			ctrl.Log.Error(err, "failed to evict repo cache directory", "dir", oldest.dir)
+			break
+		}
+		delete(c.entries, oldest.key)
+		total -= oldest.size
+		repoCacheEvictions.Inc()
+	}
+
+	repoCacheSize.Set(float64(total))
+}
+
+func calcDirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// files might disappear while we walk
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
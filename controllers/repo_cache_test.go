@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"github.com/kluctl/kluctl/v2/pkg/repocache"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepoCacheKey(t *testing.T) {
+	g := NewWithT(t)
+
+	k1, err := buildRepoCacheKey("https://example.com/repo1.git", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	k2, err := buildRepoCacheKey("https://example.com/repo1.git", &corev1.Secret{Data: map[string][]byte{"password": []byte("a")}})
+	g.Expect(err).ToNot(HaveOccurred())
+	k3, err := buildRepoCacheKey("https://example.com/repo1.git", &corev1.Secret{Data: map[string][]byte{"password": []byte("b")}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(k1).ToNot(Equal(k2))
+	g.Expect(k2).ToNot(Equal(k3))
+
+	k4, err := buildRepoCacheKey("https://example.com/repo1.git", &corev1.Secret{Data: map[string][]byte{"password": []byte("b")}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(k4).To(Equal(k3))
+}
+
+type testRepoCacheUse struct {
+	e *repoCacheDir
+	s *sharedRepoCache
+}
+
+func newTestRp(ctx context.Context, dir string) *repocache.GitRepoCache {
+	return &repocache.GitRepoCache{}
+}
+
+func TestRepoCacheEviction(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+
+	c, err := NewRepoCache(context.Background(), dir, 250, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	use := func(key string, size int) *testRepoCacheUse {
+		e, s, err := c.acquire(key, "", time.Time{}, newTestRp)
+		g.Expect(err).ToNot(HaveOccurred())
+		err = os.WriteFile(filepath.Join(e.dir, "data"), make([]byte, size), 0o600)
+		g.Expect(err).ToNot(HaveOccurred())
+		return &testRepoCacheUse{e: e, s: s}
+	}
+	release := func(u *testRepoCacheUse) {
+		c.release(u.e, "", u.s)
+	}
+
+	e1 := use("k1", 100)
+	release(e1)
+	time.Sleep(10 * time.Millisecond)
+	e2 := use("k2", 100)
+	release(e2)
+	g.Expect(c.entries).To(HaveLen(2))
+
+	// k1 is still in use, so k2 is evicted
+	e1 = use("k1", 100)
+	e3 := use("k3", 100)
+	release(e3)
+	g.Expect(c.entries).To(HaveKey("k1"))
+	g.Expect(c.entries).ToNot(HaveKey("k2"))
+	g.Expect(c.entries).To(HaveKey("k3"))
+	g.Expect(filepath.Join(dir, "k2")).ToNot(BeADirectory())
+
+	// k1 is the least recently used one after releasing it
+	release(e1)
+	time.Sleep(10 * time.Millisecond)
+	e3 = use("k3", 100)
+	release(e3)
+	e4 := use("k4", 100)
+	release(e4)
+	g.Expect(c.entries).ToNot(HaveKey("k1"))
+	g.Expect(c.entries).To(HaveKey("k3"))
+	g.Expect(c.entries).To(HaveKey("k4"))
+
+	// existing directories are picked up
+	c2, err := NewRepoCache(context.Background(), dir, 0, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c2.entries).To(HaveLen(2))
+	g.Expect(c2.entries["k3"].size).To(BeEquivalentTo(100))
+}
+
+func TestRepoCacheSharing(t *testing.T) {
+	g := NewWithT(t)
+
+	c, err := NewRepoCache(context.Background(), t.TempDir(), 0, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	e1, s1, err := c.acquire("k1", "", time.Time{}, newTestRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	e2, s2, err := c.acquire("k1", "", time.Time{}, newTestRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, s3, err := c.acquire("k1", "commit", time.Time{}, newTestRp)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// concurrent users share the same GitRepoCache and work directory, unless the variant differs
+	g.Expect(s2).To(BeIdenticalTo(s1))
+	g.Expect(s3).ToNot(BeIdenticalTo(s1))
+	g.Expect(s1.users).To(Equal(2))
+	g.Expect(s1.workDir).To(BeADirectory())
+	g.Expect(s3.workDir).To(BeADirectory())
+	g.Expect(s3.workDir).ToNot(Equal(s1.workDir))
+
+	c.release(e1, "", s1)
+	g.Expect(e1.shared).To(HaveKey(""))
+	g.Expect(s1.workDir).To(BeADirectory())
+	c.release(e2, "", s2)
+	g.Expect(e1.shared).ToNot(HaveKey(""))
+	g.Expect(s1.workDir).ToNot(BeAnExistingFile())
+
+	// a new GitRepoCache is used when nobody uses the old one anymore
+	_, s4, err := c.acquire("k1", "", time.Time{}, newTestRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s4).ToNot(BeIdenticalTo(s1))
+
+	// old GitRepoCaches are not shared
+	s4.created = time.Now().Add(-sharedRepoCacheMaxAge)
+	_, s5, err := c.acquire("k1", "", time.Time{}, newTestRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s5).ToNot(BeIdenticalTo(s4))
+}
+
+func TestRepoCacheSharingDeadline(t *testing.T) {
+	g := NewWithT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := NewRepoCache(ctx, t.TempDir(), 0, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var rpCtx context.Context
+	newRp := func(ctx context.Context, dir string) *repocache.GitRepoCache {
+		rpCtx = ctx
+		return &repocache.GitRepoCache{}
+	}
+
+	now := time.Now()
+	e1, s1, err := c.acquire("k1", "", now.Add(time.Minute), newRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s1.deadline).To(Equal(now.Add(time.Minute + sharedRepoCacheMaxAge)))
+	ctx1 := rpCtx
+
+	// joining with a slightly later deadline is fine, while a much later or no deadline requires a new instance
+	e2, s2, err := c.acquire("k1", "", now.Add(time.Minute+time.Second), newRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s2).To(BeIdenticalTo(s1))
+	e3, s3, err := c.acquire("k1", "", now.Add(time.Hour), newRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s3).ToNot(BeIdenticalTo(s1))
+	_, s4, err := c.acquire("k1", "", time.Time{}, newRp)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s4).ToNot(BeIdenticalTo(s3))
+
+	// the context is only cancelled when the last user releases the instance
+	c.release(e1, "", s1)
+	g.Expect(ctx1.Err()).ToNot(HaveOccurred())
+	c.release(e2, "", s2)
+	g.Expect(ctx1.Err()).To(HaveOccurred())
+	c.release(e3, "", s3)
+
+	// instances are derived from the context of the RepoCache
+	cancel()
+	g.Expect(rpCtx.Err()).To(HaveOccurred())
+}
+
+func TestRepoCachePrivate(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+
+	c, err := NewRepoCache(context.Background(), dir, 0, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	rp, release, err := c.GetPrivate(context.Background(), nil, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(rp).ToNot(BeNil())
+	release()
+
+	// private caches must not create shared entries
+	g.Expect(c.entries).To(BeEmpty())
+	entries, err := os.ReadDir(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(entries).To(BeEmpty())
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	test_utils "github.com/kluctl/kluctl/v2/e2e/test-utils"
+	ssh_pool "github.com/kluctl/kluctl/v2/pkg/git/ssh-pool"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -70,7 +71,7 @@ var (
 	debugMode    = os.Getenv("DEBUG_TEST") != ""
 )
 
-func runInContext(registerControllers func(*testenv.Environment), run func() error, crdPath string) error {
+func runInContext(registerControllers func(*testenv.Environment), run func() error, crdPaths ...string) error {
 	var err error
 	utilruntime.Must(sourcev1.AddToScheme(scheme.Scheme))
 	utilruntime.Must(kluctliov1alpha1.AddToScheme(scheme.Scheme))
@@ -79,7 +80,7 @@ func runInContext(registerControllers func(*testenv.Environment), run func() err
 		controllerLog.SetLogger(zap.New(zap.WriteTo(os.Stderr), zap.UseDevMode(false)))
 	}
 
-	testEnv = testenv.New(testenv.WithCRDPath(crdPath))
+	testEnv = testenv.New(testenv.WithCRDPath(crdPaths...))
 
 	registerControllers(testEnv)
 
@@ -136,22 +137,45 @@ func TestMain(m *testing.M) {
 	runInContext(func(testEnv *testenv.Environment) {
 		controllerName := "flux-kluctl-controller"
 		testMetricsH = controller.MustMakeMetrics(testEnv)
+		repoCacheDir, err := os.MkdirTemp("", "kluctl-controller-repo-cache-")
+		if err != nil {
+			panic(fmt.Sprintf("Failed to create repo cache dir: %v", err))
+		}
+		repoCache, err := NewRepoCache(ctx, repoCacheDir, 0, &ssh_pool.SshPool{})
+		if err != nil {
+			panic(fmt.Sprintf("Failed to create repo cache: %v", err))
+		}
 		reconciler = &KluctlDeploymentReconciler{
 			ControllerName:  controllerName,
 			RestConfig:      testEnv.Config,
 			Client:          testEnv,
+			Scheme:          testEnv.GetScheme(),
 			EventRecorder:   testEnv.GetEventRecorderFor(controllerName),
 			MetricsRecorder: testMetricsH.MetricsRecorder,
+			RepoCache:       repoCache,
 		}
 		if err := (reconciler).SetupWithManager(testEnv, KluctlDeploymentReconcilerOpts{
-			MaxConcurrentReconciles: 4,
+			MaxConcurrentReconciles:   4,
+			DependencyRequeueInterval: 2 * time.Second,
 		}); err != nil {
 			panic(fmt.Sprintf("Failed to start KustomizationReconciler: %v", err))
 		}
+		generatorReconciler := &KluctlDeploymentGeneratorReconciler{
+			ControllerName: controllerName,
+			Client:         testEnv,
+			Scheme:         testEnv.GetScheme(),
+			EventRecorder:  testEnv.GetEventRecorderFor(controllerName),
+			RepoCache:      repoCache,
+		}
+		if err := generatorReconciler.SetupWithManager(testEnv, KluctlDeploymentReconcilerOpts{
+			MaxConcurrentReconciles: 4,
+		}); err != nil {
+			panic(fmt.Sprintf("Failed to start KluctlDeploymentGeneratorReconciler: %v", err))
+		}
 	}, func() error {
 		code = m.Run()
 		return nil
-	}, filepath.Join("..", "config", "crd", "bases"))
+	}, filepath.Join("..", "config", "crd", "bases"), filepath.Join("..", "build", "config", "crd", "bases"))
 
 	os.Exit(code)
 }
@@ -35,7 +35,7 @@ func MatchesTimeSpec(t time.Time, spec string) (bool, error) {
 		}
 
 		absolueMatch := absoluteTimeSpecPattern.FindStringSubmatch(spec_)
-		if recurringMatch != nil {
+		if absolueMatch != nil {
 			hit, err := matchedAbsoluteTimeSpec(t, absolueMatch)
 			if err != nil {
 				return false, err
@@ -52,7 +52,7 @@ func MatchesTimeSpec(t time.Time, spec string) (bool, error) {
 }
 
 func weekdayIndex(s string) int {
-	s = strings.ToLower(s)
+	s = strings.ToUpper(s)
 	for i, x := range weekdays {
 		if x == s {
 			return i
@@ -61,28 +61,32 @@ func weekdayIndex(s string) int {
 	return -1
 }
 
-func matchesRecurringTimeSpec(t time.Time, match []string) (bool, error) {
-	calcMinutes := func(i1, i2 int) (int, error) {
-		h, err := strconv.ParseInt(match[i1], 10, 32)
-		if err != nil {
-			return 0, err
-		}
-		m, err := strconv.ParseInt(match[i2], 10, 32)
-		if err != nil {
-			return 0, err
-		}
-		return int(h*60 + m), nil
+func calcTimeSpecMinutes(match []string, i1, i2 int) (int, error) {
+	h, err := strconv.ParseInt(match[i1], 10, 32)
+	if err != nil {
+		return 0, err
 	}
+	m, err := strconv.ParseInt(match[i2], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int(h*60 + m), nil
+}
 
-	localTime := t
+func timeSpecLocation(match []string) (*time.Location, error) {
 	tz := match[timeSpecPattern.SubexpIndex("tz")]
-	if tz != "" {
-		loc, err := time.LoadLocation(tz)
-		if err != nil {
-			return false, err
-		}
-		localTime = t.In(loc)
+	if tz == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+func matchesRecurringTimeSpec(t time.Time, match []string) (bool, error) {
+	loc, err := timeSpecLocation(match)
+	if err != nil {
+		return false, err
 	}
+	localTime := t.In(loc)
 
 	localWeekday := int(localTime.Weekday())
 	if localWeekday == 0 {
@@ -109,11 +113,11 @@ func matchesRecurringTimeSpec(t time.Time, match []string) (bool, error) {
 		dayMatches = dayFrom <= localWeekday && localWeekday <= dayTo
 	}
 	localTimeMinutes := localTime.Hour()*60 + localTime.Minute()
-	minuteFrom, err := calcMinutes(3, 4)
+	minuteFrom, err := calcTimeSpecMinutes(match, 3, 4)
 	if err != nil {
 		return false, err
 	}
-	minuteTo, err := calcMinutes(5, 6)
+	minuteTo, err := calcTimeSpecMinutes(match, 5, 6)
 	if err != nil {
 		return false, err
 	}
@@ -135,3 +139,65 @@ func matchedAbsoluteTimeSpec(t time.Time, match []string) (bool, error) {
 	ttu := timeTo.UnixMilli()
 	return tfu <= tu && tu <= ttu, nil
 }
+
+// TimeSpecBoundaries returns all points in time between t and t+horizon at which the result of MatchesTimeSpec
+// might change for the given spec. Recurring specs result in the start of the window and the first minute after
+// the window for each day, absolute specs result in the start of the range and the first second after the range.
+func TimeSpecBoundaries(t time.Time, spec string, horizon time.Duration) ([]time.Time, error) {
+	if strings.ToLower(spec) == "always" || strings.ToLower(spec) == "never" {
+		return nil, nil
+	}
+
+	end := t.Add(horizon)
+	var ret []time.Time
+	add := func(x time.Time) {
+		if !x.Before(t) && !x.After(end) {
+			ret = append(ret, x)
+		}
+	}
+
+	for _, spec_ := range strings.Split(spec, ",") {
+		spec_ = strings.TrimSpace(spec_)
+		recurringMatch := timeSpecPattern.FindStringSubmatch(spec_)
+		if recurringMatch != nil {
+			loc, err := timeSpecLocation(recurringMatch)
+			if err != nil {
+				return nil, err
+			}
+			minuteFrom, err := calcTimeSpecMinutes(recurringMatch, 3, 4)
+			if err != nil {
+				return nil, err
+			}
+			minuteTo, err := calcTimeSpecMinutes(recurringMatch, 5, 6)
+			if err != nil {
+				return nil, err
+			}
+			localTime := t.In(loc)
+			days := int(horizon/(time.Hour*24)) + 1
+			for i := 0; i <= days; i++ {
+				y, m, d := localTime.AddDate(0, 0, i).Date()
+				add(time.Date(y, m, d, 0, minuteFrom, 0, 0, loc))
+				add(time.Date(y, m, d, 0, minuteTo+1, 0, 0, loc))
+			}
+			continue
+		}
+
+		absolueMatch := absoluteTimeSpecPattern.FindStringSubmatch(spec_)
+		if absolueMatch != nil {
+			timeFrom, err := time.Parse(time.RFC3339, absolueMatch[1])
+			if err != nil {
+				return nil, err
+			}
+			timeTo, err := time.Parse(time.RFC3339, absolueMatch[2])
+			if err != nil {
+				return nil, err
+			}
+			add(timeFrom)
+			add(timeTo.Add(time.Second))
+			continue
+		}
+
+		return nil, fmt.Errorf(`time spec value "%s" does not match format ("Mon-Fri 06:30-20:30 Europe/Berlin" or "2019-01-01T00:00:00+00:00-2019-01-02T12:34:56+00:00")`, spec_)
+	}
+	return ret, nil
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestMatchesTimeSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	// 2023-06-05 is a Monday
+	mon10 := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+	sat10 := time.Date(2023, 6, 10, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		t     time.Time
+		spec  string
+		match bool
+	}{
+		{mon10, "always", true},
+		{mon10, "never", false},
+		{mon10, "Mon-Fri 08:00-16:00 UTC", true},
+		{mon10, "Mon-Fri 10:01-16:00 UTC", false},
+		{mon10, "Mon-Fri 08:00-10:00 UTC", true},
+		{sat10, "Mon-Fri 08:00-16:00 UTC", false},
+		{sat10, "Sun-Fri 08:00-16:00 UTC", false},
+		{sat10, "Fri-Sat 08:00-16:00 UTC", true},
+		{mon10, "Mon-Fri 08:00-16:00 Europe/Berlin", true},
+		{mon10, "Mon-Fri 12:30-16:00 Europe/Berlin", false},
+		{mon10, "2023-06-05T09:00:00+00:00-2023-06-05T11:00:00+00:00", true},
+		{mon10, "2023-06-05T11:00:00+00:00-2023-06-05T12:00:00+00:00", false},
+		{mon10, "Sat-Sun 08:00-16:00 UTC, 2023-06-05T09:00:00+00:00-2023-06-05T11:00:00+00:00", true},
+	}
+
+	for _, tc := range tests {
+		hit, err := MatchesTimeSpec(tc.t, tc.spec)
+		g.Expect(err).To(Succeed(), tc.spec)
+		g.Expect(hit).To(Equal(tc.match), tc.spec)
+	}
+
+	_, err := MatchesTimeSpec(mon10, "invalid")
+	g.Expect(err).To(HaveOccurred())
+	_, err = MatchesTimeSpec(mon10, "Mon-Xyz 08:00-16:00 UTC")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestNextDeployAllowedTime(t *testing.T) {
+	g := NewWithT(t)
+
+	// 2023-06-05 is a Monday
+	mon18 := time.Date(2023, 6, 5, 18, 0, 0, 0, time.UTC)
+
+	obj := &kluctlv1.KluctlDeployment{}
+	obj.Spec.DeployWindows = []string{"Mon-Fri 08:00-16:00 UTC"}
+
+	allowed, err := isDeployAllowed(obj, mon18)
+	g.Expect(err).To(Succeed())
+	g.Expect(allowed).To(BeFalse())
+
+	next, err := nextDeployAllowedTime(obj, mon18)
+	g.Expect(err).To(Succeed())
+	g.Expect(next).ToNot(BeNil())
+	g.Expect(*next).To(BeTemporally("==", time.Date(2023, 6, 6, 8, 0, 0, 0, time.UTC)))
+
+	// blackout on tuesday morning
+	obj.Spec.DeployBlackouts = []string{"2023-06-06T00:00:00+00:00-2023-06-06T11:59:59+00:00"}
+	next, err = nextDeployAllowedTime(obj, mon18)
+	g.Expect(err).To(Succeed())
+	g.Expect(next).ToNot(BeNil())
+	g.Expect(*next).To(BeTemporally("==", time.Date(2023, 6, 6, 12, 0, 0, 0, time.UTC)))
+
+	// blackout without windows
+	obj.Spec.DeployWindows = nil
+	obj.Spec.DeployBlackouts = []string{"Mon-Mon 17:00-19:29 UTC"}
+	next, err = nextDeployAllowedTime(obj, mon18)
+	g.Expect(err).To(Succeed())
+	g.Expect(next).ToNot(BeNil())
+	g.Expect(*next).To(BeTemporally("==", time.Date(2023, 6, 5, 19, 30, 0, 0, time.UTC)))
+
+	obj.Spec.DeployWindows = []string{"never"}
+	next, err = nextDeployAllowedTime(obj, mon18)
+	g.Expect(err).To(Succeed())
+	g.Expect(next).To(BeNil())
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/fluxcd/pkg/apis/meta"
 	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
@@ -17,11 +18,48 @@ func setReadiness(obj *kluctlv1.KluctlDeployment, status metav1.ConditionStatus,
 
 	c := obj.GetConditions()
 	apimeta.SetStatusCondition(&c, newCondition)
+	if status != metav1.ConditionUnknown {
+		// a final readiness state was determined, so we're not reconciling anymore
+		apimeta.RemoveStatusCondition(&c, meta.ReconcilingCondition)
+	}
+	if status == metav1.ConditionTrue {
+		apimeta.RemoveStatusCondition(&c, meta.StalledCondition)
+	}
 	obj.SetConditions(c)
 
 	obj.Status.ObservedGeneration = obj.GetGeneration()
 }
 
+// setReconciling marks the object as being reconciled, see https://github.com/kubernetes-sigs/cli-utils/tree/master/pkg/kstatus
+func setReconciling(obj *kluctlv1.KluctlDeployment, reason, message string) {
+	setCondition(obj, meta.ReconcilingCondition, metav1.ConditionTrue, reason, message)
+}
+
+// setStalled marks the object as stalled, meaning that reconciliation can not proceed without the spec or
+// environment being changed, see https://github.com/kubernetes-sigs/cli-utils/tree/master/pkg/kstatus
+func setStalled(obj *kluctlv1.KluctlDeployment, reason, message string) {
+	setCondition(obj, meta.StalledCondition, metav1.ConditionTrue, reason, message)
+}
+
+func setCondition(obj *kluctlv1.KluctlDeployment, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	newCondition := metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: trimString(message, kluctlv1.MaxConditionMessageLength),
+	}
+
+	c := obj.GetConditions()
+	apimeta.SetStatusCondition(&c, newCondition)
+	obj.SetConditions(c)
+}
+
+func removeCondition(obj *kluctlv1.KluctlDeployment, conditionType string) {
+	c := obj.GetConditions()
+	apimeta.RemoveStatusCondition(&c, conditionType)
+	obj.SetConditions(c)
+}
+
 func setReadinessWithRevision(obj *kluctlv1.KluctlDeployment, status metav1.ConditionStatus, reason, message string, revision string) {
 	setReadiness(obj, status, reason, message)
 	obj.Status.LastAttemptedRevision = revision
@@ -34,3 +72,25 @@ func trimString(str string, limit int) string {
 
 	return str[0:limit] + "..."
 }
+
+// nonRetryableError is returned for errors that can not be fixed by retrying, e.g. invalid specs
+type nonRetryableError struct {
+	err error
+}
+
+func newNonRetryableError(err error) error {
+	return &nonRetryableError{err: err}
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+func isNonRetryableError(err error) bool {
+	var e *nonRetryableError
+	return errors.As(err, &e)
+}
@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fluxcd/pkg/apis/meta"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	types2 "github.com/kluctl/kluctl/v2/pkg/types"
+	"io"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"net"
+	"net/http"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// +kubebuilder:rbac:groups=flux.kluctl.io,resources=kluctldeploymentgenerators,verbs=patch
+
+const webhookMaxPayloadSize = 10 * 1024 * 1024
+
+// WebhookReceiver is an optional HTTP server that receives push events from Git hosting providers and requests
+// reconciliation of all KluctlDeployments and KluctlDeploymentGenerators that use the pushed repository. It serves
+// the following paths:
+//
+//	/github  GitHub push events, validated via the X-Hub-Signature-256 header
+//	/gitea   Gitea push events, validated via the X-Gitea-Signature header
+//	/gitlab  GitLab push events, validated via the X-Gitlab-Token header
+//	/generic {"url": "...", "ref": "..."} payloads, validated via the X-Signature-256 header
+type WebhookReceiver struct {
+	client.Client
+
+	// Addr is the address the receiver binds to
+	Addr string
+	// Secret is the secret used to validate the HMAC signatures (or the token for GitLab) of incoming requests
+	Secret []byte
+}
+
+// pushEvent is the provider independent representation of a push
+type pushEvent struct {
+	urls []string
+	ref  string
+
+	// defaultBranch is the default branch of the repository, if known
+	defaultBranch string
+}
+
+type webhookProvider struct {
+	validate func(r *http.Request, body []byte, secret []byte) bool
+	// parse returns nil if the event is not a push event
+	parse func(r *http.Request, body []byte) (*pushEvent, error)
+}
+
+var webhookProviders = map[string]webhookProvider{
+	"github":  {validate: validateSignatureHeader("X-Hub-Signature-256", "sha256="), parse: parseGitHubPush},
+	"gitea":   {validate: validateSignatureHeader("X-Gitea-Signature", ""), parse: parseGiteaPush},
+	"gitlab":  {validate: validateGitLabToken, parse: parseGitLabPush},
+	"generic": {validate: validateSignatureHeader("X-Signature-256", "sha256="), parse: parseGenericPush},
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The receiver only sets annotations, so it can run
+// on all replicas.
+func (wr *WebhookReceiver) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable. It runs the HTTP server until the context is cancelled.
+func (wr *WebhookReceiver) Start(ctx context.Context) error {
+	log := ctrl.LoggerFrom(ctx).WithName("webhook-receiver")
+
+	mux := http.NewServeMux()
+	for name, p := range webhookProviders {
+		mux.Handle("/"+name, wr.handler(p))
+	}
+
+	srv := &http.Server{
+		Addr:              wr.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctrl.LoggerInto(context.Background(), log)
+		},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("starting webhook receiver", "addr", wr.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		err := <-errCh
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+func (wr *WebhookReceiver) handler(p webhookProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := ctrl.LoggerFrom(ctx).WithValues("path", r.URL.Path)
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxPayloadSize))
+		if err != nil {
+			http.Error(w, "failed to read payload", http.StatusBadRequest)
+			return
+		}
+
+		if !p.validate(r, body, wr.Secret) {
+			log.Info("rejected webhook with invalid signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		ev, err := p.parse(r, body)
+		if err != nil {
+			log.Info("rejected webhook with invalid payload", "error", err.Error())
+			http.Error(w, fmt.Sprintf("invalid payload: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if ev == nil {
+			// not a push event, e.g. a ping
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		n, err := wr.requestReconciliations(ctx, ev)
+		if err != nil {
+			log.Error(err, "failed to request reconciliations")
+			http.Error(w, "failed to request reconciliations", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("received push event", "urls", ev.urls, "ref", ev.ref, "reconciliations", n)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "requested %d reconciliations\n", n)
+	})
+}
+
+// requestReconciliations sets the reconcile annotation on all objects that are affected by the given push event
+// and returns the number of annotated objects.
+func (wr *WebhookReceiver) requestReconciliations(ctx context.Context, ev *pushEvent) (int, error) {
+	repoKeys := map[string]bool{}
+	for _, u := range ev.urls {
+		if key := normalizedRepoKey(u); key != "" {
+			repoKeys[key] = true
+		}
+	}
+	if len(repoKeys) == 0 {
+		return 0, nil
+	}
+
+	var kds kluctlv1.KluctlDeploymentList
+	if err := wr.List(ctx, &kds); err != nil {
+		return 0, err
+	}
+	var generators kluctlv1.KluctlDeploymentGeneratorList
+	if err := wr.List(ctx, &generators); err != nil {
+		return 0, err
+	}
+
+	var objs []client.Object
+	for i := range kds.Items {
+		kd := &kds.Items[i]
+		if kd.Spec.Source == nil || !repoKeys[normalizedRepoKey(kd.Spec.Source.URL)] {
+			continue
+		}
+		if !pushMatchesGitRef(ev, kd.Spec.Source.Ref) {
+			continue
+		}
+		objs = append(objs, kd)
+	}
+	for i := range generators.Items {
+		gen := &generators.Items[i]
+		// any push might add or remove branches and pull requests
+		if repoKeys[normalizedRepoKey(gen.Spec.Source.URL)] {
+			objs = append(objs, gen)
+		}
+	}
+
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	var errs []error
+	n := 0
+	for _, obj := range objs {
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		a := obj.GetAnnotations()
+		if a == nil {
+			a = map[string]string{}
+		}
+		a[meta.ReconcileRequestAnnotation] = requestedAt
+		obj.SetAnnotations(a)
+		if err := wr.Patch(ctx, obj, patch); err != nil {
+			errs = append(errs, fmt.Errorf("failed to annotate %s/%s: %w", obj.GetNamespace(), obj.GetName(), err))
+			continue
+		}
+		n++
+	}
+	return n, utilerrors.NewAggregate(errs)
+}
+
+func normalizedRepoKey(u string) string {
+	gitUrl, err := types2.ParseGitUrl(u)
+	if err != nil {
+		return ""
+	}
+	return gitUrl.NormalizedRepoKey()
+}
+
+// pushMatchesGitRef checks if the pushed ref might change the result of resolving the given GitRef. Branch and tag
+// patterns are matched the same way as in resolveGitRef.
+func pushMatchesGitRef(ev *pushEvent, ref *kluctlv1.GitRef) bool {
+	if ref != nil && ref.Commit != "" {
+		// pinned to a commit
+		return false
+	}
+
+	if strings.HasPrefix(ev.ref, "refs/tags/") {
+		tag := strings.TrimPrefix(ev.ref, "refs/tags/")
+		if ref == nil {
+			return false
+		}
+		if ref.SemVer != "" {
+			_, err := findHighestSemVer(ref.SemVer, []string{tag})
+			return err == nil
+		}
+		if ref.Tag != "" {
+			return refPatternMatches(ref.Tag, tag)
+		}
+		return false
+	}
+
+	if strings.HasPrefix(ev.ref, "refs/heads/") {
+		branch := strings.TrimPrefix(ev.ref, "refs/heads/")
+		if ref == nil || (ref.SemVer == "" && ref.Tag == "" && ref.Branch == "") {
+			// the default branch is used
+			return ev.defaultBranch == "" || ev.defaultBranch == branch
+		}
+		if ref.SemVer == "" && ref.Tag == "" {
+			return refPatternMatches(ref.Branch, branch)
+		}
+		return false
+	}
+
+	return false
+}
+
+func refPatternMatches(pattern string, name string) bool {
+	if pattern == name {
+		return true
+	}
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
+
+func computeHmac(body []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// validateSignatureHeader returns a validation function that checks the hex encoded HMAC-SHA256 signature found in
+// the given header.
+func validateSignatureHeader(header string, prefix string) func(r *http.Request, body []byte, secret []byte) bool {
+	return func(r *http.Request, body []byte, secret []byte) bool {
+		sig := r.Header.Get(header)
+		if !strings.HasPrefix(sig, prefix) {
+			return false
+		}
+		b, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+		if err != nil {
+			return false
+		}
+		return hmac.Equal(b, computeHmac(body, secret))
+	}
+}
+
+// validateGitLabToken checks the X-Gitlab-Token header, as GitLab does not support signing payloads
+func validateGitLabToken(r *http.Request, body []byte, secret []byte) bool {
+	token := r.Header.Get("X-Gitlab-Token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), secret) == 1
+}
+
+type gitHubPushPayload struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		CloneUrl      string `json:"clone_url"`
+		SshUrl        string `json:"ssh_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+func (p *gitHubPushPayload) toPushEvent() (*pushEvent, error) {
+	if p.Ref == "" || (p.Repository.CloneUrl == "" && p.Repository.SshUrl == "") {
+		return nil, fmt.Errorf("ref or repository urls missing")
+	}
+	return &pushEvent{
+		urls:          nonEmptyStrings(p.Repository.CloneUrl, p.Repository.SshUrl),
+		ref:           p.Ref,
+		defaultBranch: p.Repository.DefaultBranch,
+	}, nil
+}
+
+func parseGitHubPush(r *http.Request, body []byte) (*pushEvent, error) {
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		return nil, nil
+	}
+	var p gitHubPushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	return p.toPushEvent()
+}
+
+// parseGiteaPush parses Gitea push events, which use the same payload format as GitHub
+func parseGiteaPush(r *http.Request, body []byte) (*pushEvent, error) {
+	if r.Header.Get("X-Gitea-Event") != "push" {
+		return nil, nil
+	}
+	var p gitHubPushPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	return p.toPushEvent()
+}
+
+func parseGitLabPush(r *http.Request, body []byte) (*pushEvent, error) {
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Push Hook", "Tag Push Hook":
+	default:
+		return nil, nil
+	}
+	var p struct {
+		Ref     string `json:"ref"`
+		Project struct {
+			GitHttpUrl    string `json:"git_http_url"`
+			GitSshUrl     string `json:"git_ssh_url"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.Ref == "" || (p.Project.GitHttpUrl == "" && p.Project.GitSshUrl == "") {
+		return nil, fmt.Errorf("ref or project urls missing")
+	}
+	return &pushEvent{
+		urls:          nonEmptyStrings(p.Project.GitHttpUrl, p.Project.GitSshUrl),
+		ref:           p.Ref,
+		defaultBranch: p.Project.DefaultBranch,
+	}, nil
+}
+
+func parseGenericPush(r *http.Request, body []byte) (*pushEvent, error) {
+	var p struct {
+		Url string `json:"url"`
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.Url == "" || p.Ref == "" {
+		return nil, fmt.Errorf("url or ref missing")
+	}
+	return &pushEvent{
+		urls: []string{p.Url},
+		ref:  p.Ref,
+	}, nil
+}
+
+func nonEmptyStrings(s ...string) []string {
+	var ret []string
+	for _, x := range s {
+		if x != "" {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"encoding/hex"
+	kluctlv1 "github.com/kluctl/flux-kluctl-controller/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookValidateSignature(t *testing.T) {
+	g := NewWithT(t)
+
+	secret := []byte("secret")
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	sig := hex.EncodeToString(computeHmac(body, secret))
+
+	newRequest := func(header string, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+
+	github := webhookProviders["github"].validate
+	g.Expect(github(newRequest("X-Hub-Signature-256", "sha256="+sig), body, secret)).To(BeTrue())
+	g.Expect(github(newRequest("X-Hub-Signature-256", sig), body, secret)).To(BeFalse())
+	g.Expect(github(newRequest("X-Hub-Signature-256", "sha256="+sig), body, []byte("other"))).To(BeFalse())
+	g.Expect(github(newRequest("X-Hub-Signature-256", "sha256=zz"), body, secret)).To(BeFalse())
+	g.Expect(github(newRequest("", ""), body, secret)).To(BeFalse())
+
+	gitea := webhookProviders["gitea"].validate
+	g.Expect(gitea(newRequest("X-Gitea-Signature", sig), body, secret)).To(BeTrue())
+	g.Expect(gitea(newRequest("X-Gitea-Signature", sig), []byte("modified"), secret)).To(BeFalse())
+
+	generic := webhookProviders["generic"].validate
+	g.Expect(generic(newRequest("X-Signature-256", "sha256="+sig), body, secret)).To(BeTrue())
+	g.Expect(generic(newRequest("X-Hub-Signature-256", "sha256="+sig), body, secret)).To(BeFalse())
+
+	gitlab := webhookProviders["gitlab"].validate
+	g.Expect(gitlab(newRequest("X-Gitlab-Token", "secret"), body, secret)).To(BeTrue())
+	g.Expect(gitlab(newRequest("X-Gitlab-Token", "wrong"), body, secret)).To(BeFalse())
+	g.Expect(gitlab(newRequest("", ""), body, secret)).To(BeFalse())
+}
+
+func TestWebhookParsePush(t *testing.T) {
+	g := NewWithT(t)
+
+	parse := func(provider string, header string, event string, body string) (*pushEvent, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if header != "" {
+			r.Header.Set(header, event)
+		}
+		return webhookProviders[provider].parse(r, []byte(body))
+	}
+
+	githubBody := `{"ref":"refs/heads/main","repository":{"clone_url":"https://github.com/org/repo.git","ssh_url":"git@github.com:org/repo.git","default_branch":"main"}}`
+	ev, err := parse("github", "X-GitHub-Event", "push", githubBody)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev).To(Equal(&pushEvent{
+		urls:          []string{"https://github.com/org/repo.git", "git@github.com:org/repo.git"},
+		ref:           "refs/heads/main",
+		defaultBranch: "main",
+	}))
+
+	ev, err = parse("github", "X-GitHub-Event", "ping", `{"zen":"..."}`)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev).To(BeNil())
+
+	_, err = parse("github", "X-GitHub-Event", "push", `{"ref":"refs/heads/main"}`)
+	g.Expect(err).To(HaveOccurred())
+
+	ev, err = parse("gitea", "X-Gitea-Event", "push", githubBody)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev.ref).To(Equal("refs/heads/main"))
+
+	ev, err = parse("gitlab", "X-Gitlab-Event", "Tag Push Hook", `{"ref":"refs/tags/v1.0.0","project":{"git_http_url":"https://gitlab.com/org/repo.git","git_ssh_url":"git@gitlab.com:org/repo.git","default_branch":"main"}}`)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev).To(Equal(&pushEvent{
+		urls:          []string{"https://gitlab.com/org/repo.git", "git@gitlab.com:org/repo.git"},
+		ref:           "refs/tags/v1.0.0",
+		defaultBranch: "main",
+	}))
+
+	ev, err = parse("gitlab", "X-Gitlab-Event", "Issue Hook", `{}`)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev).To(BeNil())
+
+	ev, err = parse("generic", "", "", `{"url":"https://example.com/repo.git","ref":"refs/heads/dev"}`)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ev).To(Equal(&pushEvent{
+		urls: []string{"https://example.com/repo.git"},
+		ref:  "refs/heads/dev",
+	}))
+
+	_, err = parse("generic", "", "", `{"url":"https://example.com/repo.git"}`)
+	g.Expect(err).To(HaveOccurred())
+	_, err = parse("generic", "", "", `not-json`)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestPushMatchesGitRef(t *testing.T) {
+	g := NewWithT(t)
+
+	branchPush := &pushEvent{ref: "refs/heads/release-1", defaultBranch: "main"}
+	mainPush := &pushEvent{ref: "refs/heads/main", defaultBranch: "main"}
+	unknownDefaultPush := &pushEvent{ref: "refs/heads/release-1"}
+	tagPush := &pushEvent{ref: "refs/tags/v1.2.0"}
+
+	g.Expect(pushMatchesGitRef(mainPush, nil)).To(BeTrue())
+	g.Expect(pushMatchesGitRef(branchPush, nil)).To(BeFalse())
+	g.Expect(pushMatchesGitRef(unknownDefaultPush, nil)).To(BeTrue())
+	g.Expect(pushMatchesGitRef(tagPush, nil)).To(BeFalse())
+
+	g.Expect(pushMatchesGitRef(branchPush, &kluctlv1.GitRef{Branch: "release-1"})).To(BeTrue())
+	g.Expect(pushMatchesGitRef(branchPush, &kluctlv1.GitRef{Branch: "release-.*"})).To(BeTrue())
+	g.Expect(pushMatchesGitRef(branchPush, &kluctlv1.GitRef{Branch: "release"})).To(BeFalse())
+	g.Expect(pushMatchesGitRef(mainPush, &kluctlv1.GitRef{Branch: "release-.*"})).To(BeFalse())
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{Branch: "v1.2.0"})).To(BeFalse())
+
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{Tag: "v1.2.0"})).To(BeTrue())
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{Tag: "v1\\..*"})).To(BeTrue())
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{Tag: "v2\\..*"})).To(BeFalse())
+	g.Expect(pushMatchesGitRef(branchPush, &kluctlv1.GitRef{Tag: "release-1"})).To(BeFalse())
+
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{SemVer: ">=1.0.0 <2.0.0"})).To(BeTrue())
+	g.Expect(pushMatchesGitRef(tagPush, &kluctlv1.GitRef{SemVer: ">=2.0.0"})).To(BeFalse())
+
+	g.Expect(pushMatchesGitRef(branchPush, &kluctlv1.GitRef{Branch: "release-1", Commit: "0123"})).To(BeFalse())
+}
@@ -17,10 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	ssh_pool "github.com/kluctl/kluctl/v2/pkg/git/ssh-pool"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
 	"os"
+	"path/filepath"
+	"time"
 
 	helper "github.com/fluxcd/pkg/runtime/controller"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -33,6 +38,7 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/fluxcd/pkg/runtime/acl"
 	"github.com/fluxcd/pkg/runtime/client"
@@ -75,8 +81,13 @@ func main() {
 		aclOptions            acl.Options
 		watchAllNamespaces    bool
 		httpRetry             int
+		requeueDependency     time.Duration
 		defaultServiceAccount string
 		dryRun                bool
+		webhookAddr           string
+		webhookSecretFile     string
+		repoCacheDir          string
+		repoCacheMaxSize      string
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
@@ -85,8 +96,15 @@ func main() {
 	flag.BoolVar(&watchAllNamespaces, "watch-all-namespaces", true,
 		"Watch for custom resources in all namespaces, if set to false it will only watch the runtime namespace.")
 	flag.IntVar(&httpRetry, "http-retry", 9, "The maximum number of retries when failing to fetch artifacts over HTTP.")
+	flag.DurationVar(&requeueDependency, "requeue-dependency", 30*time.Second, "The interval at which failing dependencies are reevaluated.")
 	flag.StringVar(&defaultServiceAccount, "default-service-account", "", "Default service account used for impersonation.")
 	flag.BoolVar(&dryRun, "dry-run", false, "Run all deployments in dryRun=true mode.")
+	flag.StringVar(&webhookAddr, "webhook-addr", "", "The address the webhook receiver binds to. The receiver is disabled when empty.")
+	flag.StringVar(&repoCacheDir, "repo-cache-dir", filepath.Join(os.TempDir(), "kluctl-controller-repo-cache"),
+		"The directory used to cache git repositories. Can be put on a persistent volume to survive restarts.")
+	flag.StringVar(&repoCacheMaxSize, "repo-cache-max-size", "10Gi",
+		"The maximum size of the git repository cache. Least recently used repositories are evicted when exceeded. 0 disables eviction.")
+	flag.StringVar(&webhookSecretFile, "webhook-secret-file", "", "The file containing the secret used to validate incoming webhooks.")
 
 	clientOptions.BindFlags(flag.CommandLine)
 	logOptions.BindFlags(flag.CommandLine)
@@ -121,6 +139,12 @@ func main() {
 		LeaderElectionID:              fmt.Sprintf("%s-leader-election", controllerName),
 		Namespace:                     watchNamespace,
 		Logger:                        ctrl.Log,
+		// ConfigMaps and Secrets are read directly, so that only their metadata is cached for the argsFrom watches
+		Client: ctrlclient.Options{
+			Cache: &ctrlclient.CacheOptions{
+				DisableFor: []ctrlclient.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -135,6 +159,19 @@ func main() {
 	metricsH := helper.MustMakeMetrics(mgr)
 	sshPool := &ssh_pool.SshPool{}
 
+	maxSize, err := resource.ParseQuantity(repoCacheMaxSize)
+	if err != nil {
+		setupLog.Error(err, "invalid repo cache max size")
+		os.Exit(1)
+	}
+	ctx := ctrl.SetupSignalHandler()
+
+	repoCache, err := controllers.NewRepoCache(ctx, repoCacheDir, maxSize.Value(), sshPool)
+	if err != nil {
+		setupLog.Error(err, "unable to create repo cache")
+		os.Exit(1)
+	}
+
 	r := controllers.KluctlDeploymentReconciler{
 		ControllerName:        controllerName,
 		DefaultServiceAccount: defaultServiceAccount,
@@ -146,20 +183,57 @@ func main() {
 		EventRecorder:         eventRecorder,
 		MetricsRecorder:       metricsH.MetricsRecorder,
 		NoCrossNamespaceRefs:  aclOptions.NoCrossNamespaceRefs,
-		SshPool:               sshPool,
+		RepoCache:             repoCache,
 	}
 
 	if err = r.SetupWithManager(mgr, controllers.KluctlDeploymentReconcilerOpts{
-		MaxConcurrentReconciles: concurrent,
-		HTTPRetry:               httpRetry,
+		MaxConcurrentReconciles:   concurrent,
+		HTTPRetry:                 httpRetry,
+		DependencyRequeueInterval: requeueDependency,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", kluctliov1alpha1.KluctlDeploymentKind)
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	gr := controllers.KluctlDeploymentGeneratorReconciler{
+		ControllerName: controllerName,
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		EventRecorder:  eventRecorder,
+		RepoCache:      repoCache,
+	}
+
+	if err = gr.SetupWithManager(mgr, controllers.KluctlDeploymentReconcilerOpts{
+		MaxConcurrentReconciles: concurrent,
+	}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", kluctliov1alpha1.KluctlDeploymentGeneratorKind)
+		os.Exit(1)
+	}
+
+	if webhookAddr != "" {
+		secret, err := os.ReadFile(webhookSecretFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read webhook secret")
+			os.Exit(1)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			setupLog.Error(fmt.Errorf("webhook secret is empty"), "unable to start webhook receiver")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controllers.WebhookReceiver{
+			Client: mgr.GetClient(),
+			Addr:   webhookAddr,
+			Secret: secret,
+		}); err != nil {
+			setupLog.Error(err, "unable to create webhook receiver")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}